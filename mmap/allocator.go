@@ -0,0 +1,308 @@
+package mmap
+
+import (
+	"encoding/binary"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+// Allocator format.
+const (
+	allocatorMagic   = 0x434f4c41 // "ALOC"
+	allocatorVersion = 1
+)
+
+// Allocator header layout.
+// All offsets inside allocator are relative to allocator region.
+const (
+	allocatorMagicOffset     = 0
+	allocatorVersionOffset   = 4
+	allocatorSizeOffset      = 8
+	allocatorLockOffset      = 16
+	allocatorTopOffset       = 24
+	allocatorLargeFreeOffset = 32
+	allocatorClassFreeOffset = 40
+	allocatorHeaderSize      = 2 * cacheLineSize
+)
+
+// Allocator block layout.
+// Link is the next free block of the same list or marker of allocated block.
+const (
+	blockSizeOffset   = 0
+	blockLinkOffset   = 8
+	blockHeaderSize   = 16
+	blockAlignment    = 16
+	blockAllocated    = 0xa110ca7eda110ca7
+	allocatorMinimum  = allocatorHeaderSize + 4*cacheLineSize
+	allocatorMaxClass = 2048
+)
+
+// Size classes of small blocks payload.
+var allocatorClasses = [...]uint64{16, 32, 64, 128, 256, 512, 1024, allocatorMaxClass}
+
+// Relative pointer, offset of allocated block in mapping.
+// Pointer is valid in every process mapping the same memory regardless of base address.
+type Pointer syspack.Offset
+
+// Nil pointer.
+const NilPointer = Pointer(0)
+
+// Whether is pointer nil.
+func (pointer Pointer) IsNil() bool {
+	return pointer == NilPointer
+}
+
+// Get pointer offset in mapping.
+func (pointer Pointer) Offset() syspack.Offset {
+	return syspack.Offset(pointer)
+}
+
+// Get pointer moved by given delta.
+func (pointer Pointer) Add(delta syspack.Offset) Pointer {
+	return pointer + Pointer(delta)
+}
+
+// Resolve pointer against mapping to byte slice of given length.
+func (pointer Pointer) Resolve(mapping *Mapping, length syspack.Size) ([]byte, error) {
+	if pointer.IsNil() {
+		return nil, &ErrorInvalidOffset{Offset: pointer.Offset()}
+	}
+	return mapping.Direct(pointer.Offset(), pointer.Offset()+syspack.Offset(length))
+}
+
+type Allocator struct {
+	// Allocator of blocks inside mapping region.
+	// Small blocks are taken from per size class free lists, large ones from first-fit free list.
+	// All metadata is stored in mapping, so allocator may be shared between processes.
+
+	// Mapping.
+	mapping *Mapping
+
+	// Region offset in mapping.
+	offset syspack.Offset
+
+	// Region data.
+	data []byte
+
+	// Allocator lock.
+	mutex sharedMutex
+}
+
+// Make new allocator managing mapping region of given size at given offset.
+func NewAllocator(mapping *Mapping, offset syspack.Offset, size syspack.Size) (*Allocator, error) {
+	if !mapping.CanWrite() {
+		return nil, &ErrorNotAllowed{Operation: "write"}
+	}
+	allocator, err := openAllocator(mapping, offset, size)
+	if err != nil {
+		return nil, err
+	}
+	header := allocator.data[:allocatorHeaderSize]
+	for i := range header {
+		header[i] = 0
+	}
+	allocator.setUint64(allocatorSizeOffset, uint64(size))
+	allocator.setUint64(allocatorTopOffset, allocatorHeaderSize)
+	if err := mapping.StoreUint32At(allocatorVersion, offset+allocatorVersionOffset); err != nil {
+		return nil, err
+	}
+	if err := mapping.StoreUint32At(allocatorMagic, offset+allocatorMagicOffset); err != nil {
+		return nil, err
+	}
+	return allocator, nil
+}
+
+// Attach to allocator existing in mapping at given offset.
+func AttachAllocator(mapping *Mapping, offset syspack.Offset) (*Allocator, error) {
+	magic, err := mapping.LoadUint32At(offset + allocatorMagicOffset)
+	if err != nil {
+		return nil, err
+	}
+	if magic != allocatorMagic {
+		return nil, &ErrorBadMagic{Magic: magic}
+	}
+	version, err := mapping.LoadUint32At(offset + allocatorVersionOffset)
+	if err != nil {
+		return nil, err
+	}
+	if version != allocatorVersion {
+		return nil, &ErrorVersionMismatch{Version: version, Expected: allocatorVersion}
+	}
+	size, err := mapping.LoadUint64At(offset + allocatorSizeOffset)
+	if err != nil {
+		return nil, err
+	}
+	if size > uint64(syspack.MaxInt) {
+		return nil, &ErrorInvalidSize{Size: syspack.MaxSize}
+	}
+	return openAllocator(mapping, offset, syspack.Size(size))
+}
+
+// Open allocator in mapping at given offset.
+func openAllocator(mapping *Mapping, offset syspack.Offset, size syspack.Size) (*Allocator, error) {
+	if size < allocatorMinimum || size > syspack.Size(syspack.MaxInt) {
+		return nil, &ErrorInvalidSize{Size: size}
+	}
+	if offset%blockAlignment != 0 {
+		return nil, &ErrorUnalignedOffset{Offset: offset}
+	}
+	data, err := mapping.Direct(offset, offset+syspack.Offset(size))
+	if err != nil {
+		return nil, err
+	}
+	lock, err := mapping.writableWord32(offset + allocatorLockOffset)
+	if err != nil {
+		return nil, err
+	}
+	return &Allocator{
+		mapping: mapping,
+		offset:  offset,
+		data:    data,
+		mutex:   sharedMutex{state: lock},
+	}, nil
+}
+
+// Get 64-bit value at given region offset.
+func (allocator *Allocator) uint64At(offset uint64) uint64 {
+	return binary.LittleEndian.Uint64(allocator.data[offset:])
+}
+
+// Set 64-bit value at given region offset.
+func (allocator *Allocator) setUint64(offset uint64, value uint64) {
+	binary.LittleEndian.PutUint64(allocator.data[offset:], value)
+	low := allocator.offset + syspack.Offset(offset)
+	allocator.mapping.markDirty(low, low+8)
+}
+
+// Get size class index of payload size.
+func allocatorClass(size uint64) int {
+	for class, classSize := range allocatorClasses {
+		if size <= classSize {
+			return class
+		}
+	}
+	return -1
+}
+
+// Allocate block of given size.
+func (allocator *Allocator) Alloc(size syspack.Size) (Pointer, error) {
+	if size == 0 || size > syspack.Size(len(allocator.data)) {
+		return NilPointer, &ErrorInvalidSize{Size: size}
+	}
+	payload := (uint64(size) + blockAlignment - 1) &^ (blockAlignment - 1)
+	allocator.mutex.Lock()
+	defer allocator.mutex.Unlock()
+	var block uint64
+	if class := allocatorClass(payload); class >= 0 {
+		payload = allocatorClasses[class]
+		block = allocator.popFree(allocatorClassFreeOffset + 8*uint64(class))
+	} else {
+		block = allocator.takeLarge(payload + blockHeaderSize)
+	}
+	if block == 0 {
+		top := allocator.uint64At(allocatorTopOffset)
+		if top+blockHeaderSize+payload > uint64(len(allocator.data)) {
+			return NilPointer, &ErrorNoSpace{Size: size}
+		}
+		block = top
+		allocator.setUint64(allocatorTopOffset, top+blockHeaderSize+payload)
+		allocator.setUint64(block+blockSizeOffset, blockHeaderSize+payload)
+	}
+	allocator.setUint64(block+blockLinkOffset, blockAllocated)
+	return Pointer(allocator.offset) + Pointer(block+blockHeaderSize), nil
+}
+
+// Pop block from free list with head at given region offset.
+func (allocator *Allocator) popFree(head uint64) uint64 {
+	block := allocator.uint64At(head)
+	if block != 0 {
+		allocator.setUint64(head, allocator.uint64At(block+blockLinkOffset))
+	}
+	return block
+}
+
+// Take first large free block fitting given size, splitting it if remainder is large too.
+func (allocator *Allocator) takeLarge(size uint64) uint64 {
+	previous := uint64(allocatorLargeFreeOffset)
+	for block := allocator.uint64At(previous); block != 0; block = allocator.uint64At(block + blockLinkOffset) {
+		blockSize := allocator.uint64At(block + blockSizeOffset)
+		if blockSize < size {
+			previous = block + blockLinkOffset
+			continue
+		}
+		next := allocator.uint64At(block + blockLinkOffset)
+		if rest := blockSize - size; rest > blockHeaderSize+allocatorMaxClass {
+			allocator.setUint64(block+blockSizeOffset, size)
+			allocator.setUint64(block+size+blockSizeOffset, rest)
+			allocator.setUint64(block+size+blockLinkOffset, next)
+			next = block + size
+		}
+		allocator.setUint64(previous, next)
+		return block
+	}
+	return 0
+}
+
+// Get block of allocated pointer.
+func (allocator *Allocator) block(pointer Pointer) (uint64, error) {
+	relative := pointer.Offset() - allocator.offset - blockHeaderSize
+	if relative < allocatorHeaderSize || relative%blockAlignment != 0 ||
+		uint64(relative) >= allocator.uint64At(allocatorTopOffset) {
+		return 0, &ErrorInvalidOffset{Offset: pointer.Offset()}
+	}
+	block := uint64(relative)
+	if allocator.uint64At(block+blockLinkOffset) != blockAllocated {
+		return 0, &ErrorInvalidOffset{Offset: pointer.Offset()}
+	}
+	return block, nil
+}
+
+// Free allocated block.
+func (allocator *Allocator) Free(pointer Pointer) error {
+	allocator.mutex.Lock()
+	defer allocator.mutex.Unlock()
+	block, err := allocator.block(pointer)
+	if err != nil {
+		return err
+	}
+	size := allocator.uint64At(block + blockSizeOffset)
+	if block+size == allocator.uint64At(allocatorTopOffset) {
+		allocator.setUint64(block+blockLinkOffset, 0)
+		allocator.setUint64(allocatorTopOffset, block)
+		return nil
+	}
+	head := uint64(allocatorLargeFreeOffset)
+	if class := allocatorClass(size - blockHeaderSize); class >= 0 {
+		head = allocatorClassFreeOffset + 8*uint64(class)
+	}
+	allocator.setUint64(block+blockLinkOffset, allocator.uint64At(head))
+	allocator.setUint64(head, block)
+	return nil
+}
+
+// Get usable size of allocated block.
+func (allocator *Allocator) SizeOf(pointer Pointer) (syspack.Size, error) {
+	allocator.mutex.Lock()
+	defer allocator.mutex.Unlock()
+	block, err := allocator.block(pointer)
+	if err != nil {
+		return 0, err
+	}
+	return syspack.Size(allocator.uint64At(block+blockSizeOffset) - blockHeaderSize), nil
+}
+
+// Get byte slice of allocated block.
+func (allocator *Allocator) Bytes(pointer Pointer) ([]byte, error) {
+	size, err := allocator.SizeOf(pointer)
+	if err != nil {
+		return nil, err
+	}
+	return pointer.Resolve(allocator.mapping, size)
+}
+
+// Get number of bytes never allocated at the end of region.
+func (allocator *Allocator) Unused() syspack.Size {
+	allocator.mutex.Lock()
+	defer allocator.mutex.Unlock()
+	return syspack.Size(uint64(len(allocator.data)) - allocator.uint64At(allocatorTopOffset))
+}
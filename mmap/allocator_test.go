@@ -0,0 +1,72 @@
+package mmap
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+func TestAllocator(t *testing.T) {
+	mapping, err := makeTestMapping(ModeReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mapping.Close()
+	allocator, err := NewAllocator(mapping, 64, testLength-64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pointers := make([]Pointer, 100)
+	for i := range pointers {
+		message := makeTestMessage(i * 97)
+		if pointers[i], err = allocator.Alloc(syspack.Len(message) + 1); err != nil {
+			t.Fatal(err)
+		}
+		buffer, err := allocator.Bytes(pointers[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		copy(buffer, message)
+	}
+	unused := allocator.Unused()
+	attached, err := AttachAllocator(mapping, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, pointer := range pointers {
+		message := makeTestMessage(i * 97)
+		buffer, err := pointer.Resolve(mapping, syspack.Len(message)+1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Compare(buffer[:len(message)], message) != 0 {
+			t.Fatalf("block %d must be a %v, %v found", i, message, buffer)
+		}
+		if err := attached.Free(pointer); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := attached.Free(pointers[0]); err == nil {
+		t.Fatal("expected invalid offset, no error found")
+	} else if _, ok := err.(*ErrorInvalidOffset); !ok {
+		t.Fatalf("expected invalid offset, [%v] error found", err)
+	}
+	for i := range pointers {
+		message := makeTestMessage(i * 97)
+		if _, err := allocator.Alloc(syspack.Len(message) + 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if allocator.Unused() != unused {
+		t.Fatalf("free blocks must be reused, %d bytes of %d left", allocator.Unused(), unused)
+	}
+	if _, err := allocator.Alloc(testLength / 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := allocator.Alloc(testLength / 2); err == nil {
+		t.Fatal("expected no space, no error found")
+	} else if _, ok := err.(*ErrorNoSpace); !ok {
+		t.Fatalf("expected no space, [%v] error found", err)
+	}
+}
@@ -0,0 +1,220 @@
+package mmap
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+// Default arena chunk size.
+const defaultArenaChunkSize = 1 << 20
+
+// Huge page size.
+const hugePageSize = 1 << 21
+
+type ArenaOptions struct {
+	// Arena options.
+
+	// Chunk size, rounded up to page size.
+	ChunkSize syspack.Size
+
+	// Place chunks on huge pages.
+	// Explicit huge pages are tried first, transparent ones are used as fallback.
+	HugePages bool
+}
+
+type ArenaStats struct {
+	// Arena usage statistics.
+
+	// Number of chunks.
+	Chunks int
+
+	// Bytes mapped by chunks.
+	Reserved syspack.Size
+
+	// Bytes allocated, including alignment padding.
+	Used syspack.Size
+
+	// Number of allocations.
+	Allocations uint64
+}
+
+type arenaChunk struct {
+	// Arena chunk.
+
+	// Mapped address.
+	address uintptr
+
+	// Data.
+	data []byte
+
+	// Allocated bytes.
+	used int
+}
+
+type Arena struct {
+	// Off-heap allocator carving memory out of anonymous mappings.
+	// Allocated memory is invisible to garbage collector, so it must not contain Go pointers.
+	// Arena is not safe for concurrent use and must be freed explicitly.
+
+	// Chunk size.
+	chunkSize syspack.Size
+
+	// Use huge pages.
+	hugePages bool
+
+	// Chunks.
+	chunks []*arenaChunk
+
+	// Index of the first chunk allocated from.
+	current int
+
+	// Number of allocations.
+	allocations uint64
+}
+
+// Make new arena.
+func NewArena(options *ArenaOptions) (*Arena, error) {
+	arena := &Arena{chunkSize: defaultArenaChunkSize}
+	if options != nil {
+		if options.ChunkSize > syspack.Size(syspack.MaxInt) {
+			return nil, &ErrorInvalidSize{Size: options.ChunkSize}
+		}
+		if options.ChunkSize > 0 {
+			arena.chunkSize = options.ChunkSize
+		}
+		arena.hugePages = options.HugePages
+	}
+	arena.chunkSize = arena.roundSize(arena.chunkSize)
+	return arena, nil
+}
+
+// Round size up to page size.
+func (arena *Arena) roundSize(size syspack.Size) syspack.Size {
+	pageSize := syspack.Size(os.Getpagesize())
+	if arena.hugePages {
+		pageSize = hugePageSize
+	}
+	return (size + pageSize - 1) &^ (pageSize - 1)
+}
+
+// Map new chunk of given size.
+func (arena *Arena) mapChunk(size syspack.Size) (*arenaChunk, error) {
+	protection := syscall.PROT_READ | syscall.PROT_WRITE
+	flags := syscall.MAP_PRIVATE | syscall.MAP_ANONYMOUS
+	if arena.hugePages {
+		address, err := syspack.Mmap(0, size, protection, flags|syscall.MAP_HUGETLB, ^uintptr(0), 0)
+		if err == nil {
+			return &arenaChunk{address: address, data: makeSlice(address, int(size))}, nil
+		}
+	}
+	address, err := syspack.MmapE(0, size, protection, flags, ^uintptr(0), 0)
+	if err != nil {
+		return nil, err
+	}
+	if arena.hugePages {
+		// Transparent huge pages may be disabled, so advice is not mandatory.
+		syspack.Madvise(address, size, syspack.MadvHugepage)
+	}
+	return &arenaChunk{address: address, data: makeSlice(address, int(size))}, nil
+}
+
+// Allocate zeroed memory of given size and alignment.
+// Alignment must be a power of two.
+func (arena *Arena) Alloc(size, alignment syspack.Size) (unsafe.Pointer, error) {
+	data, err := arena.alloc(size, alignment)
+	if err != nil {
+		return nil, err
+	}
+	return unsafe.Pointer(&data[0]), nil
+}
+
+// Allocate zeroed byte slice of given length.
+func (arena *Arena) Bytes(length syspack.Size) ([]byte, error) {
+	return arena.alloc(length, 1)
+}
+
+// Allocate zeroed memory of given size and alignment.
+func (arena *Arena) alloc(size, alignment syspack.Size) ([]byte, error) {
+	if size == 0 || size > syspack.Size(syspack.MaxInt) {
+		return nil, &ErrorInvalidSize{Size: size}
+	}
+	if alignment == 0 || alignment&(alignment-1) != 0 || alignment > syspack.Size(os.Getpagesize()) {
+		return nil, &ErrorInvalidSize{Size: alignment}
+	}
+	for i := arena.current; i < len(arena.chunks); i++ {
+		if data := arena.chunks[i].carve(int(size), int(alignment)); data != nil {
+			arena.allocations++
+			return data, nil
+		}
+	}
+	// Oversized request gets dedicated chunk, current one is still allocated from.
+	chunkSize := arena.chunkSize
+	if size > chunkSize {
+		chunkSize = arena.roundSize(size)
+	}
+	chunk, err := arena.mapChunk(chunkSize)
+	if err != nil {
+		return nil, err
+	}
+	arena.chunks = append(arena.chunks, chunk)
+	if chunkSize == arena.chunkSize {
+		arena.current = len(arena.chunks) - 1
+	}
+	arena.allocations++
+	return chunk.carve(int(size), int(alignment)), nil
+}
+
+// Carve memory of given size and alignment from chunk.
+func (chunk *arenaChunk) carve(size, alignment int) []byte {
+	start := (int(chunk.address)+chunk.used+alignment-1)&^(alignment-1) - int(chunk.address)
+	if start+size > len(chunk.data) {
+		return nil
+	}
+	chunk.used = start + size
+	return chunk.data[start:chunk.used:chunk.used]
+}
+
+// Reset arena, releasing physical memory but keeping chunks mapped.
+// All memory allocated before is zeroed and reused.
+func (arena *Arena) Reset() error {
+	for _, chunk := range arena.chunks {
+		if chunk.used == 0 {
+			continue
+		}
+		if err := syspack.MadviseE(chunk.address, syspack.Size(len(chunk.data)), syspack.MadvDontneed); err != nil {
+			return err
+		}
+		chunk.used = 0
+	}
+	arena.current = 0
+	arena.allocations = 0
+	return nil
+}
+
+// Free arena, unmapping all chunks.
+// Arena may be reused after that.
+func (arena *Arena) Free() error {
+	for len(arena.chunks) > 0 {
+		chunk := arena.chunks[len(arena.chunks)-1]
+		if err := syspack.MunmapE(chunk.address, syspack.Size(len(chunk.data))); err != nil {
+			return err
+		}
+		arena.chunks = arena.chunks[:len(arena.chunks)-1]
+	}
+	arena.current = 0
+	arena.allocations = 0
+	return nil
+}
+
+// Get arena usage statistics.
+func (arena *Arena) Stats() ArenaStats {
+	stats := ArenaStats{Chunks: len(arena.chunks), Allocations: arena.allocations}
+	for _, chunk := range arena.chunks {
+		stats.Reserved += syspack.Size(len(chunk.data))
+		stats.Used += syspack.Size(chunk.used)
+	}
+	return stats
+}
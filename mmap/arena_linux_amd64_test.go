@@ -0,0 +1,78 @@
+package mmap
+
+import (
+	"testing"
+)
+
+func TestArena(t *testing.T) {
+	arena, err := NewArena(&ArenaOptions{ChunkSize: 1 << 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer arena.Free()
+	for i := 0; i < 1000; i++ {
+		pointer, err := arena.Alloc(24, 8)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if uintptr(pointer)%8 != 0 {
+			t.Fatalf("pointer %p must be aligned", pointer)
+		}
+		value := (*[3]uint64)(pointer)
+		if value[0] != 0 || value[1] != 0 || value[2] != 0 {
+			t.Fatalf("memory must be zeroed, %v found", *value)
+		}
+		value[0], value[1], value[2] = 1, 2, 3
+	}
+	buffer, err := arena.Bytes(1 << 17)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(buffer, testBuffer)
+	if _, err := arena.Alloc(24, 8); err != nil {
+		t.Fatal(err)
+	}
+	stats := arena.Stats()
+	if stats.Allocations != 1002 {
+		t.Fatalf("allocations must be a 1002, %d found", stats.Allocations)
+	}
+	// Oversized allocation must not abandon free space of the first chunk.
+	if stats.Chunks != 2 || stats.Used != 24*1001+1<<17 || stats.Reserved < stats.Used {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if err := arena.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	pointer, err := arena.Alloc(8, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := *(*uint64)(pointer); value != 0 {
+		t.Fatalf("memory must be zeroed after reset, %d found", value)
+	}
+	if stats := arena.Stats(); stats.Chunks != 2 || stats.Used != 8 {
+		t.Fatalf("unexpected stats after reset %+v", stats)
+	}
+	if err := arena.Free(); err != nil {
+		t.Fatal(err)
+	}
+	if stats := arena.Stats(); stats.Chunks != 0 || stats.Reserved != 0 {
+		t.Fatalf("unexpected stats after free %+v", stats)
+	}
+}
+
+func TestArenaHugePages(t *testing.T) {
+	arena, err := NewArena(&ArenaOptions{HugePages: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer arena.Free()
+	buffer, err := arena.Bytes(1 << 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(buffer, testBuffer)
+	if stats := arena.Stats(); stats.Reserved%hugePageSize != 0 {
+		t.Fatalf("reserved size must be a multiple of huge page size, %d found", stats.Reserved)
+	}
+}
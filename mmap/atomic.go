@@ -0,0 +1,150 @@
+package mmap
+
+import (
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+// Get pointer to 32-bit word at given offset.
+func (mapping *Mapping) word32(offset syspack.Offset) (*uint32, error) {
+	if mapping.data == nil {
+		return nil, &ErrorClosed{}
+	}
+	if offset < 0 || offset+4 > syspack.Off(mapping.data) {
+		return nil, &ErrorInvalidOffset{Offset: offset}
+	}
+	pointer := unsafe.Pointer(&mapping.data[offset])
+	if uintptr(pointer)%4 != 0 {
+		return nil, &ErrorUnalignedOffset{Offset: offset}
+	}
+	return (*uint32)(pointer), nil
+}
+
+// Get pointer to 64-bit word at given offset.
+func (mapping *Mapping) word64(offset syspack.Offset) (*uint64, error) {
+	if mapping.data == nil {
+		return nil, &ErrorClosed{}
+	}
+	if offset < 0 || offset+8 > syspack.Off(mapping.data) {
+		return nil, &ErrorInvalidOffset{Offset: offset}
+	}
+	pointer := unsafe.Pointer(&mapping.data[offset])
+	if uintptr(pointer)%8 != 0 {
+		return nil, &ErrorUnalignedOffset{Offset: offset}
+	}
+	return (*uint64)(pointer), nil
+}
+
+// Get pointer to writable 32-bit word at given offset.
+func (mapping *Mapping) writableWord32(offset syspack.Offset) (*uint32, error) {
+	word, err := mapping.word32(offset)
+	if err != nil {
+		return nil, err
+	}
+	if !mapping.canWrite {
+		return nil, &ErrorNotAllowed{Operation: "write"}
+	}
+	return word, nil
+}
+
+// Get pointer to writable 64-bit word at given offset.
+func (mapping *Mapping) writableWord64(offset syspack.Offset) (*uint64, error) {
+	word, err := mapping.word64(offset)
+	if err != nil {
+		return nil, err
+	}
+	if !mapping.canWrite {
+		return nil, &ErrorNotAllowed{Operation: "write"}
+	}
+	return word, nil
+}
+
+// Atomically load 32-bit unsigned integer from mapping at given offset.
+func (mapping *Mapping) LoadUint32At(offset syspack.Offset) (uint32, error) {
+	word, err := mapping.word32(offset)
+	if err != nil {
+		return 0, err
+	}
+	return atomic.LoadUint32(word), nil
+}
+
+// Atomically store 32-bit unsigned integer to mapping at given offset.
+func (mapping *Mapping) StoreUint32At(value uint32, offset syspack.Offset) error {
+	word, err := mapping.writableWord32(offset)
+	if err != nil {
+		return err
+	}
+	atomic.StoreUint32(word, value)
+	mapping.markDirty(offset, offset+4)
+	return nil
+}
+
+// Atomically add delta to 32-bit unsigned integer in mapping at given offset.
+func (mapping *Mapping) AddUint32At(delta uint32, offset syspack.Offset) (uint32, error) {
+	word, err := mapping.writableWord32(offset)
+	if err != nil {
+		return 0, err
+	}
+	value := atomic.AddUint32(word, delta)
+	mapping.markDirty(offset, offset+4)
+	return value, nil
+}
+
+// Atomically swap 32-bit unsigned integer in mapping at given offset if it is equal to old value.
+func (mapping *Mapping) CompareAndSwapUint32At(old, new uint32, offset syspack.Offset) (bool, error) {
+	word, err := mapping.writableWord32(offset)
+	if err != nil {
+		return false, err
+	}
+	swapped := atomic.CompareAndSwapUint32(word, old, new)
+	if swapped {
+		mapping.markDirty(offset, offset+4)
+	}
+	return swapped, nil
+}
+
+// Atomically load 64-bit unsigned integer from mapping at given offset.
+func (mapping *Mapping) LoadUint64At(offset syspack.Offset) (uint64, error) {
+	word, err := mapping.word64(offset)
+	if err != nil {
+		return 0, err
+	}
+	return atomic.LoadUint64(word), nil
+}
+
+// Atomically store 64-bit unsigned integer to mapping at given offset.
+func (mapping *Mapping) StoreUint64At(value uint64, offset syspack.Offset) error {
+	word, err := mapping.writableWord64(offset)
+	if err != nil {
+		return err
+	}
+	atomic.StoreUint64(word, value)
+	mapping.markDirty(offset, offset+8)
+	return nil
+}
+
+// Atomically add delta to 64-bit unsigned integer in mapping at given offset.
+func (mapping *Mapping) AddUint64At(delta uint64, offset syspack.Offset) (uint64, error) {
+	word, err := mapping.writableWord64(offset)
+	if err != nil {
+		return 0, err
+	}
+	value := atomic.AddUint64(word, delta)
+	mapping.markDirty(offset, offset+8)
+	return value, nil
+}
+
+// Atomically swap 64-bit unsigned integer in mapping at given offset if it is equal to old value.
+func (mapping *Mapping) CompareAndSwapUint64At(old, new uint64, offset syspack.Offset) (bool, error) {
+	word, err := mapping.writableWord64(offset)
+	if err != nil {
+		return false, err
+	}
+	swapped := atomic.CompareAndSwapUint64(word, old, new)
+	if swapped {
+		mapping.markDirty(offset, offset+8)
+	}
+	return swapped, nil
+}
@@ -0,0 +1,262 @@
+package mmap
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"os"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+// Bitset format.
+const (
+	bitsetMagic   = 0x54455342 // "BSET"
+	bitsetVersion = 1
+)
+
+// Bitset header layout.
+// Hash count and seeds are used by Bloom filter only.
+const (
+	bitsetMagicOffset   = 0
+	bitsetVersionOffset = 4
+	bitsetBitsOffset    = 8
+	bitsetHashesOffset  = 16
+	bitsetSeedsOffset   = 24
+	bitsetHeaderSize    = cacheLineSize
+)
+
+type Bitset struct {
+	// Fixed-size bitset stored in mapped file.
+
+	// Mapping.
+	mapping *Mapping
+
+	// Bit words.
+	words []uint64
+
+	// Number of bits.
+	bits uint64
+}
+
+// Create bitset file of given number of bits.
+func CreateBitset(path string, bits uint64) (*Bitset, error) {
+	return createBitset(path, bits, 0, [2]uint64{})
+}
+
+// Open existing bitset file.
+func OpenBitset(path string) (*Bitset, error) {
+	bitset, _, _, err := openBitset(path)
+	return bitset, err
+}
+
+// Create bitset file with given header.
+func createBitset(path string, bits uint64, hashes uint32, seeds [2]uint64) (*Bitset, error) {
+	if bits == 0 || bits > uint64(syspack.MaxInt-bitsetHeaderSize-63) {
+		return nil, &ErrorInvalidSize{Size: syspack.Size(bits)}
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0666)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	size := bitsetHeaderSize + (bits+63)/64*8
+	if err := file.Truncate(int64(size)); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	mapping, err := NewMapping(file.Fd(), 0, syspack.Size(size), &Options{Mode: ModeReadWrite})
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	header, _ := mapping.Direct(0, bitsetHeaderSize)
+	binary.LittleEndian.PutUint32(header[bitsetVersionOffset:], bitsetVersion)
+	binary.LittleEndian.PutUint64(header[bitsetBitsOffset:], bits)
+	binary.LittleEndian.PutUint32(header[bitsetHashesOffset:], hashes)
+	binary.LittleEndian.PutUint64(header[bitsetSeedsOffset:], seeds[0])
+	binary.LittleEndian.PutUint64(header[bitsetSeedsOffset+8:], seeds[1])
+	binary.LittleEndian.PutUint32(header[bitsetMagicOffset:], bitsetMagic)
+	return newBitset(mapping, bits), nil
+}
+
+// Open existing bitset file returning its header.
+func openBitset(path string) (*Bitset, uint32, [2]uint64, error) {
+	var seeds [2]uint64
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, 0, seeds, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, 0, seeds, err
+	}
+	if info.Size() < bitsetHeaderSize || info.Size() > int64(syspack.MaxInt) {
+		return nil, 0, seeds, &ErrorInvalidSize{Size: syspack.Size(info.Size())}
+	}
+	mapping, err := NewMapping(file.Fd(), 0, syspack.Size(info.Size()), &Options{Mode: ModeReadWrite})
+	if err != nil {
+		return nil, 0, seeds, err
+	}
+	header, _ := mapping.Direct(0, bitsetHeaderSize)
+	if magic := binary.LittleEndian.Uint32(header[bitsetMagicOffset:]); magic != bitsetMagic {
+		mapping.Close()
+		return nil, 0, seeds, &ErrorBadMagic{Magic: magic}
+	}
+	if version := binary.LittleEndian.Uint32(header[bitsetVersionOffset:]); version != bitsetVersion {
+		mapping.Close()
+		return nil, 0, seeds, &ErrorVersionMismatch{Version: version, Expected: bitsetVersion}
+	}
+	bits := binary.LittleEndian.Uint64(header[bitsetBitsOffset:])
+	if bits == 0 || (bits+63)/64*8 != uint64(info.Size())-bitsetHeaderSize {
+		mapping.Close()
+		return nil, 0, seeds, &ErrorInvalidSize{Size: syspack.Size(info.Size())}
+	}
+	hashes := binary.LittleEndian.Uint32(header[bitsetHashesOffset:])
+	seeds[0] = binary.LittleEndian.Uint64(header[bitsetSeedsOffset:])
+	seeds[1] = binary.LittleEndian.Uint64(header[bitsetSeedsOffset+8:])
+	return newBitset(mapping, bits), hashes, seeds, nil
+}
+
+// Make bitset over mapping.
+func newBitset(mapping *Mapping, bits uint64) *Bitset {
+	data, _ := mapping.Direct(bitsetHeaderSize, syspack.Offset(mapping.Len()))
+	return &Bitset{
+		mapping: mapping,
+		words:   makeWordSlice(uintptr(unsafe.Pointer(&data[0])), len(data)/8),
+		bits:    bits,
+	}
+}
+
+// Get number of bits.
+func (bitset *Bitset) Len() uint64 {
+	return bitset.bits
+}
+
+// Get word pointer and mask of bit at given index.
+func (bitset *Bitset) bit(index uint64) (*uint64, uint64, error) {
+	if bitset.words == nil {
+		return nil, 0, &ErrorClosed{}
+	}
+	if index >= bitset.bits {
+		return nil, 0, &ErrorInvalidOffset{Offset: syspack.Offset(index)}
+	}
+	return &bitset.words[index/64], 1 << (index % 64), nil
+}
+
+// Set bit at given index.
+func (bitset *Bitset) Set(index uint64) error {
+	word, mask, err := bitset.bit(index)
+	if err != nil {
+		return err
+	}
+	*word |= mask
+	return nil
+}
+
+// Clear bit at given index.
+func (bitset *Bitset) Clear(index uint64) error {
+	word, mask, err := bitset.bit(index)
+	if err != nil {
+		return err
+	}
+	*word &^= mask
+	return nil
+}
+
+// Test bit at given index.
+func (bitset *Bitset) Test(index uint64) (bool, error) {
+	word, mask, err := bitset.bit(index)
+	if err != nil {
+		return false, err
+	}
+	return *word&mask != 0, nil
+}
+
+// Atomically set bit at given index returning its previous value.
+func (bitset *Bitset) AtomicSet(index uint64) (bool, error) {
+	word, mask, err := bitset.bit(index)
+	if err != nil {
+		return false, err
+	}
+	for {
+		old := atomic.LoadUint64(word)
+		if old&mask != 0 {
+			return true, nil
+		}
+		if atomic.CompareAndSwapUint64(word, old, old|mask) {
+			return false, nil
+		}
+	}
+}
+
+// Atomically clear bit at given index returning its previous value.
+func (bitset *Bitset) AtomicClear(index uint64) (bool, error) {
+	word, mask, err := bitset.bit(index)
+	if err != nil {
+		return false, err
+	}
+	for {
+		old := atomic.LoadUint64(word)
+		if old&mask == 0 {
+			return false, nil
+		}
+		if atomic.CompareAndSwapUint64(word, old, old&^mask) {
+			return true, nil
+		}
+	}
+}
+
+// Atomically test bit at given index.
+func (bitset *Bitset) AtomicTest(index uint64) (bool, error) {
+	word, mask, err := bitset.bit(index)
+	if err != nil {
+		return false, err
+	}
+	return atomic.LoadUint64(word)&mask != 0, nil
+}
+
+// Get number of set bits.
+func (bitset *Bitset) Count() uint64 {
+	count := 0
+	for i := range bitset.words {
+		count += bits.OnesCount64(atomic.LoadUint64(&bitset.words[i]))
+	}
+	return uint64(count)
+}
+
+// Get index of first set bit not less than given one.
+// Returns false if there is no such bit.
+func (bitset *Bitset) NextSet(from uint64) (uint64, bool) {
+	if bitset.words == nil || from >= bitset.bits {
+		return 0, false
+	}
+	i := from / 64
+	word := atomic.LoadUint64(&bitset.words[i]) >> (from % 64) << (from % 64)
+	for {
+		if word != 0 {
+			index := i*64 + uint64(bits.TrailingZeros64(word))
+			return index, index < bitset.bits
+		}
+		if i++; i >= uint64(len(bitset.words)) {
+			return 0, false
+		}
+		word = atomic.LoadUint64(&bitset.words[i])
+	}
+}
+
+// Sync bitset file.
+func (bitset *Bitset) Sync() error {
+	return bitset.mapping.syncAll()
+}
+
+// Close bitset file.
+func (bitset *Bitset) Close() error {
+	if err := bitset.mapping.Close(); err != nil {
+		return err
+	}
+	bitset.words = nil
+	return nil
+}
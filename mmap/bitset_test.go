@@ -0,0 +1,89 @@
+package mmap
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testBitsetPath = filepath.Join(os.TempDir(), "test.bitset")
+
+func TestBitset(t *testing.T) {
+	os.Remove(testBitsetPath)
+	defer os.Remove(testBitsetPath)
+	bitset, err := CreateBitset(testBitsetPath, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bitset.Close()
+	for _, index := range []uint64{0, 63, 64, 500, 999} {
+		if err := bitset.Set(index); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bitset.Set(1000); err == nil {
+		t.Fatal("expected invalid offset, no error found")
+	}
+	if previous, err := bitset.AtomicClear(500); err != nil || !previous {
+		t.Fatalf("bit 500 must be set, %v %v found", previous, err)
+	}
+	if err := bitset.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if bitset, err = OpenBitset(testBitsetPath); err != nil {
+		t.Fatal(err)
+	}
+	if count := bitset.Count(); count != 4 {
+		t.Fatalf("count must be a 4, %d found", count)
+	}
+	var found []uint64
+	for index, ok := bitset.NextSet(0); ok; index, ok = bitset.NextSet(index + 1) {
+		found = append(found, index)
+	}
+	if fmt.Sprint(found) != "[0 63 64 999]" {
+		t.Fatalf("set bits must be a [0 63 64 999], %v found", found)
+	}
+	if set, err := bitset.Test(64); err != nil || !set {
+		t.Fatalf("bit 64 must be set, %v %v found", set, err)
+	}
+}
+
+func TestBloom(t *testing.T) {
+	os.Remove(testBitsetPath)
+	defer os.Remove(testBitsetPath)
+	bits, hashes := OptimalBloom(1000, 0.01)
+	bloom, err := CreateBloom(testBitsetPath, bits, hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bloom.Close()
+	for i := 0; i < 1000; i++ {
+		if err := bloom.Add([]byte(fmt.Sprint("key", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bloom.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if bloom, err = OpenBloom(testBitsetPath); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		if ok, err := bloom.Contains([]byte(fmt.Sprint("key", i))); err != nil || !ok {
+			t.Fatalf("key %d must be contained, %v %v found", i, ok, err)
+		}
+	}
+	falsePositives := 0
+	for i := 0; i < 1000; i++ {
+		if ok, _ := bloom.Contains([]byte(fmt.Sprint("other", i))); ok {
+			falsePositives++
+		}
+	}
+	if falsePositives > 50 {
+		t.Fatalf("too many false positives: %d", falsePositives)
+	}
+	if estimate := bloom.Estimate(); estimate < 900 || estimate > 1100 {
+		t.Fatalf("estimate must be about 1000, %d found", estimate)
+	}
+}
@@ -0,0 +1,132 @@
+package mmap
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"math"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+type Bloom struct {
+	// Bloom filter stored in mapped bitset file.
+	// Keys may be added concurrently.
+
+	// Bitset.
+	bitset *Bitset
+
+	// Number of hash functions.
+	hashes uint32
+
+	// Hash seeds.
+	seeds [2]uint64
+}
+
+// Get optimal number of bits and hash functions for given number of items and false positive rate.
+func OptimalBloom(items uint64, falsePositive float64) (uint64, uint32) {
+	if items == 0 {
+		items = 1
+	}
+	if falsePositive <= 0 || falsePositive >= 1 {
+		falsePositive = 0.01
+	}
+	bits := math.Ceil(-float64(items) * math.Log(falsePositive) / (math.Ln2 * math.Ln2))
+	hashes := math.Round(bits / float64(items) * math.Ln2)
+	if hashes < 1 {
+		hashes = 1
+	}
+	return uint64(bits), uint32(hashes)
+}
+
+// Create Bloom filter file with given number of bits and hash functions.
+// Hash seeds are chosen randomly and stored in file header.
+func CreateBloom(path string, bits uint64, hashes uint32) (*Bloom, error) {
+	if hashes == 0 {
+		return nil, &ErrorInvalidSize{Size: 0}
+	}
+	var random [16]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		return nil, err
+	}
+	seeds := [2]uint64{
+		binary.LittleEndian.Uint64(random[:8]),
+		binary.LittleEndian.Uint64(random[8:]),
+	}
+	bitset, err := createBitset(path, bits, hashes, seeds)
+	if err != nil {
+		return nil, err
+	}
+	return &Bloom{bitset: bitset, hashes: hashes, seeds: seeds}, nil
+}
+
+// Open existing Bloom filter file.
+func OpenBloom(path string) (*Bloom, error) {
+	bitset, hashes, seeds, err := openBitset(path)
+	if err != nil {
+		return nil, err
+	}
+	if hashes == 0 {
+		bitset.Close()
+		return nil, &ErrorInvalidSize{Size: 0}
+	}
+	return &Bloom{bitset: bitset, hashes: hashes, seeds: seeds}, nil
+}
+
+// Get bit index of key for given hash function using double hashing.
+func (bloom *Bloom) index(first, second uint64, i uint32) uint64 {
+	return (first + uint64(i)*second) % bloom.bitset.bits
+}
+
+// Add key to filter.
+func (bloom *Bloom) Add(key []byte) error {
+	first, second := hashBytes(bloom.seeds[0], key), hashBytes(bloom.seeds[1], key)|1
+	for i := uint32(0); i < bloom.hashes; i++ {
+		if _, err := bloom.bitset.AtomicSet(bloom.index(first, second, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Whether filter may contain key.
+// False positives are possible, false negatives are not.
+func (bloom *Bloom) Contains(key []byte) (bool, error) {
+	first, second := hashBytes(bloom.seeds[0], key), hashBytes(bloom.seeds[1], key)|1
+	for i := uint32(0); i < bloom.hashes; i++ {
+		set, err := bloom.bitset.AtomicTest(bloom.index(first, second, i))
+		if err != nil || !set {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Get number of hash functions.
+func (bloom *Bloom) Hashes() uint32 {
+	return bloom.hashes
+}
+
+// Get underlying bitset.
+func (bloom *Bloom) Bitset() *Bitset {
+	return bloom.bitset
+}
+
+// Get estimated number of added keys.
+func (bloom *Bloom) Estimate() uint64 {
+	bits := float64(bloom.bitset.bits)
+	set := float64(bloom.bitset.Count())
+	if set >= bits {
+		return uint64(syspack.MaxInt)
+	}
+	return uint64(math.Round(-bits / float64(bloom.hashes) * math.Log(1-set/bits)))
+}
+
+// Sync filter file.
+func (bloom *Bloom) Sync() error {
+	return bloom.bitset.Sync()
+}
+
+// Close filter file.
+func (bloom *Bloom) Close() error {
+	return bloom.bitset.Close()
+}
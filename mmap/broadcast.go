@@ -0,0 +1,318 @@
+package mmap
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+// Broadcast format.
+const (
+	broadcastMagic   = 0x54534342 // "BCST"
+	broadcastVersion = 1
+)
+
+// Broadcast header layout.
+const (
+	broadcastMagicOffset     = 0
+	broadcastVersionOffset   = 4
+	broadcastSlotCountOffset = 8
+	broadcastSlotSizeOffset  = 12
+	broadcastCursorOffset    = cacheLineSize
+	broadcastSignalOffset    = cacheLineSize + 8
+	broadcastWaitersOffset   = cacheLineSize + 12
+	broadcastHeaderSize      = 2 * cacheLineSize
+)
+
+// Broadcast slot layout.
+// Slot stamp is odd while slot is being written and even when it is committed.
+const (
+	broadcastStampOffset   = 0
+	broadcastLengthOffset  = 8
+	broadcastPayloadOffset = 16
+)
+
+type Broadcast struct {
+	// Single-writer multi-reader ring of fixed-size slots.
+	// Readers consume at their own pace and detect when they were overrun.
+
+	// Mapping.
+	mapping *Mapping
+
+	// Slot region.
+	slots []byte
+
+	// Slot count.
+	slotCount uint64
+
+	// Maximum message size.
+	slotSize uint64
+
+	// Slot stride.
+	stride uint64
+
+	// Next sequence number to be written.
+	cursor *uint64
+
+	// Counter incremented on every publication.
+	signal *uint32
+
+	// Number of waiting readers.
+	waiters *uint32
+}
+
+type BroadcastReader struct {
+	// Broadcast reader.
+
+	// Broadcast.
+	broadcast *Broadcast
+
+	// Next sequence number to be read.
+	position uint64
+}
+
+// Get mapping size required for broadcast with given slot count and size.
+func BroadcastSize(slotCount, slotSize syspack.Size) syspack.Size {
+	return broadcastHeaderSize + slotCount*broadcastStride(slotSize)
+}
+
+// Get slot stride.
+func broadcastStride(slotSize syspack.Size) syspack.Size {
+	return (broadcastPayloadOffset + slotSize + 7) &^ 7
+}
+
+// Make new broadcast in mapping at given offset.
+// Slot count must be a power of two.
+func NewBroadcast(mapping *Mapping, offset syspack.Offset, slotCount, slotSize syspack.Size) (*Broadcast, error) {
+	if !mapping.CanWrite() {
+		return nil, &ErrorNotAllowed{Operation: "write"}
+	}
+	broadcast, err := openBroadcast(mapping, offset, slotCount, slotSize)
+	if err != nil {
+		return nil, err
+	}
+	for i := range broadcast.slots {
+		broadcast.slots[i] = 0
+	}
+	atomic.StoreUint64(broadcast.cursor, 0)
+	atomic.StoreUint32(broadcast.signal, 0)
+	atomic.StoreUint32(broadcast.waiters, 0)
+	mapping.markDirty(offset, offset+syspack.Offset(BroadcastSize(slotCount, slotSize)))
+	if err := mapping.StoreUint32At(uint32(slotCount), offset+broadcastSlotCountOffset); err != nil {
+		return nil, err
+	}
+	if err := mapping.StoreUint32At(uint32(slotSize), offset+broadcastSlotSizeOffset); err != nil {
+		return nil, err
+	}
+	if err := mapping.StoreUint32At(broadcastVersion, offset+broadcastVersionOffset); err != nil {
+		return nil, err
+	}
+	if err := mapping.StoreUint32At(broadcastMagic, offset+broadcastMagicOffset); err != nil {
+		return nil, err
+	}
+	return broadcast, nil
+}
+
+// Attach to broadcast existing in mapping at given offset.
+// Read-only mapping is sufficient for non-blocking readers.
+func AttachBroadcast(mapping *Mapping, offset syspack.Offset) (*Broadcast, error) {
+	magic, err := mapping.LoadUint32At(offset + broadcastMagicOffset)
+	if err != nil {
+		return nil, err
+	}
+	if magic != broadcastMagic {
+		return nil, &ErrorBadMagic{Magic: magic}
+	}
+	version, err := mapping.LoadUint32At(offset + broadcastVersionOffset)
+	if err != nil {
+		return nil, err
+	}
+	if version != broadcastVersion {
+		return nil, &ErrorVersionMismatch{Version: version, Expected: broadcastVersion}
+	}
+	slotCount, err := mapping.LoadUint32At(offset + broadcastSlotCountOffset)
+	if err != nil {
+		return nil, err
+	}
+	slotSize, err := mapping.LoadUint32At(offset + broadcastSlotSizeOffset)
+	if err != nil {
+		return nil, err
+	}
+	return openBroadcast(mapping, offset, syspack.Size(slotCount), syspack.Size(slotSize))
+}
+
+// Open broadcast in mapping at given offset.
+func openBroadcast(mapping *Mapping, offset syspack.Offset, slotCount, slotSize syspack.Size) (*Broadcast, error) {
+	if slotCount == 0 || slotCount&(slotCount-1) != 0 || slotCount > syspack.Size(syspack.MaxDword) {
+		return nil, &ErrorInvalidSize{Size: slotCount}
+	}
+	if slotSize > syspack.Size(syspack.MaxDword) {
+		return nil, &ErrorInvalidSize{Size: slotSize}
+	}
+	size := BroadcastSize(slotCount, slotSize)
+	if size/broadcastStride(slotSize) < slotCount {
+		return nil, &ErrorInvalidSize{Size: slotCount}
+	}
+	data, err := mapping.Direct(offset, offset+syspack.Offset(size))
+	if err != nil {
+		return nil, err
+	}
+	broadcast := &Broadcast{
+		mapping:   mapping,
+		slots:     data[broadcastHeaderSize:],
+		slotCount: uint64(slotCount),
+		slotSize:  uint64(slotSize),
+		stride:    uint64(broadcastStride(slotSize)),
+	}
+	if broadcast.cursor, err = mapping.word64(offset + broadcastCursorOffset); err != nil {
+		return nil, err
+	}
+	if broadcast.signal, err = mapping.word32(offset + broadcastSignalOffset); err != nil {
+		return nil, err
+	}
+	if broadcast.waiters, err = mapping.word32(offset + broadcastWaitersOffset); err != nil {
+		return nil, err
+	}
+	return broadcast, nil
+}
+
+// Get slot count.
+func (broadcast *Broadcast) SlotCount() syspack.Size {
+	return syspack.Size(broadcast.slotCount)
+}
+
+// Get maximum message size.
+func (broadcast *Broadcast) SlotSize() syspack.Size {
+	return syspack.Size(broadcast.slotSize)
+}
+
+// Get next sequence number to be written.
+func (broadcast *Broadcast) Cursor() uint64 {
+	return atomic.LoadUint64(broadcast.cursor)
+}
+
+// Get slot for given sequence number.
+func (broadcast *Broadcast) slot(sequence uint64) []byte {
+	start := (sequence & (broadcast.slotCount - 1)) * broadcast.stride
+	return broadcast.slots[start : start+broadcast.stride]
+}
+
+// Get slot stamp.
+func slotStamp(slot []byte) *uint64 {
+	return (*uint64)(unsafe.Pointer(&slot[broadcastStampOffset]))
+}
+
+// Publish message.
+// Only one writer may publish at a time.
+func (broadcast *Broadcast) Publish(message []byte) error {
+	if !broadcast.mapping.CanWrite() {
+		return &ErrorNotAllowed{Operation: "write"}
+	}
+	if uint64(len(message)) > broadcast.slotSize {
+		return &ErrorInvalidSize{Size: syspack.Len(message)}
+	}
+	sequence := atomic.LoadUint64(broadcast.cursor)
+	slot := broadcast.slot(sequence)
+	stamp := slotStamp(slot)
+	atomic.StoreUint64(stamp, sequence<<1|1)
+	binary.LittleEndian.PutUint32(slot[broadcastLengthOffset:], uint32(len(message)))
+	copy(slot[broadcastPayloadOffset:], message)
+	atomic.StoreUint64(stamp, (sequence+1)<<1)
+	broadcast.mapping.markDirtyBytes(slot)
+	atomic.StoreUint64(broadcast.cursor, sequence+1)
+	broadcast.mapping.markDirtyPointer(unsafe.Pointer(broadcast.cursor), 8)
+	atomic.AddUint32(broadcast.signal, 1)
+	if atomic.LoadUint32(broadcast.waiters) != 0 {
+		if _, err := wake32(broadcast.signal, -1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Make new reader starting at next message to be published.
+func (broadcast *Broadcast) NewReader() *BroadcastReader {
+	return &BroadcastReader{broadcast: broadcast, position: broadcast.Cursor()}
+}
+
+// Get next sequence number to be read.
+func (reader *BroadcastReader) Position() uint64 {
+	return reader.position
+}
+
+// Get number of published messages not read yet.
+func (reader *BroadcastReader) Lag() uint64 {
+	return reader.broadcast.Cursor() - reader.position
+}
+
+// Try to read next message without blocking.
+// Message is appended to buffer, returns false if there are no new messages.
+// If reader was overrun, it is moved to the oldest available message and ErrorOverrun is returned.
+func (reader *BroadcastReader) TryRead(buffer []byte) ([]byte, bool, error) {
+	broadcast := reader.broadcast
+	if reader.position >= broadcast.Cursor() {
+		return buffer, false, nil
+	}
+	slot := broadcast.slot(reader.position)
+	stamp := slotStamp(slot)
+	expected := (reader.position + 1) << 1
+	if atomic.LoadUint64(stamp) != expected {
+		return buffer, false, reader.overrun()
+	}
+	length := uint64(binary.LittleEndian.Uint32(slot[broadcastLengthOffset:]))
+	if length > broadcast.slotSize {
+		return buffer, false, reader.overrun()
+	}
+	result := append(buffer, slot[broadcastPayloadOffset:broadcastPayloadOffset+length]...)
+	if atomic.LoadUint64(stamp) != expected {
+		return buffer, false, reader.overrun()
+	}
+	reader.position++
+	return result, true, nil
+}
+
+// Read next message, waiting for it at most timeout.
+// Message is appended to buffer, negative timeout means infinite wait.
+// Waiting requires writable mapping.
+func (reader *BroadcastReader) Read(buffer []byte, timeout time.Duration) ([]byte, error) {
+	broadcast := reader.broadcast
+	deadline := time.Now().Add(timeout)
+	for {
+		var ok bool
+		var err error
+		if buffer, ok, err = reader.TryRead(buffer); ok || err != nil {
+			return buffer, err
+		}
+		if !broadcast.mapping.CanWrite() {
+			return buffer, &ErrorNotAllowed{Operation: "wait"}
+		}
+		atomic.AddUint32(broadcast.waiters, 1)
+		signal := atomic.LoadUint32(broadcast.signal)
+		if buffer, ok, err = reader.TryRead(buffer); ok || err != nil {
+			atomic.AddUint32(broadcast.waiters, ^uint32(0))
+			return buffer, err
+		}
+		err = wait32(broadcast.signal, signal, remaining(deadline, timeout))
+		atomic.AddUint32(broadcast.waiters, ^uint32(0))
+		if err != nil {
+			return buffer, err
+		}
+	}
+}
+
+// Move overrun reader to the oldest available message.
+func (reader *BroadcastReader) overrun() error {
+	position := reader.position
+	cursor := reader.broadcast.Cursor()
+	if cursor >= reader.broadcast.slotCount {
+		// Slot of the oldest message may be already being rewritten.
+		reader.position = cursor - reader.broadcast.slotCount + 1
+	}
+	if reader.position <= position {
+		reader.position = position + 1
+	}
+	return &ErrorOverrun{Lost: reader.position - position}
+}
@@ -0,0 +1,95 @@
+package mmap
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestBroadcast(t *testing.T) {
+	mapping, err := makeTestMapping(ModeReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mapping.Close()
+	writer, err := NewBroadcast(mapping, 0, 1024, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const count = 1000
+	readers := make([]*BroadcastReader, 2)
+	for i := range readers {
+		broadcast, err := AttachBroadcast(mapping, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		readers[i] = broadcast.NewReader()
+	}
+	done := make(chan error, len(readers))
+	for _, reader := range readers {
+		go func(reader *BroadcastReader) {
+			var buffer []byte
+			var err error
+			for i := 0; i < count; i++ {
+				if buffer, err = reader.Read(buffer[:0], time.Second); err != nil {
+					done <- err
+					return
+				}
+				if message := makeTestMessage(i); bytes.Compare(buffer, message) != 0 {
+					t.Errorf("message %d must be a %v, %v found", i, message, buffer)
+				}
+			}
+			done <- nil
+		}(reader)
+	}
+	for i := 0; i < count; i++ {
+		if err := writer.Publish(makeTestMessage(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for range readers {
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBroadcastOverrun(t *testing.T) {
+	mapping, err := makeTestMapping(ModeReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mapping.Close()
+	broadcast, err := NewBroadcast(mapping, 0, 4, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := broadcast.NewReader()
+	for i := 0; i < 10; i++ {
+		if err := broadcast.Publish(makeTestMessage(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if lag := reader.Lag(); lag != 10 {
+		t.Fatalf("lag must be a 10, %d found", lag)
+	}
+	if _, _, err := reader.TryRead(nil); err == nil {
+		t.Fatal("expected overrun, no error found")
+	} else if overrun, ok := err.(*ErrorOverrun); !ok {
+		t.Fatalf("expected overrun, [%v] error found", err)
+	} else if overrun.Lost != 7 {
+		t.Fatalf("lost must be a 7, %d found", overrun.Lost)
+	}
+	for i := 7; i < 10; i++ {
+		buffer, ok, err := reader.TryRead(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if message := makeTestMessage(i); !ok || bytes.Compare(buffer, message) != 0 {
+			t.Fatalf("message %d must be a %v, %v found", i, message, buffer)
+		}
+	}
+	if _, ok, err := reader.TryRead(nil); err != nil || ok {
+		t.Fatalf("no message expected, %v %v found", ok, err)
+	}
+}
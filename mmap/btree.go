@@ -0,0 +1,803 @@
+package mmap
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+// B+tree format.
+const (
+	btreeMagic   = 0x45525442 // "BTRE"
+	btreeVersion = 1
+)
+
+// B+tree meta page layout.
+// Meta pages are the first two pages of file, commit of transaction N writes meta page N%2.
+const (
+	btreeMagicOffset    = 0
+	btreeVersionOffset  = 4
+	btreePageSizeOffset = 8
+	btreeTxidOffset     = 16
+	btreeRootOffset     = 24
+	btreePagesOffset    = 32
+	btreeFreelistOffset = 40
+	btreeEntriesOffset  = 48
+	btreeChecksumOffset = 56
+	btreeMetaSize       = cacheLineSize
+	btreeMetaPages      = 2
+)
+
+// B+tree defaults and limits.
+const (
+	defaultBTreeMapSize = 1 << 30
+	minBTreePageSize    = 512
+	maxBTreePageSize    = 1 << 16
+)
+
+type BTreeOptions struct {
+	// B+tree options.
+
+	// Page size of new file, operating system page size by default.
+	PageSize int
+
+	// Size of mapping which limits file growth, 1 GiB by default.
+	// File is extended to this size at once, so it is sparse on most file systems.
+	MapSize syspack.Size
+}
+
+type btreeMeta struct {
+	// B+tree meta page.
+
+	// Transaction identifier.
+	txid uint64
+
+	// Root page number, zero if tree is empty.
+	root uint64
+
+	// Number of used pages.
+	pages uint64
+
+	// First free list page number, zero if there is no free list.
+	freelist uint64
+
+	// Number of entries.
+	entries uint64
+}
+
+type BTree struct {
+	// Copy-on-write B+tree stored in file.
+	// Pages are read through read-only mapping and written by single writer transaction,
+	// which never modifies pages reachable from committed root.
+	// Readers see consistent snapshot and never block writer.
+
+	// File.
+	file *os.File
+
+	// Mapping.
+	mapping *Mapping
+
+	// Data.
+	data []byte
+
+	// Page size.
+	pageSize int
+
+	// Maximum number of pages.
+	maxPages uint64
+
+	// Writer lock.
+	writer sync.Mutex
+
+	// State lock.
+	mutex sync.Mutex
+
+	// Committed meta.
+	meta btreeMeta
+
+	// Number of active readers by transaction identifier.
+	readers map[uint64]int
+
+	// Pages freed by commit with given transaction identifier.
+	pending map[uint64][]uint64
+
+	// Reusable pages.
+	free []uint64
+
+	// Pages of committed free list.
+	freelistPages []uint64
+}
+
+type BTreeTx struct {
+	// B+tree transaction.
+	// Read-only transaction sees snapshot of tree taken when it began.
+	// Keys and values returned by read-only transaction refer directly to mapping
+	// and are valid until transaction is finished.
+
+	// Tree.
+	tree *BTree
+
+	// Transaction is writable.
+	writable bool
+
+	// Transaction is finished.
+	done bool
+
+	// Meta.
+	meta btreeMeta
+
+	// Dirty nodes by page number.
+	dirty map[uint64]*btreeNode
+
+	// Pages allocated by transaction.
+	allocated map[uint64]bool
+
+	// Reusable pages.
+	free []uint64
+
+	// Pages freed by transaction.
+	freed []uint64
+}
+
+// Open B+tree file creating it if necessary.
+func OpenBTree(path string, options *BTreeOptions) (*BTree, error) {
+	if options == nil {
+		options = &BTreeOptions{}
+	}
+	pageSize := options.PageSize
+	if pageSize == 0 {
+		pageSize = os.Getpagesize()
+	}
+	if pageSize < minBTreePageSize || pageSize > maxBTreePageSize || pageSize&(pageSize-1) != 0 {
+		return nil, &ErrorInvalidSize{Size: syspack.Size(pageSize)}
+	}
+	mapSize := options.MapSize
+	if mapSize == 0 {
+		mapSize = defaultBTreeMapSize
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := openBTree(file, path, pageSize, mapSize)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return tree, nil
+}
+
+// Open B+tree over opened file.
+func openBTree(file *os.File, path string, pageSize int, mapSize syspack.Size) (*BTree, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() == 0 {
+		if err := initBTree(file, path, pageSize); err != nil {
+			return nil, err
+		}
+	}
+	meta, pageSize, err := readBTreeMeta(file)
+	if err != nil {
+		return nil, err
+	}
+	if info, err = file.Stat(); err != nil {
+		return nil, err
+	}
+	if syspack.Size(info.Size()) > mapSize {
+		mapSize = syspack.Size(info.Size())
+	}
+	mapSize &^= syspack.Size(pageSize - 1)
+	if mapSize > syspack.Size(syspack.MaxInt) || uint64(mapSize)/uint64(pageSize) < meta.pages {
+		return nil, &ErrorInvalidSize{Size: mapSize}
+	}
+	if syspack.Size(info.Size()) < mapSize {
+		if err := file.Truncate(int64(mapSize)); err != nil {
+			return nil, err
+		}
+	}
+	mapping, err := NewMapping(file.Fd(), 0, mapSize, &Options{Mode: ModeReadOnly})
+	if err != nil {
+		return nil, err
+	}
+	data, _ := mapping.Direct(0, syspack.Offset(mapping.Len()))
+	tree := &BTree{
+		file:     file,
+		mapping:  mapping,
+		data:     data,
+		pageSize: pageSize,
+		maxPages: uint64(mapSize) / uint64(pageSize),
+		meta:     meta,
+		readers:  make(map[uint64]int),
+		pending:  make(map[uint64][]uint64),
+	}
+	if err := tree.readFreelist(); err != nil {
+		mapping.Close()
+		return nil, err
+	}
+	return tree, nil
+}
+
+// Write meta pages of empty tree.
+func initBTree(file *os.File, path string, pageSize int) error {
+	meta := btreeMeta{pages: btreeMetaPages}
+	page := make([]byte, pageSize)
+	for i := 0; i < btreeMetaPages; i++ {
+		meta.encode(page, pageSize)
+		if _, err := file.WriteAt(page, int64(i*pageSize)); err != nil {
+			return err
+		}
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// Read the latest valid meta page.
+// Page size stored in the first meta page is used to locate the second one,
+// every valid page size is probed if the first one is corrupted.
+func readBTreeMeta(file *os.File) (btreeMeta, int, error) {
+	page := make([]byte, btreeMetaSize)
+	best, bestPageSize, err := readBTreeMetaAt(file, page, 0)
+	for pageSize := minBTreePageSize; pageSize <= maxBTreePageSize; pageSize <<= 1 {
+		if bestPageSize != 0 && pageSize != bestPageSize {
+			continue
+		}
+		meta, metaPageSize, metaErr := readBTreeMetaAt(file, page, int64(pageSize))
+		if metaErr != nil || metaPageSize != pageSize {
+			continue
+		}
+		if bestPageSize == 0 || meta.txid > best.txid {
+			best, bestPageSize = meta, metaPageSize
+		}
+		break
+	}
+	if bestPageSize == 0 {
+		return best, 0, err
+	}
+	return best, bestPageSize, nil
+}
+
+// Read and decode meta page at given file offset.
+func readBTreeMetaAt(file *os.File, page []byte, offset int64) (btreeMeta, int, error) {
+	if _, err := file.ReadAt(page, offset); err != nil {
+		return btreeMeta{}, 0, err
+	}
+	return decodeBTreeMeta(page)
+}
+
+// Encode meta into page.
+func (meta *btreeMeta) encode(page []byte, pageSize int) {
+	for i := range page[:btreeMetaSize] {
+		page[i] = 0
+	}
+	binary.LittleEndian.PutUint32(page[btreeMagicOffset:], btreeMagic)
+	binary.LittleEndian.PutUint32(page[btreeVersionOffset:], btreeVersion)
+	binary.LittleEndian.PutUint32(page[btreePageSizeOffset:], uint32(pageSize))
+	binary.LittleEndian.PutUint64(page[btreeTxidOffset:], meta.txid)
+	binary.LittleEndian.PutUint64(page[btreeRootOffset:], meta.root)
+	binary.LittleEndian.PutUint64(page[btreePagesOffset:], meta.pages)
+	binary.LittleEndian.PutUint64(page[btreeFreelistOffset:], meta.freelist)
+	binary.LittleEndian.PutUint64(page[btreeEntriesOffset:], meta.entries)
+	binary.LittleEndian.PutUint32(
+		page[btreeChecksumOffset:],
+		crc32.Checksum(page[:btreeChecksumOffset], castagnoli),
+	)
+}
+
+// Decode and validate meta page.
+func decodeBTreeMeta(page []byte) (btreeMeta, int, error) {
+	var meta btreeMeta
+	checksum := crc32.Checksum(page[:btreeChecksumOffset], castagnoli)
+	if expected := binary.LittleEndian.Uint32(page[btreeChecksumOffset:]); checksum != expected {
+		return meta, 0, &ErrorChecksum{Checksum: checksum, Expected: expected}
+	}
+	if magic := binary.LittleEndian.Uint32(page[btreeMagicOffset:]); magic != btreeMagic {
+		return meta, 0, &ErrorBadMagic{Magic: magic}
+	}
+	if version := binary.LittleEndian.Uint32(page[btreeVersionOffset:]); version != btreeVersion {
+		return meta, 0, &ErrorVersionMismatch{Version: version, Expected: btreeVersion}
+	}
+	pageSize := int(binary.LittleEndian.Uint32(page[btreePageSizeOffset:]))
+	if pageSize < minBTreePageSize || pageSize > maxBTreePageSize || pageSize&(pageSize-1) != 0 {
+		return meta, 0, &ErrorInvalidSize{Size: syspack.Size(pageSize)}
+	}
+	meta.txid = binary.LittleEndian.Uint64(page[btreeTxidOffset:])
+	meta.root = binary.LittleEndian.Uint64(page[btreeRootOffset:])
+	meta.pages = binary.LittleEndian.Uint64(page[btreePagesOffset:])
+	meta.freelist = binary.LittleEndian.Uint64(page[btreeFreelistOffset:])
+	meta.entries = binary.LittleEndian.Uint64(page[btreeEntriesOffset:])
+	if meta.pages < btreeMetaPages || meta.root >= meta.pages || meta.freelist >= meta.pages {
+		return meta, 0, &ErrorInvalidSize{Size: syspack.Size(meta.pages)}
+	}
+	return meta, pageSize, nil
+}
+
+// Get committed page of given number.
+func (tree *BTree) page(pgno uint64, pages uint64) ([]byte, error) {
+	if pgno < btreeMetaPages || pgno >= pages {
+		return nil, &ErrorInvalidOffset{Offset: syspack.Offset(pgno) * syspack.Offset(tree.pageSize)}
+	}
+	start := pgno * uint64(tree.pageSize)
+	return tree.data[start : start+uint64(tree.pageSize) : start+uint64(tree.pageSize)], nil
+}
+
+// Read committed free list.
+// Free list longer than file is considered cyclic.
+func (tree *BTree) readFreelist() error {
+	for pgno, steps := tree.meta.freelist, uint64(0); pgno != 0; steps++ {
+		if steps >= tree.meta.pages {
+			return &ErrorInvalidOffset{Offset: syspack.Offset(pgno) * syspack.Offset(tree.pageSize)}
+		}
+		page, err := tree.page(pgno, tree.meta.pages)
+		if err != nil {
+			return err
+		}
+		flags := binary.LittleEndian.Uint16(page[btreePageFlagsOffset:])
+		count := int(binary.LittleEndian.Uint16(page[btreePageCountOffset:]))
+		if flags != btreeFreelistPage || btreePageHeaderSize+count*btreeFreelistEntry > len(page) {
+			return &ErrorInvalidOffset{Offset: syspack.Offset(pgno) * syspack.Offset(tree.pageSize)}
+		}
+		tree.freelistPages = append(tree.freelistPages, pgno)
+		for i := 0; i < count; i++ {
+			free := binary.LittleEndian.Uint64(page[btreePageHeaderSize+i*btreeFreelistEntry:])
+			if free < btreeMetaPages || free >= tree.meta.pages {
+				return &ErrorInvalidOffset{Offset: syspack.Offset(free) * syspack.Offset(tree.pageSize)}
+			}
+			tree.free = append(tree.free, free)
+		}
+		pgno = binary.LittleEndian.Uint64(page[btreePageNextOffset:])
+	}
+	return nil
+}
+
+// Get maximum total length of key and value.
+func (tree *BTree) MaxEntrySize() int {
+	return (tree.pageSize-btreePageHeaderSize)/4 - btreeOffsetSize - btreeBranchEntrySize
+}
+
+// Begin transaction.
+// Only one writable transaction may exist at a time, Begin blocks until previous one is finished.
+func (tree *BTree) Begin(writable bool) (*BTreeTx, error) {
+	if writable {
+		tree.writer.Lock()
+	}
+	tree.mutex.Lock()
+	defer tree.mutex.Unlock()
+	if tree.data == nil {
+		if writable {
+			tree.writer.Unlock()
+		}
+		return nil, &ErrorClosed{}
+	}
+	tx := &BTreeTx{tree: tree, writable: writable, meta: tree.meta}
+	if !writable {
+		tree.readers[tx.meta.txid]++
+		return tx, nil
+	}
+	tree.releasePending()
+	tx.dirty = make(map[uint64]*btreeNode)
+	tx.allocated = make(map[uint64]bool)
+	tx.free = append([]uint64(nil), tree.free...)
+	return tx, nil
+}
+
+// Move pages which are not visible to any reader from pending to reusable.
+// Pages freed by commit N are visible to readers of snapshots older than N only.
+func (tree *BTree) releasePending() {
+	oldest := tree.meta.txid
+	for txid := range tree.readers {
+		if txid < oldest {
+			oldest = txid
+		}
+	}
+	for txid, pages := range tree.pending {
+		if txid <= oldest {
+			tree.free = append(tree.free, pages...)
+			delete(tree.pending, txid)
+		}
+	}
+}
+
+// Close tree.
+// All transactions must be finished before.
+func (tree *BTree) Close() error {
+	tree.writer.Lock()
+	defer tree.writer.Unlock()
+	tree.mutex.Lock()
+	defer tree.mutex.Unlock()
+	if tree.data == nil {
+		return &ErrorClosed{}
+	}
+	if err := tree.mapping.Close(); err != nil {
+		return err
+	}
+	tree.data = nil
+	return tree.file.Close()
+}
+
+// Check whether transaction is usable.
+func (tx *BTreeTx) check(write bool) error {
+	if tx.done {
+		return &ErrorClosed{}
+	}
+	if write && !tx.writable {
+		return &ErrorNotAllowed{Operation: "write in read-only transaction"}
+	}
+	return nil
+}
+
+// Get node of given page number.
+func (tx *BTreeTx) node(pgno uint64) (*btreeNode, error) {
+	if node, ok := tx.dirty[pgno]; ok {
+		return node, nil
+	}
+	page, err := tx.tree.page(pgno, tx.meta.pages)
+	if err != nil {
+		return nil, err
+	}
+	return decodeBtreeNode(page, pgno)
+}
+
+// Allocate page.
+func (tx *BTreeTx) alloc() (uint64, error) {
+	var pgno uint64
+	if n := len(tx.free); n > 0 {
+		pgno, tx.free = tx.free[n-1], tx.free[:n-1]
+	} else {
+		if tx.meta.pages >= tx.tree.maxPages {
+			return 0, &ErrorNoSpace{Size: syspack.Size(tx.tree.pageSize)}
+		}
+		pgno = tx.meta.pages
+		tx.meta.pages++
+	}
+	tx.allocated[pgno] = true
+	return pgno, nil
+}
+
+// Release page.
+// Page allocated by this transaction is reusable at once, other ones after commit.
+func (tx *BTreeTx) release(pgno uint64) {
+	if tx.allocated[pgno] {
+		delete(tx.allocated, pgno)
+		delete(tx.dirty, pgno)
+		tx.free = append(tx.free, pgno)
+		return
+	}
+	tx.freed = append(tx.freed, pgno)
+}
+
+// Make node of given page number dirty copying it to new page if necessary.
+func (tx *BTreeTx) touch(pgno uint64) (uint64, *btreeNode, error) {
+	if node, ok := tx.dirty[pgno]; ok {
+		return pgno, node, nil
+	}
+	node, err := tx.node(pgno)
+	if err != nil {
+		return 0, nil, err
+	}
+	newPgno, err := tx.alloc()
+	if err != nil {
+		return 0, nil, err
+	}
+	tx.release(pgno)
+	tx.dirty[newPgno] = node
+	return newPgno, node, nil
+}
+
+// Get number of entries.
+func (tx *BTreeTx) Len() uint64 {
+	return tx.meta.entries
+}
+
+// Get value of key.
+// Returns false if there is no such key.
+func (tx *BTreeTx) Get(key []byte) ([]byte, bool, error) {
+	if err := tx.check(false); err != nil {
+		return nil, false, err
+	}
+	for pgno := tx.meta.root; pgno != 0; {
+		node, err := tx.node(pgno)
+		if err != nil {
+			return nil, false, err
+		}
+		index, found := node.search(key)
+		if node.leaf {
+			if !found {
+				return nil, false, nil
+			}
+			return node.values[index], true, nil
+		}
+		pgno = node.children[index]
+	}
+	return nil, false, nil
+}
+
+// Call function for every entry with key in [start, end) in key order.
+// Nil start or end means unbounded range, iteration stops when function returns false.
+func (tx *BTreeTx) Scan(start, end []byte, function func(key, value []byte) bool) error {
+	if err := tx.check(false); err != nil {
+		return err
+	}
+	if tx.meta.root == 0 {
+		return nil
+	}
+	_, err := tx.scan(tx.meta.root, start, end, function)
+	return err
+}
+
+// Scan subtree of given page number.
+// Returns false if iteration is stopped.
+func (tx *BTreeTx) scan(pgno uint64, start, end []byte, function func(key, value []byte) bool) (bool, error) {
+	node, err := tx.node(pgno)
+	if err != nil {
+		return false, err
+	}
+	index := 0
+	if start != nil {
+		index, _ = node.search(start)
+	}
+	for ; index < len(node.keys); index++ {
+		if end != nil && (node.leaf || index > 0) && string(node.keys[index]) >= string(end) {
+			return false, nil
+		}
+		if node.leaf {
+			if !function(node.keys[index], node.values[index]) {
+				return false, nil
+			}
+			continue
+		}
+		if next, err := tx.scan(node.children[index], start, end, function); !next || err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Put value of key.
+func (tx *BTreeTx) Put(key, value []byte) error {
+	if err := tx.check(true); err != nil {
+		return err
+	}
+	if len(key)+len(value) > tx.tree.MaxEntrySize() {
+		return &ErrorInvalidSize{Size: syspack.Size(len(key) + len(value))}
+	}
+	key = append([]byte(nil), key...)
+	value = append([]byte(nil), value...)
+	if tx.meta.root == 0 {
+		pgno, err := tx.alloc()
+		if err != nil {
+			return err
+		}
+		tx.dirty[pgno] = &btreeNode{leaf: true, keys: [][]byte{key}, values: [][]byte{value}}
+		tx.meta.root = pgno
+		tx.meta.entries++
+		return nil
+	}
+	root, splitKey, splitPgno, added, err := tx.put(tx.meta.root, key, value)
+	if err != nil {
+		return err
+	}
+	if splitPgno != 0 {
+		if root, err = tx.alloc(); err != nil {
+			return err
+		}
+		tx.dirty[root] = &btreeNode{
+			keys:     [][]byte{nil, splitKey},
+			children: []uint64{tx.meta.root, splitPgno},
+		}
+	}
+	tx.meta.root = root
+	if added {
+		tx.meta.entries++
+	}
+	return nil
+}
+
+// Put value of key into subtree of given page number.
+// Returns new page number of subtree root and split right sibling if any.
+func (tx *BTreeTx) put(pgno uint64, key, value []byte) (uint64, []byte, uint64, bool, error) {
+	pgno, node, err := tx.touch(pgno)
+	if err != nil {
+		return 0, nil, 0, false, err
+	}
+	index, found := node.search(key)
+	added := !found
+	if node.leaf {
+		if found {
+			node.values[index] = value
+		} else {
+			node.insert(index, key, value, 0)
+		}
+	} else {
+		child, splitKey, splitPgno, childAdded, err := tx.put(node.children[index], key, value)
+		if err != nil {
+			return 0, nil, 0, false, err
+		}
+		node.children[index] = child
+		if splitPgno != 0 {
+			node.insert(index+1, splitKey, nil, splitPgno)
+		}
+		added = childAdded
+	}
+	if node.size() <= tx.tree.pageSize {
+		return pgno, nil, 0, added, nil
+	}
+	right := node.split()
+	rightPgno, err := tx.alloc()
+	if err != nil {
+		return 0, nil, 0, false, err
+	}
+	tx.dirty[rightPgno] = right
+	return pgno, right.keys[0], rightPgno, added, nil
+}
+
+// Delete key.
+// Returns false if there is no such key.
+// Empty pages are released, underfull ones are not merged.
+func (tx *BTreeTx) Delete(key []byte) (bool, error) {
+	if err := tx.check(true); err != nil {
+		return false, err
+	}
+	if _, found, err := tx.Get(key); !found || err != nil {
+		return false, err
+	}
+	root, empty, err := tx.delete(tx.meta.root, key)
+	if err != nil {
+		return false, err
+	}
+	tx.meta.entries--
+	if empty {
+		tx.release(root)
+		tx.meta.root = 0
+		return true, nil
+	}
+	for {
+		node, err := tx.node(root)
+		if err != nil {
+			return false, err
+		}
+		if node.leaf || len(node.children) > 1 {
+			break
+		}
+		tx.release(root)
+		root = node.children[0]
+	}
+	tx.meta.root = root
+	return true, nil
+}
+
+// Delete existing key from subtree of given page number.
+// Returns new page number of subtree root and whether subtree became empty.
+func (tx *BTreeTx) delete(pgno uint64, key []byte) (uint64, bool, error) {
+	pgno, node, err := tx.touch(pgno)
+	if err != nil {
+		return 0, false, err
+	}
+	index, _ := node.search(key)
+	if node.leaf {
+		node.remove(index)
+		return pgno, len(node.keys) == 0, nil
+	}
+	child, empty, err := tx.delete(node.children[index], key)
+	if err != nil {
+		return 0, false, err
+	}
+	if empty {
+		tx.release(child)
+		node.remove(index)
+	} else {
+		node.children[index] = child
+	}
+	return pgno, len(node.keys) == 0, nil
+}
+
+// Commit transaction.
+// Dirty pages are written and synced before meta page, so crash leaves either old or new tree.
+func (tx *BTreeTx) Commit() error {
+	if err := tx.check(true); err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	tree := tx.tree
+	tree.mutex.Lock()
+	freed := append(tx.freed, tree.freelistPages...)
+	var pending []uint64
+	for _, pages := range tree.pending {
+		pending = append(pending, pages...)
+	}
+	tree.mutex.Unlock()
+	perPage := (tree.pageSize - btreePageHeaderSize) / btreeFreelistEntry
+	var free, freelistPages []uint64
+	for {
+		free = append(append(append(free[:0], tx.free...), freed...), pending...)
+		if len(freelistPages)*perPage >= len(free) {
+			break
+		}
+		pgno, err := tx.alloc()
+		if err != nil {
+			return err
+		}
+		freelistPages = append(freelistPages, pgno)
+	}
+	meta := tx.meta
+	meta.txid++
+	meta.freelist = 0
+	if len(freelistPages) > 0 {
+		meta.freelist = freelistPages[0]
+	}
+	page := make([]byte, tree.pageSize)
+	for pgno, node := range tx.dirty {
+		node.encode(page)
+		if _, err := tree.file.WriteAt(page, int64(pgno)*int64(tree.pageSize)); err != nil {
+			return err
+		}
+	}
+	for i, pgno := range freelistPages {
+		var entries []uint64
+		if i*perPage < len(free) {
+			entries = free[i*perPage:]
+		}
+		if len(entries) > perPage {
+			entries = entries[:perPage]
+		}
+		for j := range page[:btreePageHeaderSize] {
+			page[j] = 0
+		}
+		binary.LittleEndian.PutUint16(page[btreePageFlagsOffset:], btreeFreelistPage)
+		binary.LittleEndian.PutUint16(page[btreePageCountOffset:], uint16(len(entries)))
+		if i+1 < len(freelistPages) {
+			binary.LittleEndian.PutUint64(page[btreePageNextOffset:], freelistPages[i+1])
+		}
+		for j, entry := range entries {
+			binary.LittleEndian.PutUint64(page[btreePageHeaderSize+j*btreeFreelistEntry:], entry)
+		}
+		if _, err := tree.file.WriteAt(page, int64(pgno)*int64(tree.pageSize)); err != nil {
+			return err
+		}
+	}
+	if err := tree.file.Sync(); err != nil {
+		return err
+	}
+	meta.encode(page, tree.pageSize)
+	if _, err := tree.file.WriteAt(page[:btreeMetaSize], int64(meta.txid%btreeMetaPages)*int64(tree.pageSize)); err != nil {
+		return err
+	}
+	if err := tree.file.Sync(); err != nil {
+		return err
+	}
+	tree.mutex.Lock()
+	tree.meta = meta
+	tree.free = tx.free
+	if len(freed) > 0 {
+		tree.pending[meta.txid] = freed
+	}
+	tree.freelistPages = freelistPages
+	tree.mutex.Unlock()
+	return nil
+}
+
+// Finish transaction discarding changes.
+func (tx *BTreeTx) Rollback() error {
+	if tx.done {
+		return &ErrorClosed{}
+	}
+	tx.done = true
+	tx.dirty = nil
+	if tx.writable {
+		tx.tree.writer.Unlock()
+		return nil
+	}
+	tx.tree.mutex.Lock()
+	if tx.tree.readers[tx.meta.txid]--; tx.tree.readers[tx.meta.txid] == 0 {
+		delete(tx.tree.readers, tx.meta.txid)
+	}
+	tx.tree.mutex.Unlock()
+	return nil
+}
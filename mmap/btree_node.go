@@ -0,0 +1,205 @@
+package mmap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+// B+tree page flags.
+const (
+	btreeBranchPage = 1 << iota
+	btreeLeafPage
+	btreeFreelistPage
+)
+
+// B+tree page layout.
+// Page header is followed by entry offsets, entries are packed after them.
+const (
+	btreePageFlagsOffset = 0
+	btreePageCountOffset = 2
+	btreePageNextOffset  = 8
+	btreePageHeaderSize  = 16
+	btreeOffsetSize      = 2
+	btreeLeafEntrySize   = 4
+	btreeBranchEntrySize = 10
+	btreeFreelistEntry   = 8
+)
+
+type btreeNode struct {
+	// Decoded B+tree page.
+	// Keys and values of clean nodes refer directly to mapping.
+
+	// Node is leaf.
+	leaf bool
+
+	// Keys, the first key of branch is lower bound only.
+	keys [][]byte
+
+	// Values of leaf.
+	values [][]byte
+
+	// Children of branch.
+	children []uint64
+}
+
+// Get encoded size of entry at given index.
+func (node *btreeNode) entrySize(index int) int {
+	if node.leaf {
+		return btreeOffsetSize + btreeLeafEntrySize + len(node.keys[index]) + len(node.values[index])
+	}
+	return btreeOffsetSize + btreeBranchEntrySize + len(node.keys[index])
+}
+
+// Get encoded size of node.
+func (node *btreeNode) size() int {
+	size := btreePageHeaderSize
+	for i := range node.keys {
+		size += node.entrySize(i)
+	}
+	return size
+}
+
+// Find index of key in leaf or index of child which may contain key in branch.
+func (node *btreeNode) search(key []byte) (int, bool) {
+	if node.leaf {
+		index := sort.Search(len(node.keys), func(i int) bool {
+			return bytes.Compare(node.keys[i], key) >= 0
+		})
+		return index, index < len(node.keys) && bytes.Equal(node.keys[index], key)
+	}
+	index := sort.Search(len(node.keys)-1, func(i int) bool {
+		return bytes.Compare(node.keys[i+1], key) > 0
+	})
+	return index, true
+}
+
+// Insert entry at given index.
+func (node *btreeNode) insert(index int, key, value []byte, child uint64) {
+	node.keys = append(node.keys, nil)
+	copy(node.keys[index+1:], node.keys[index:])
+	node.keys[index] = key
+	if node.leaf {
+		node.values = append(node.values, nil)
+		copy(node.values[index+1:], node.values[index:])
+		node.values[index] = value
+	} else {
+		node.children = append(node.children, 0)
+		copy(node.children[index+1:], node.children[index:])
+		node.children[index] = child
+	}
+}
+
+// Remove entry at given index.
+func (node *btreeNode) remove(index int) {
+	node.keys = append(node.keys[:index], node.keys[index+1:]...)
+	if node.leaf {
+		node.values = append(node.values[:index], node.values[index+1:]...)
+	} else {
+		node.children = append(node.children[:index], node.children[index+1:]...)
+	}
+}
+
+// Split node in halves by size returning the right one.
+func (node *btreeNode) split() *btreeNode {
+	half, size, index := node.size()/2, btreePageHeaderSize, 0
+	for index < len(node.keys)-1 {
+		size += node.entrySize(index)
+		index++
+		if size >= half {
+			break
+		}
+	}
+	right := &btreeNode{leaf: node.leaf}
+	right.keys = append(right.keys, node.keys[index:]...)
+	node.keys = node.keys[:index:index]
+	if node.leaf {
+		right.values = append(right.values, node.values[index:]...)
+		node.values = node.values[:index:index]
+	} else {
+		right.children = append(right.children, node.children[index:]...)
+		node.children = node.children[:index:index]
+	}
+	return right
+}
+
+// Encode node into page.
+func (node *btreeNode) encode(page []byte) {
+	for i := range page[:btreePageHeaderSize] {
+		page[i] = 0
+	}
+	flags := uint16(btreeBranchPage)
+	if node.leaf {
+		flags = btreeLeafPage
+	}
+	binary.LittleEndian.PutUint16(page[btreePageFlagsOffset:], flags)
+	binary.LittleEndian.PutUint16(page[btreePageCountOffset:], uint16(len(node.keys)))
+	offset := btreePageHeaderSize + btreeOffsetSize*len(node.keys)
+	for i, key := range node.keys {
+		binary.LittleEndian.PutUint16(page[btreePageHeaderSize+btreeOffsetSize*i:], uint16(offset))
+		if node.leaf {
+			binary.LittleEndian.PutUint16(page[offset:], uint16(len(key)))
+			binary.LittleEndian.PutUint16(page[offset+2:], uint16(len(node.values[i])))
+			offset += btreeLeafEntrySize
+			offset += copy(page[offset:], key)
+			offset += copy(page[offset:], node.values[i])
+		} else {
+			binary.LittleEndian.PutUint64(page[offset:], node.children[i])
+			binary.LittleEndian.PutUint16(page[offset+8:], uint16(len(key)))
+			offset += btreeBranchEntrySize
+			offset += copy(page[offset:], key)
+		}
+	}
+}
+
+// Decode page of given number into node.
+func decodeBtreeNode(page []byte, pgno uint64) (*btreeNode, error) {
+	corrupted := &ErrorInvalidOffset{Offset: syspack.Offset(pgno) * syspack.Offset(len(page))}
+	flags := binary.LittleEndian.Uint16(page[btreePageFlagsOffset:])
+	count := int(binary.LittleEndian.Uint16(page[btreePageCountOffset:]))
+	if flags != btreeLeafPage && flags != btreeBranchPage {
+		return nil, corrupted
+	}
+	if btreePageHeaderSize+btreeOffsetSize*count > len(page) {
+		return nil, corrupted
+	}
+	node := &btreeNode{leaf: flags == btreeLeafPage, keys: make([][]byte, count)}
+	if node.leaf {
+		node.values = make([][]byte, count)
+	} else {
+		node.children = make([]uint64, count)
+	}
+	for i := 0; i < count; i++ {
+		offset := int(binary.LittleEndian.Uint16(page[btreePageHeaderSize+btreeOffsetSize*i:]))
+		if node.leaf {
+			if offset+btreeLeafEntrySize > len(page) {
+				return nil, corrupted
+			}
+			keyLength := int(binary.LittleEndian.Uint16(page[offset:]))
+			valueLength := int(binary.LittleEndian.Uint16(page[offset+2:]))
+			start := offset + btreeLeafEntrySize
+			if start+keyLength+valueLength > len(page) {
+				return nil, corrupted
+			}
+			node.keys[i] = page[start : start+keyLength : start+keyLength]
+			node.values[i] = page[start+keyLength : start+keyLength+valueLength : start+keyLength+valueLength]
+		} else {
+			if offset+btreeBranchEntrySize > len(page) {
+				return nil, corrupted
+			}
+			node.children[i] = binary.LittleEndian.Uint64(page[offset:])
+			keyLength := int(binary.LittleEndian.Uint16(page[offset+8:]))
+			start := offset + btreeBranchEntrySize
+			if start+keyLength > len(page) {
+				return nil, corrupted
+			}
+			node.keys[i] = page[start : start+keyLength : start+keyLength]
+		}
+	}
+	if count == 0 && !node.leaf {
+		return nil, corrupted
+	}
+	return node, nil
+}
@@ -0,0 +1,255 @@
+package mmap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testBTreePath = filepath.Join(os.TempDir(), "test.btree")
+
+func makeTestKey(i int) []byte {
+	return []byte(fmt.Sprintf("key%05d", i))
+}
+
+func TestBTree(t *testing.T) {
+	os.Remove(testBTreePath)
+	defer os.Remove(testBTreePath)
+	tree, err := OpenBTree(testBTreePath, &BTreeOptions{MapSize: 1 << 24})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	const count = 2000
+	tx, err := tree.Begin(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < count; i++ {
+		if err := tx.Put(makeTestKey(i), makeTestMessage(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := tree.Begin(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snapshot.Rollback()
+	if tx, err = tree.Begin(true); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < count; i += 2 {
+		if ok, err := tx.Delete(makeTestKey(i)); err != nil || !ok {
+			t.Fatalf("key %d must be deleted, %v %v found", i, ok, err)
+		}
+	}
+	if err := tx.Put(makeTestKey(1), testBuffer); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Len() != count {
+		t.Fatalf("snapshot length must be a %d, %d found", count, snapshot.Len())
+	}
+	for i := 0; i < count; i++ {
+		value, ok, err := snapshot.Get(makeTestKey(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok || bytes.Compare(value, makeTestMessage(i)) != 0 {
+			t.Fatalf("snapshot key %d must have initial value, %v found", i, ok)
+		}
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if tree, err = OpenBTree(testBTreePath, &BTreeOptions{MapSize: 1 << 24}); err != nil {
+		t.Fatal(err)
+	}
+	if tx, err = tree.Begin(false); err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if tx.Len() != count/2 {
+		t.Fatalf("length must be a %d, %d found", count/2, tx.Len())
+	}
+	i := 1
+	err = tx.Scan(nil, nil, func(key, value []byte) bool {
+		expected := makeTestMessage(i)
+		if i == 1 {
+			expected = testBuffer
+		}
+		if bytes.Compare(key, makeTestKey(i)) != 0 || bytes.Compare(value, expected) != 0 {
+			t.Fatalf("entry %d must be found, %q found", i, key)
+		}
+		i += 2
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != count+1 {
+		t.Fatalf("%d entries must be scanned, %d found", count/2, i/2)
+	}
+	var keys []string
+	err = tx.Scan(makeTestKey(100), makeTestKey(110), func(key, value []byte) bool {
+		keys = append(keys, string(key))
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 5 || keys[0] != string(makeTestKey(101)) || keys[4] != string(makeTestKey(109)) {
+		t.Fatalf("range must contain odd keys from 101 to 109, %v found", keys)
+	}
+	if err := tx.Put(makeTestKey(0), nil); err == nil {
+		t.Fatal("write in read-only transaction must fail")
+	}
+}
+
+func TestBTreeRollback(t *testing.T) {
+	os.Remove(testBTreePath)
+	defer os.Remove(testBTreePath)
+	tree, err := OpenBTree(testBTreePath, &BTreeOptions{MapSize: 1 << 24})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	tx, err := tree.Begin(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Put(testBuffer, testBuffer); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if tx, err = tree.Begin(false); err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if _, ok, err := tx.Get(testBuffer); ok || err != nil {
+		t.Fatalf("key must not be found, %v %v found", ok, err)
+	}
+}
+
+func TestBTreePageReuse(t *testing.T) {
+	os.Remove(testBTreePath)
+	defer os.Remove(testBTreePath)
+	tree, err := OpenBTree(testBTreePath, &BTreeOptions{MapSize: 1 << 24})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	for i := 0; i < 1000; i++ {
+		tx, err := tree.Begin(true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Put(makeTestKey(i%100), makeTestMessage(i)); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tree.meta.pages > 64 {
+		t.Fatalf("freed pages must be reused, %d pages found", tree.meta.pages)
+	}
+}
+
+func TestBTreeTornMeta(t *testing.T) {
+	os.Remove(testBTreePath)
+	defer os.Remove(testBTreePath)
+	tree, err := OpenBTree(testBTreePath, &BTreeOptions{MapSize: 1 << 24})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		tx, err := tree.Begin(true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Put(makeTestKey(i), makeTestMessage(i)); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pageSize := tree.pageSize
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.OpenFile(testBTreePath, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteAt([]byte{0xff}, btreeEntriesOffset); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	// Page size of file must be used regardless of option.
+	if tree, err = OpenBTree(testBTreePath, &BTreeOptions{PageSize: pageSize / 2, MapSize: 1 << 24}); err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	tx, err := tree.Begin(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if _, ok, _ := tx.Get(makeTestKey(1)); ok {
+		t.Fatal("torn commit must be discarded")
+	}
+	if _, ok, _ := tx.Get(makeTestKey(0)); !ok {
+		t.Fatal("previous commit must be found")
+	}
+}
+
+func TestBTreeFreelistCycle(t *testing.T) {
+	os.Remove(testBTreePath)
+	defer os.Remove(testBTreePath)
+	tree, err := OpenBTree(testBTreePath, &BTreeOptions{MapSize: 1 << 24})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; tree.meta.freelist == 0; i++ {
+		tx, err := tree.Begin(true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Put(makeTestKey(i), makeTestMessage(i)); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	freelist, pageSize := tree.meta.freelist, tree.pageSize
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.OpenFile(testBTreePath, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := make([]byte, 8)
+	binary.LittleEndian.PutUint64(next, freelist)
+	if _, err := file.WriteAt(next, int64(freelist)*int64(pageSize)+btreePageNextOffset); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	if tree, err = OpenBTree(testBTreePath, &BTreeOptions{MapSize: 1 << 24}); err == nil {
+		tree.Close()
+		t.Fatal("cyclic free list must be rejected")
+	}
+}
@@ -0,0 +1,328 @@
+package mmap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+	"os"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+// Constant database format.
+const (
+	cdbMagic   = 0x31424443 // "CDB1"
+	cdbVersion = 1
+)
+
+// Constant database header layout.
+// Header checksum covers header bytes preceding it, data checksum covers records and table.
+const (
+	cdbMagicOffset          = 0
+	cdbVersionOffset        = 4
+	cdbRecordsOffset        = 8
+	cdbTableOffset          = 16
+	cdbSlotsOffset          = 24
+	cdbDataChecksumOffset   = 56
+	cdbHeaderChecksumOffset = 60
+	cdbHeaderSize           = cacheLineSize
+)
+
+// Constant database record and table layout.
+// Empty table slot has zero record offset.
+const (
+	cdbRecordHeaderSize = 8
+	cdbSlotSize         = 16
+)
+
+// Castagnoli table.
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+type cdbEntry struct {
+	// Constant database table entry.
+
+	// Key hash.
+	hash uint64
+
+	// Record offset.
+	offset uint64
+}
+
+type CDBWriter struct {
+	// Constant database writer.
+
+	// File.
+	file *os.File
+
+	// Buffered writer.
+	writer *bufio.Writer
+
+	// Data checksum.
+	checksum hash.Hash32
+
+	// Written offset.
+	offset uint64
+
+	// Table entries.
+	entries []cdbEntry
+}
+
+// Create constant database file.
+func CreateCDB(path string) (*CDBWriter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return nil, err
+	}
+	writer := &CDBWriter{
+		file:     file,
+		writer:   bufio.NewWriter(file),
+		checksum: crc32.New(castagnoli),
+		offset:   cdbHeaderSize,
+	}
+	if _, err := writer.writer.Write(make([]byte, cdbHeaderSize)); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return writer, nil
+}
+
+// Write bytes to file updating data checksum.
+func (writer *CDBWriter) write(data []byte) error {
+	writer.checksum.Write(data)
+	n, err := writer.writer.Write(data)
+	writer.offset += uint64(n)
+	return err
+}
+
+// Put record.
+// Records with equal keys are all stored, Get returns the first one.
+func (writer *CDBWriter) Put(key, value []byte) error {
+	if writer.file == nil {
+		return &ErrorClosed{}
+	}
+	if uint64(len(key)) > uint64(syspack.MaxDword) {
+		return &ErrorInvalidSize{Size: syspack.Len(key)}
+	}
+	if uint64(len(value)) > uint64(syspack.MaxDword) {
+		return &ErrorInvalidSize{Size: syspack.Len(value)}
+	}
+	writer.entries = append(writer.entries, cdbEntry{hash: hashBytes(0, key), offset: writer.offset})
+	var header [cdbRecordHeaderSize]byte
+	binary.LittleEndian.PutUint32(header[:4], uint32(len(key)))
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(value)))
+	if err := writer.write(header[:]); err != nil {
+		return err
+	}
+	if err := writer.write(key); err != nil {
+		return err
+	}
+	return writer.write(value)
+}
+
+// Finish database writing hash table and header and close file.
+func (writer *CDBWriter) Close() error {
+	if writer.file == nil {
+		return &ErrorClosed{}
+	}
+	defer func() {
+		writer.file.Close()
+		writer.file = nil
+	}()
+	slots := uint64(1)
+	for slots < 2*uint64(len(writer.entries)) {
+		slots <<= 1
+	}
+	table := make([]byte, slots*cdbSlotSize)
+	for _, entry := range writer.entries {
+		for slot := entry.hash & (slots - 1); ; slot = (slot + 1) & (slots - 1) {
+			start := slot * cdbSlotSize
+			if binary.LittleEndian.Uint64(table[start+8:]) == 0 {
+				binary.LittleEndian.PutUint64(table[start:], entry.hash)
+				binary.LittleEndian.PutUint64(table[start+8:], entry.offset)
+				break
+			}
+		}
+	}
+	tableOffset := writer.offset
+	if err := writer.write(table); err != nil {
+		return err
+	}
+	if err := writer.writer.Flush(); err != nil {
+		return err
+	}
+	header := make([]byte, cdbHeaderSize)
+	binary.LittleEndian.PutUint32(header[cdbMagicOffset:], cdbMagic)
+	binary.LittleEndian.PutUint32(header[cdbVersionOffset:], cdbVersion)
+	binary.LittleEndian.PutUint64(header[cdbRecordsOffset:], uint64(len(writer.entries)))
+	binary.LittleEndian.PutUint64(header[cdbTableOffset:], tableOffset)
+	binary.LittleEndian.PutUint64(header[cdbSlotsOffset:], slots)
+	binary.LittleEndian.PutUint32(header[cdbDataChecksumOffset:], writer.checksum.Sum32())
+	binary.LittleEndian.PutUint32(
+		header[cdbHeaderChecksumOffset:],
+		crc32.Checksum(header[:cdbHeaderChecksumOffset], castagnoli),
+	)
+	if _, err := writer.file.WriteAt(header, 0); err != nil {
+		return err
+	}
+	return writer.file.Sync()
+}
+
+type CDB struct {
+	// Constant database reader over read-only mapping.
+	// Returned keys and values refer directly to mapping and are valid until database is closed.
+
+	// Mapping.
+	mapping *Mapping
+
+	// Data.
+	data []byte
+
+	// Number of records.
+	records uint64
+
+	// Table offset.
+	table uint64
+
+	// Number of table slots.
+	slots uint64
+}
+
+// Open constant database file.
+func OpenCDB(path string) (*CDB, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < cdbHeaderSize || info.Size() > int64(syspack.MaxInt) {
+		return nil, &ErrorInvalidSize{Size: syspack.Size(info.Size())}
+	}
+	mapping, err := NewMapping(file.Fd(), 0, syspack.Size(info.Size()), &Options{Mode: ModeReadOnly})
+	if err != nil {
+		return nil, err
+	}
+	data, _ := mapping.Direct(0, syspack.Offset(mapping.Len()))
+	db := &CDB{mapping: mapping, data: data}
+	if err := db.parseHeader(); err != nil {
+		mapping.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Parse and validate header.
+func (db *CDB) parseHeader() error {
+	header := db.data[:cdbHeaderSize]
+	checksum := crc32.Checksum(header[:cdbHeaderChecksumOffset], castagnoli)
+	if expected := binary.LittleEndian.Uint32(header[cdbHeaderChecksumOffset:]); checksum != expected {
+		return &ErrorChecksum{Checksum: checksum, Expected: expected}
+	}
+	if magic := binary.LittleEndian.Uint32(header[cdbMagicOffset:]); magic != cdbMagic {
+		return &ErrorBadMagic{Magic: magic}
+	}
+	if version := binary.LittleEndian.Uint32(header[cdbVersionOffset:]); version != cdbVersion {
+		return &ErrorVersionMismatch{Version: version, Expected: cdbVersion}
+	}
+	db.records = binary.LittleEndian.Uint64(header[cdbRecordsOffset:])
+	db.table = binary.LittleEndian.Uint64(header[cdbTableOffset:])
+	db.slots = binary.LittleEndian.Uint64(header[cdbSlotsOffset:])
+	size := uint64(len(db.data))
+	if db.slots == 0 || db.slots&(db.slots-1) != 0 || db.records >= db.slots ||
+		db.table < cdbHeaderSize || db.table > size || (size-db.table)/cdbSlotSize != db.slots {
+		return &ErrorInvalidSize{Size: syspack.Size(size)}
+	}
+	return nil
+}
+
+// Get number of records.
+func (db *CDB) Len() uint64 {
+	return db.records
+}
+
+// Get record at given offset.
+func (db *CDB) record(offset uint64) ([]byte, []byte, uint64, error) {
+	if offset < cdbHeaderSize || offset > db.table || db.table-offset < cdbRecordHeaderSize {
+		return nil, nil, 0, &ErrorInvalidOffset{Offset: syspack.Offset(offset)}
+	}
+	keyLength := uint64(binary.LittleEndian.Uint32(db.data[offset:]))
+	valueLength := uint64(binary.LittleEndian.Uint32(db.data[offset+4:]))
+	start := offset + cdbRecordHeaderSize
+	end := start + keyLength + valueLength
+	if end > db.table {
+		return nil, nil, 0, &ErrorInvalidOffset{Offset: syspack.Offset(offset)}
+	}
+	return db.data[start : start+keyLength : start+keyLength], db.data[start+keyLength : end : end], end, nil
+}
+
+// Get value of key.
+// Returns false if there is no such key.
+func (db *CDB) Get(key []byte) ([]byte, bool, error) {
+	if db.data == nil {
+		return nil, false, &ErrorClosed{}
+	}
+	hash := hashBytes(0, key)
+	for i, slot := uint64(0), hash&(db.slots-1); i < db.slots; i, slot = i+1, (slot+1)&(db.slots-1) {
+		start := db.table + slot*cdbSlotSize
+		offset := binary.LittleEndian.Uint64(db.data[start+8:])
+		if offset == 0 {
+			break
+		}
+		if binary.LittleEndian.Uint64(db.data[start:]) != hash {
+			continue
+		}
+		recordKey, value, _, err := db.record(offset)
+		if err != nil {
+			return nil, false, err
+		}
+		if string(recordKey) == string(key) {
+			return value, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// Call function for every record in insertion order.
+// Iteration stops on first error returned by function.
+func (db *CDB) ForEach(function func(key, value []byte) error) error {
+	if db.data == nil {
+		return &ErrorClosed{}
+	}
+	offset := uint64(cdbHeaderSize)
+	for i := uint64(0); i < db.records; i++ {
+		key, value, next, err := db.record(offset)
+		if err != nil {
+			return err
+		}
+		if err := function(key, value); err != nil {
+			return err
+		}
+		offset = next
+	}
+	return nil
+}
+
+// Verify checksum of records and table.
+func (db *CDB) Verify() error {
+	if db.data == nil {
+		return &ErrorClosed{}
+	}
+	checksum := crc32.Checksum(db.data[cdbHeaderSize:], castagnoli)
+	if expected := binary.LittleEndian.Uint32(db.data[cdbDataChecksumOffset:]); checksum != expected {
+		return &ErrorChecksum{Checksum: checksum, Expected: expected}
+	}
+	return nil
+}
+
+// Close database.
+func (db *CDB) Close() error {
+	if err := db.mapping.Close(); err != nil {
+		return err
+	}
+	db.data = nil
+	return nil
+}
@@ -0,0 +1,92 @@
+package mmap
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testCDBPath = filepath.Join(os.TempDir(), "test.cdb")
+
+func TestCDB(t *testing.T) {
+	defer os.Remove(testCDBPath)
+	writer, err := CreateCDB(testCDBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const count = 1000
+	for i := 0; i < count; i++ {
+		if err := writer.Put([]byte(fmt.Sprint("key", i)), makeTestMessage(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err := OpenCDB(testCDBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	if db.Len() != count {
+		t.Fatalf("length must be a %d, %d found", count, db.Len())
+	}
+	for i := 0; i < count; i++ {
+		value, ok, err := db.Get([]byte(fmt.Sprint("key", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if message := makeTestMessage(i); !ok || bytes.Compare(value, message) != 0 {
+			t.Fatalf("value %d must be a %v, %v found", i, message, value)
+		}
+	}
+	if _, ok, err := db.Get([]byte("missing")); err != nil || ok {
+		t.Fatalf("missing key must not be found, %v %v found", ok, err)
+	}
+	i := 0
+	err = db.ForEach(func(key, value []byte) error {
+		if expected := fmt.Sprint("key", i); string(key) != expected {
+			return fmt.Errorf("key must be a %q, %q found", expected, key)
+		}
+		i++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != count {
+		t.Fatalf("%d records must be iterated, %d found", count, i)
+	}
+}
+
+func TestCDBCorrupted(t *testing.T) {
+	defer os.Remove(testCDBPath)
+	writer, err := CreateCDB(testCDBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Put(testBuffer, testBuffer); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.OpenFile(testCDBPath, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteAt([]byte{2}, cdbRecordsOffset); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenCDB(testCDBPath); err == nil {
+		t.Fatal("expected checksum mismatch, no error found")
+	} else if _, ok := err.(*ErrorChecksum); !ok {
+		t.Fatalf("expected checksum mismatch, [%v] error found", err)
+	}
+}
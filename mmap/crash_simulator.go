@@ -0,0 +1,170 @@
+package mmap
+
+import (
+	"math/rand"
+	"os"
+	"sync"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+type CrashSimulator struct {
+	// Power loss model of mapping for durability tests.
+	// Simulator tracks durable image of mapping, which is updated by every successful sync.
+	// Crash image consists of durable image with arbitrary subset of unsynced pages applied,
+	// since the system may write back dirty pages at any moment and in any order.
+	// Writes made by other means than mapping, e.g. through file, are not tracked.
+
+	// Mapping.
+	mapping *Mapping
+
+	// Durable image.
+	durable []byte
+
+	// Number of syncs.
+	syncs int
+
+	// Function called before sync is applied to durable image.
+	onSync func()
+
+	// Lock.
+	mutex sync.Mutex
+}
+
+// Attach crash simulator to mapping considering its current content durable.
+// Only one simulator may be attached to mapping.
+func NewCrashSimulator(mapping *Mapping) (*CrashSimulator, error) {
+	data, err := mapping.Direct(0, syspack.Off(mapping.data))
+	if err != nil {
+		return nil, err
+	}
+	simulator := &CrashSimulator{mapping: mapping, durable: append([]byte(nil), data...)}
+	if !mapping.setSyncHook(simulator.syncing) {
+		return nil, &ErrorNotAllowed{Operation: "simulator attachment"}
+	}
+	return simulator, nil
+}
+
+// Get page-aligned offset range of mapping covering offset range [low, high).
+func (simulator *CrashSimulator) pageRange(low, high syspack.Offset) (syspack.Offset, syspack.Offset) {
+	pageSize := syspack.Offset(os.Getpagesize())
+	inner := simulator.mapping.innerOffset()
+	low = (inner+low)/pageSize*pageSize - inner
+	high = (inner+high+pageSize-1)/pageSize*pageSize - inner
+	if low < 0 {
+		low = 0
+	}
+	if length := syspack.Offset(len(simulator.durable)); high > length {
+		high = length
+	}
+	return low, high
+}
+
+// Take copy of offset range about to be flushed.
+// Returned function applies it to durable image once range reached file.
+func (simulator *CrashSimulator) syncing(low, high syspack.Offset) func() {
+	simulator.mutex.Lock()
+	if simulator.durable == nil {
+		simulator.mutex.Unlock()
+		return func() {}
+	}
+	low, high = simulator.pageRange(low, high)
+	data := append([]byte(nil), simulator.mapping.data[low:high]...)
+	simulator.mutex.Unlock()
+	return func() {
+		simulator.mutex.Lock()
+		onSync := simulator.onSync
+		simulator.mutex.Unlock()
+		if onSync != nil {
+			onSync()
+		}
+		simulator.mutex.Lock()
+		defer simulator.mutex.Unlock()
+		if simulator.durable == nil {
+			return
+		}
+		copy(simulator.durable[low:high], data)
+		simulator.syncs++
+	}
+}
+
+// Set function called before every sync is applied to durable image.
+// Crash images made by function model power loss right before sync completion.
+func (simulator *CrashSimulator) OnSync(fn func()) {
+	simulator.mutex.Lock()
+	defer simulator.mutex.Unlock()
+	simulator.onSync = fn
+}
+
+// Get number of syncs observed.
+func (simulator *CrashSimulator) Syncs() int {
+	simulator.mutex.Lock()
+	defer simulator.mutex.Unlock()
+	return simulator.syncs
+}
+
+// Check that simulator and its mapping are not closed.
+func (simulator *CrashSimulator) check() error {
+	if simulator.durable == nil || simulator.mapping.data == nil {
+		return &ErrorClosed{}
+	}
+	return nil
+}
+
+// Get offsets of pages modified since they were synced.
+func (simulator *CrashSimulator) Unsynced() ([]syspack.Offset, error) {
+	simulator.mutex.Lock()
+	defer simulator.mutex.Unlock()
+	if err := simulator.check(); err != nil {
+		return nil, err
+	}
+	return simulator.unsynced(), nil
+}
+
+// Get offsets of unsynced pages.
+func (simulator *CrashSimulator) unsynced() []syspack.Offset {
+	var pages []syspack.Offset
+	for low, high := simulator.pageRange(0, 1); low < high; low, high = simulator.pageRange(high, high+1) {
+		if string(simulator.durable[low:high]) != string(simulator.mapping.data[low:high]) {
+			pages = append(pages, low)
+		}
+	}
+	return pages
+}
+
+// Make crash image with unsynced pages chosen by given function applied.
+// Function is called with index of page in list returned by Unsynced and its offset.
+func (simulator *CrashSimulator) CrashImage(keep func(i int, offset syspack.Offset) bool) ([]byte, error) {
+	simulator.mutex.Lock()
+	defer simulator.mutex.Unlock()
+	if err := simulator.check(); err != nil {
+		return nil, err
+	}
+	image := append([]byte(nil), simulator.durable...)
+	for i, low := range simulator.unsynced() {
+		if keep(i, low) {
+			_, high := simulator.pageRange(low, low+1)
+			copy(image[low:high], simulator.mapping.data[low:high])
+		}
+	}
+	return image, nil
+}
+
+// Make crash image with random subset of unsynced pages applied.
+func (simulator *CrashSimulator) RandomCrashImage(random *rand.Rand) ([]byte, error) {
+	return simulator.CrashImage(func(int, syspack.Offset) bool {
+		return random.Intn(2) == 0
+	})
+}
+
+// Detach simulator from mapping.
+func (simulator *CrashSimulator) Close() error {
+	simulator.mutex.Lock()
+	defer simulator.mutex.Unlock()
+	if simulator.durable == nil {
+		return &ErrorClosed{}
+	}
+	simulator.mapping.setSyncHook(nil)
+	simulator.durable = nil
+	return nil
+}
@@ -0,0 +1,160 @@
+package mmap
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+var testCrashPath = filepath.Join(os.TempDir(), "test.crash")
+
+func mapCrashImage(image []byte) (*Mapping, error) {
+	if err := ioutil.WriteFile(testCrashPath, image, 0666); err != nil {
+		return nil, err
+	}
+	file, err := os.Open(testCrashPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return NewMapping(file.Fd(), 0, syspack.Len(image), &Options{Mode: ModeReadWritePrivate})
+}
+
+func TestCrashSimulator(t *testing.T) {
+	mapping, err := makeTestMapping(ModeReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mapping.Close()
+	simulator, err := NewCrashSimulator(mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer simulator.Close()
+	if _, err := NewCrashSimulator(mapping); err == nil {
+		t.Fatal("second simulator must not be attached")
+	}
+	original, _ := mapping.Direct(0, syspack.Offset(len(testBuffer)))
+	original = append([]byte(nil), original...)
+	if _, err := mapping.WriteAt(testBuffer, 0); err != nil {
+		t.Fatal(err)
+	}
+	if unsynced, err := simulator.Unsynced(); err != nil || len(unsynced) != 1 || unsynced[0] != 0 {
+		t.Fatalf("the first page must be unsynced, %v %v found", unsynced, err)
+	}
+	dropped, err := simulator.CrashImage(func(int, syspack.Offset) bool { return false })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Compare(dropped[:len(testBuffer)], original) != 0 {
+		t.Fatal("dropped write must not reach crash image")
+	}
+	kept, err := simulator.CrashImage(func(int, syspack.Offset) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Compare(kept[:len(testBuffer)], testBuffer) != 0 {
+		t.Fatal("kept write must reach crash image")
+	}
+	if err := mapping.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if unsynced, err := simulator.Unsynced(); err != nil || simulator.Syncs() != 1 || len(unsynced) != 0 {
+		t.Fatalf("sync must be observed, %d %v %v found", simulator.Syncs(), unsynced, err)
+	}
+	if err := mapping.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := simulator.Unsynced(); err == nil {
+		t.Fatal("unsynced pages of closed mapping must not be available")
+	}
+	if _, err := simulator.CrashImage(func(int, syspack.Offset) bool { return true }); err == nil {
+		t.Fatal("crash image of closed mapping must not be available")
+	}
+}
+
+func TestCrashSimulatorDualSlot(t *testing.T) {
+	defer os.Remove(testCrashPath)
+	mapping, err := makeTestMapping(ModeReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mapping.Close()
+	slot, err := NewDualSlot(mapping, 0, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	simulator, err := NewCrashSimulator(mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer simulator.Close()
+	random := rand.New(rand.NewSource(1))
+	var previous, next []byte
+	simulator.OnSync(func() {
+		for i := 0; i < 8; i++ {
+			data, err := simulator.RandomCrashImage(random)
+			if err != nil {
+				t.Fatal(err)
+			}
+			image, err := mapCrashImage(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			recovered, err := AttachDualSlot(image, 0, 300)
+			if err != nil {
+				t.Fatal(err)
+			}
+			record, _, err := recovered.Load(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Compare(record, previous) != 0 && bytes.Compare(record, next) != 0 {
+				t.Fatal("either previous or next record must be recovered")
+			}
+			image.Close()
+		}
+	})
+	for i := 0; i < 20; i++ {
+		next = makeTestMessage(i + 200)
+		if err := slot.Store(next); err != nil {
+			t.Fatal(err)
+		}
+		previous = next
+	}
+}
+
+func TestCrashSimulatorFlusher(t *testing.T) {
+	mapping, err := makeTestMapping(ModeReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mapping.Close()
+	flusher, err := NewFlusher(mapping, &FlusherOptions{Threshold: 1, PollInterval: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer flusher.Stop()
+	for i := 0; i < 20; i++ {
+		if err := mapping.MarkDirty(0, 1); err != nil {
+			t.Fatal(err)
+		}
+		simulator, err := NewCrashSimulator(mapping)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := mapping.MarkDirty(0, 1); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+		if err := simulator.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package mmap
+
+import (
+	"math/bits"
+	"os"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+// Dirty offset range [Low, High) of mapping.
+type DirtyRange struct{ Low, High syspack.Offset }
+
+// Function called before offset range [low, high) of mapping is flushed.
+// Returned function is called once range reached file.
+type syncHook func(low, high syspack.Offset) func()
+
+// Make dirty page set of writable mapping.
+func (mapping *Mapping) initDirty() {
+	if mapping.canWrite {
+		pages := (uint64(mapping.alignedSize) + uint64(os.Getpagesize()) - 1) / uint64(os.Getpagesize())
+		mapping.dirty = make([]uint32, (pages+31)/32)
+	}
+}
+
+// Get offset of data in aligned region.
+func (mapping *Mapping) innerOffset() syspack.Offset {
+	if len(mapping.data) == 0 {
+		return 0
+	}
+	return syspack.Offset(uintptr(unsafe.Pointer(&mapping.data[0])) - mapping.alignedAddress)
+}
+
+// Mark pages overlapping offset range [low, high) as dirty.
+// Range must be valid and non-empty.
+func (mapping *Mapping) markDirty(low, high syspack.Offset) {
+	if mapping.dirty == nil {
+		return
+	}
+	pageSize := syspack.Offset(os.Getpagesize())
+	inner := mapping.innerOffset()
+	for page := (inner + low) / pageSize; page <= (inner+high-1)/pageSize; page++ {
+		word, bit := &mapping.dirty[page/32], uint32(1)<<uint(page%32)
+		for {
+			value := atomic.LoadUint32(word)
+			if value&bit != 0 || atomic.CompareAndSwapUint32(word, value, value|bit) {
+				break
+			}
+		}
+	}
+}
+
+// Mark pages overlapping direct byte slice of mapping as dirty.
+func (mapping *Mapping) markDirtyBytes(data []byte) {
+	if len(data) > 0 {
+		mapping.markDirtyPointer(unsafe.Pointer(&data[0]), uintptr(len(data)))
+	}
+}
+
+// Mark pages overlapping size bytes of mapping at given address as dirty.
+func (mapping *Mapping) markDirtyPointer(pointer unsafe.Pointer, size uintptr) {
+	if mapping.dirty == nil {
+		return
+	}
+	low := syspack.Offset(uintptr(pointer) - uintptr(unsafe.Pointer(&mapping.data[0])))
+	mapping.markDirty(low, low+syspack.Offset(size))
+}
+
+// Mark offset range [low, high) as dirty.
+// Writes made through direct byte slices must be marked to be flushed by Sync.
+func (mapping *Mapping) MarkDirty(low, high syspack.Offset) error {
+	if _, err := mapping.Direct(low, high); err != nil {
+		return err
+	}
+	if !mapping.canWrite {
+		return &ErrorNotAllowed{Operation: "write"}
+	}
+	mapping.markDirty(low, high)
+	return nil
+}
+
+// Get dirty offset ranges in ascending order.
+// Adjacent dirty pages are coalesced, ranges are clipped to mapping.
+func (mapping *Mapping) Dirty() []DirtyRange {
+	if mapping.data == nil {
+		return nil
+	}
+	var ranges []DirtyRange
+	mapping.scanDirty(false, func(low, high syspack.Offset) error {
+		ranges = append(ranges, DirtyRange{Low: low, High: high})
+		return nil
+	})
+	return ranges
+}
+
+// Get total size of dirty pages.
+func (mapping *Mapping) dirtySize() syspack.Size {
+	count := 0
+	for i := range mapping.dirty {
+		count += bits.OnesCount32(atomic.LoadUint32(&mapping.dirty[i]))
+	}
+	return syspack.Size(count) * syspack.Size(os.Getpagesize())
+}
+
+// Call given function for every coalesced dirty range, optionally clearing dirty pages of range before call.
+func (mapping *Mapping) scanDirty(clear bool, fn func(low, high syspack.Offset) error) error {
+	pageSize := syspack.Offset(os.Getpagesize())
+	inner := mapping.innerOffset()
+	length := syspack.Off(mapping.data)
+	pages := syspack.Offset(len(mapping.dirty)) * 32
+	for page := syspack.Offset(0); page < pages; {
+		if atomic.LoadUint32(&mapping.dirty[page/32])&(1<<uint(page%32)) == 0 {
+			page++
+			continue
+		}
+		start := page
+		for ; page < pages && atomic.LoadUint32(&mapping.dirty[page/32])&(1<<uint(page%32)) != 0; page++ {
+			if clear {
+				word, bit := &mapping.dirty[page/32], uint32(1)<<uint(page%32)
+				for {
+					value := atomic.LoadUint32(word)
+					if atomic.CompareAndSwapUint32(word, value, value&^bit) {
+						break
+					}
+				}
+			}
+		}
+		low, high := start*pageSize-inner, page*pageSize-inner
+		if low < 0 {
+			low = 0
+		}
+		if high > length {
+			high = length
+		}
+		if err := fn(low, high); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sync dirty ranges using given function flushing offset range [low, high).
+// Ranges failed to flush stay dirty.
+// Returned function notifies sync hook and must be called once flushed ranges reached file.
+func (mapping *Mapping) syncDirty(flush func(low, high syspack.Offset) error) (func(), error) {
+	var done []func()
+	err := mapping.scanDirty(true, func(low, high syspack.Offset) error {
+		synced := mapping.syncing(low, high)
+		if err := flush(low, high); err != nil {
+			mapping.markDirty(low, high)
+			return err
+		}
+		done = append(done, synced)
+		return nil
+	})
+	return func() {
+		for _, synced := range done {
+			synced()
+		}
+	}, err
+}
+
+// Notify sync hook that offset range [low, high) is about to be flushed.
+// Returned function must be called once range reached file.
+func (mapping *Mapping) syncing(low, high syspack.Offset) func() {
+	mapping.hookMutex.Lock()
+	hook := mapping.syncHook
+	mapping.hookMutex.Unlock()
+	if hook == nil {
+		return func() {}
+	}
+	return hook(low, high)
+}
+
+// Set sync hook, or clear it if given hook is nil.
+// Returns false if another hook is already set.
+func (mapping *Mapping) setSyncHook(hook syncHook) bool {
+	mapping.hookMutex.Lock()
+	defer mapping.hookMutex.Unlock()
+	if hook != nil && mapping.syncHook != nil {
+		return false
+	}
+	mapping.syncHook = hook
+	return true
+}
+
+// Sync whole mapping regardless of dirty ranges.
+// Used by structures writing through direct byte slices.
+func (mapping *Mapping) syncAll() error {
+	if mapping.data == nil {
+		return &ErrorClosed{}
+	}
+	if len(mapping.data) == 0 {
+		return nil
+	}
+	for i := range mapping.dirty {
+		atomic.StoreUint32(&mapping.dirty[i], 0)
+	}
+	if err := mapping.SyncRange(0, syspack.Off(mapping.data)); err != nil {
+		mapping.markDirty(0, syspack.Off(mapping.data))
+		return err
+	}
+	return nil
+}
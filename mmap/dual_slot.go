@@ -0,0 +1,207 @@
+package mmap
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"sync"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+// Dual slot format.
+const (
+	dualSlotMagic = 0x544c5344 // "DSLT"
+)
+
+// Dual slot layout.
+// Checksum covers all preceding fields and record.
+const (
+	dualSlotMagicOffset      = 0
+	dualSlotCapacityOffset   = 4
+	dualSlotGenerationOffset = 8
+	dualSlotLengthOffset     = 16
+	dualSlotChecksumOffset   = 20
+	dualSlotHeaderSize       = 24
+)
+
+type DualSlot struct {
+	// Record stored in two checksummed copies, each in its own pages.
+	// Store writes the inactive copy with the next generation and syncs it,
+	// so the newest valid copy survives crash of the system at any moment.
+	// Only one writer is allowed, readers may reside in other processes.
+
+	// Mapping.
+	mapping *Mapping
+
+	// Slots.
+	slots [2][]byte
+
+	// Offsets of slots.
+	offsets [2]syspack.Offset
+
+	// Index of the newest slot.
+	current int
+
+	// Generation of the newest slot.
+	generation uint64
+
+	// Lock.
+	mutex sync.Mutex
+}
+
+// Get size of single slot.
+func dualSlotStride(capacity syspack.Size) syspack.Size {
+	pageSize := syspack.Size(os.Getpagesize())
+	return (dualSlotHeaderSize + capacity + pageSize - 1) &^ (pageSize - 1)
+}
+
+// Get mapping size required for dual slot with records of given capacity.
+func DualSlotSize(capacity syspack.Size) syspack.Size {
+	return 2 * dualSlotStride(capacity)
+}
+
+// Make dual slot of given record capacity in mapping at given page-aligned offset.
+// Initial record is empty.
+func NewDualSlot(mapping *Mapping, offset syspack.Offset, capacity syspack.Size) (*DualSlot, error) {
+	slot, err := openDualSlot(mapping, offset, capacity)
+	if err != nil {
+		return nil, err
+	}
+	for i := range slot.slots {
+		for j := range slot.slots[i] {
+			slot.slots[i][j] = 0
+		}
+	}
+	slot.current = 1
+	if err := slot.Store(nil); err != nil {
+		return nil, err
+	}
+	if err := mapping.SyncRange(slot.offsets[1], slot.offsets[1]+syspack.Offset(len(slot.slots[1]))); err != nil {
+		return nil, err
+	}
+	return slot, nil
+}
+
+// Attach to dual slot of given record capacity existing in mapping at given offset.
+func AttachDualSlot(mapping *Mapping, offset syspack.Offset, capacity syspack.Size) (*DualSlot, error) {
+	slot, err := openDualSlot(mapping, offset, capacity)
+	if err != nil {
+		return nil, err
+	}
+	if _, slot.generation, err = slot.load(nil, &slot.current); err != nil {
+		return nil, err
+	}
+	return slot, nil
+}
+
+// Open dual slot in mapping at given offset.
+func openDualSlot(mapping *Mapping, offset syspack.Offset, capacity syspack.Size) (*DualSlot, error) {
+	if offset%syspack.Offset(os.Getpagesize()) != 0 {
+		return nil, &ErrorUnalignedOffset{Offset: offset}
+	}
+	if capacity == 0 || capacity > 1<<31 {
+		return nil, &ErrorInvalidSize{Size: capacity}
+	}
+	stride := syspack.Offset(dualSlotStride(capacity))
+	data, err := mapping.Direct(offset, offset+2*stride)
+	if err != nil {
+		return nil, err
+	}
+	if !mapping.canWrite {
+		return nil, &ErrorNotAllowed{Operation: "write"}
+	}
+	slot := &DualSlot{mapping: mapping}
+	for i := range slot.slots {
+		slot.slots[i] = data[syspack.Offset(i)*stride:][:dualSlotHeaderSize+capacity]
+		slot.offsets[i] = offset + syspack.Offset(i)*stride
+	}
+	return slot, nil
+}
+
+// Get checksum of slot header and record.
+func dualSlotChecksum(header, record []byte) uint32 {
+	return crc32.Update(crc32.Checksum(header[:dualSlotChecksumOffset], castagnoli), castagnoli, record)
+}
+
+// Copy record of the newest valid slot appending it to buffer.
+// Slot is copied before validation, so it may be concurrently rewritten.
+// Index of chosen slot is stored to given pointer if it is not nil.
+func (slot *DualSlot) load(buffer []byte, index *int) ([]byte, uint64, error) {
+	order := [2]int{0, 1}
+	if binary.LittleEndian.Uint64(slot.slots[1][dualSlotGenerationOffset:]) >
+		binary.LittleEndian.Uint64(slot.slots[0][dualSlotGenerationOffset:]) {
+		order = [2]int{1, 0}
+	}
+	capacity := uint32(len(slot.slots[0]) - dualSlotHeaderSize)
+	var err error
+	for _, i := range order {
+		var header [dualSlotHeaderSize]byte
+		copy(header[:], slot.slots[i])
+		start := len(buffer)
+		buffer = append(buffer, slot.slots[i][dualSlotHeaderSize:]...)
+		if magic := binary.LittleEndian.Uint32(header[dualSlotMagicOffset:]); magic != dualSlotMagic {
+			buffer, err = buffer[:start], &ErrorBadMagic{Magic: magic}
+			continue
+		}
+		length := binary.LittleEndian.Uint32(header[dualSlotLengthOffset:])
+		if binary.LittleEndian.Uint32(header[dualSlotCapacityOffset:]) != capacity || length > capacity {
+			buffer, err = buffer[:start], &ErrorInvalidSize{Size: syspack.Size(length)}
+			continue
+		}
+		buffer = buffer[:start+int(length)]
+		checksum := dualSlotChecksum(header[:], buffer[start:])
+		if expected := binary.LittleEndian.Uint32(header[dualSlotChecksumOffset:]); checksum != expected {
+			buffer, err = buffer[:start], &ErrorChecksum{Checksum: checksum, Expected: expected}
+			continue
+		}
+		if index != nil {
+			*index = i
+		}
+		return buffer, binary.LittleEndian.Uint64(header[dualSlotGenerationOffset:]), nil
+	}
+	return buffer, 0, err
+}
+
+// Get record capacity.
+func (slot *DualSlot) Capacity() syspack.Size {
+	return syspack.Size(len(slot.slots[0]) - dualSlotHeaderSize)
+}
+
+// Get generation of the last stored record.
+func (slot *DualSlot) Generation() uint64 {
+	slot.mutex.Lock()
+	defer slot.mutex.Unlock()
+	return slot.generation
+}
+
+// Load the newest valid record appending it to buffer.
+// Returns record along with its generation.
+func (slot *DualSlot) Load(buffer []byte) ([]byte, uint64, error) {
+	return slot.load(buffer, nil)
+}
+
+// Store record into inactive slot and sync it, making it the newest one.
+func (slot *DualSlot) Store(record []byte) error {
+	slot.mutex.Lock()
+	defer slot.mutex.Unlock()
+	if syspack.Len(record) > slot.Capacity() {
+		return &ErrorInvalidSize{Size: syspack.Len(record)}
+	}
+	target := 1 - slot.current
+	data := slot.slots[target]
+	binary.LittleEndian.PutUint32(data[dualSlotMagicOffset:], 0)
+	binary.LittleEndian.PutUint32(data[dualSlotChecksumOffset:], 0)
+	copy(data[dualSlotHeaderSize:], record)
+	binary.LittleEndian.PutUint32(data[dualSlotCapacityOffset:], uint32(slot.Capacity()))
+	binary.LittleEndian.PutUint32(data[dualSlotLengthOffset:], uint32(len(record)))
+	binary.LittleEndian.PutUint64(data[dualSlotGenerationOffset:], slot.generation+1)
+	binary.LittleEndian.PutUint32(data[dualSlotMagicOffset:], dualSlotMagic)
+	binary.LittleEndian.PutUint32(data[dualSlotChecksumOffset:], dualSlotChecksum(data, record))
+	if err := slot.mapping.SyncRange(slot.offsets[target], slot.offsets[target]+syspack.Offset(len(data))); err != nil {
+		return err
+	}
+	slot.current = target
+	slot.generation++
+	return nil
+}
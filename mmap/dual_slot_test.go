@@ -0,0 +1,70 @@
+package mmap
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+func TestDualSlot(t *testing.T) {
+	mapping, err := makeTestMapping(ModeReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mapping.Close()
+	const capacity = syspack.Size(300)
+	slot, err := NewDualSlot(mapping, 0, capacity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record, generation, err := slot.Load(nil); err != nil || len(record) != 0 || generation != 1 {
+		t.Fatalf("initial record must be empty, %d %d %v found", len(record), generation, err)
+	}
+	const count = 10
+	for i := 0; i < count; i++ {
+		if err := slot.Store(makeTestMessage(i + 290)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := slot.Store(make([]byte, capacity+1)); err == nil {
+		t.Fatal("store of too long record must fail")
+	}
+	attached, err := AttachDualSlot(mapping, 0, capacity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attached.Generation() != count+1 {
+		t.Fatalf("generation must be a %d, %d found", count+1, attached.Generation())
+	}
+	record, _, err := attached.Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Compare(record, makeTestMessage(count-1+290)) != 0 {
+		t.Fatal("the newest record must be loaded")
+	}
+	// Simulate torn write of the newest slot.
+	attached.slots[attached.current][dualSlotHeaderSize]++
+	if attached, err = AttachDualSlot(mapping, 0, capacity); err != nil {
+		t.Fatal(err)
+	}
+	if record, generation, err := attached.Load(nil); err != nil || generation != count ||
+		bytes.Compare(record, makeTestMessage(count-2+290)) != 0 {
+		t.Fatalf("the previous record must be loaded, %d %v found", generation, err)
+	}
+	if err := attached.Store(testBuffer); err != nil {
+		t.Fatal(err)
+	}
+	if record, generation, err := slot.Load(nil); err != nil || generation != count+1 || bytes.Compare(record, testBuffer) != 0 {
+		t.Fatalf("torn slot must be rewritten, %d %v found", generation, err)
+	}
+	attached.slots[0][dualSlotMagicOffset]++
+	attached.slots[1][dualSlotMagicOffset]++
+	if _, err := AttachDualSlot(mapping, 0, capacity); err == nil {
+		t.Fatal("attach without valid slot must fail")
+	}
+	if _, err := NewDualSlot(mapping, 8, capacity); err == nil {
+		t.Fatal("unaligned dual slot must fail")
+	}
+}
@@ -0,0 +1,638 @@
+package mmap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+// Durable queue format.
+const (
+	queueSegmentMagic = 0x47455351 // "QSEG"
+	queueCursorMagic  = 0x52554351 // "QCUR"
+	queueVersion      = 1
+)
+
+// Durable queue segment and cursor layout.
+// Records follow segment header, zero record state marks unwritten space.
+const (
+	queueMagicOffset       = 0
+	queueVersionOffset     = 4
+	queueIndexOffset       = 8
+	queueOffsetOffset      = 16
+	queueHeaderSize        = cacheLineSize
+	queueStateOffset       = 0
+	queueLengthOffset      = 4
+	queueChecksumOffset    = 8
+	queueRecordHeaderSize  = 16
+	queueRecordAlignment   = 8
+	queueRecordWritten     = 1
+	queueSegmentSuffix     = ".seg"
+	queueCursorName        = "cursor"
+	defaultQueueSegment    = 16 << 20
+	defaultQueueBatchSize  = 64
+	defaultQueueSyncPeriod = time.Second
+)
+
+// Durability mode.
+type Durability int
+
+// Available durability modes.
+const (
+	// Sync every operation.
+	DurabilitySync Durability = iota
+
+	// Sync every BatchSize operations.
+	DurabilityBatch
+
+	// Sync every SyncInterval in background.
+	// Background sync error is returned by the next operation.
+	DurabilityPeriodic
+)
+
+type DurableQueueOptions struct {
+	// Durable queue options.
+
+	// Segment file size, 16 MiB by default.
+	SegmentSize syspack.Size
+
+	// Durability mode.
+	Durability Durability
+
+	// Number of operations per sync in batch mode.
+	BatchSize int
+
+	// Sync interval in periodic mode.
+	SyncInterval time.Duration
+}
+
+type queueSegment struct {
+	// Durable queue segment.
+
+	// Mapping.
+	mapping *Mapping
+
+	// Data.
+	data []byte
+
+	// Segment is modified since last sync.
+	dirty bool
+}
+
+type queuePosition struct {
+	// Durable queue position.
+
+	// Segment index.
+	segment uint64
+
+	// Offset in segment.
+	offset uint64
+}
+
+type DurableQueue struct {
+	// FIFO queue stored in directory of fixed-size segment files.
+	// Dequeued messages are redelivered after restart unless acknowledged.
+	// Segments are deleted once all their messages are acknowledged.
+
+	// Directory.
+	dir string
+
+	// Options.
+	options DurableQueueOptions
+
+	// Open segments by index.
+	segments map[uint64]*queueSegment
+
+	// Cursor mapping.
+	cursor *Mapping
+
+	// Cursor data.
+	cursorData []byte
+
+	// Write position.
+	write queuePosition
+
+	// Read position.
+	read queuePosition
+
+	// Acknowledged position.
+	ack queuePosition
+
+	// Number of operations since last sync.
+	operations int
+
+	// Directory is modified since last sync.
+	dirDirty bool
+
+	// Background sync error not returned yet.
+	syncErr error
+
+	// Lock.
+	mutex sync.Mutex
+
+	// Closed on close to stop background sync.
+	done chan struct{}
+
+	// Background sync is finished.
+	stopped sync.WaitGroup
+}
+
+// Open durable queue in given directory creating it if necessary.
+func OpenDurableQueue(dir string, options *DurableQueueOptions) (*DurableQueue, error) {
+	queue := &DurableQueue{dir: dir, segments: make(map[uint64]*queueSegment)}
+	if options != nil {
+		queue.options = *options
+	}
+	if queue.options.SegmentSize == 0 {
+		queue.options.SegmentSize = defaultQueueSegment
+	}
+	if queue.options.BatchSize <= 0 {
+		queue.options.BatchSize = defaultQueueBatchSize
+	}
+	if queue.options.SyncInterval <= 0 {
+		queue.options.SyncInterval = defaultQueueSyncPeriod
+	}
+	if queue.options.SegmentSize < 2*queueHeaderSize || queue.options.SegmentSize > syspack.Size(syspack.MaxInt) {
+		return nil, &ErrorInvalidSize{Size: queue.options.SegmentSize}
+	}
+	if queue.options.Durability < DurabilitySync || queue.options.Durability > DurabilityPeriodic {
+		return nil, &ErrorNotAllowed{Operation: fmt.Sprintf("durability mode %d", queue.options.Durability)}
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	if err := queue.open(); err != nil {
+		queue.closeAll()
+		return nil, err
+	}
+	if queue.options.Durability == DurabilityPeriodic {
+		queue.done = make(chan struct{})
+		queue.stopped.Add(1)
+		go queue.syncPeriodically(queue.done)
+	}
+	return queue, nil
+}
+
+// Open segments and cursor.
+func (queue *DurableQueue) open() error {
+	indexes, err := queue.list()
+	if err != nil {
+		return err
+	}
+	if err := queue.openCursor(indexes); err != nil {
+		return err
+	}
+	if len(indexes) == 0 || indexes[len(indexes)-1] < queue.ack.segment {
+		if _, err := queue.createSegment(queue.ack.segment); err != nil {
+			return err
+		}
+		indexes = append(indexes, queue.ack.segment)
+	}
+	queue.read = queue.ack
+	last := indexes[len(indexes)-1]
+	segment, err := queue.segment(last)
+	if err != nil {
+		return err
+	}
+	queue.write = queuePosition{segment: last, offset: queueHeaderSize}
+	for {
+		_, next, err := queue.record(segment, queue.write.offset)
+		if next == 0 || err != nil {
+			break
+		}
+		queue.write.offset = next
+	}
+	// Discard torn tail.
+	tail := segment.data[queue.write.offset:]
+	for i := range tail {
+		tail[i] = 0
+	}
+	return nil
+}
+
+// List indexes of existing segments in ascending order.
+func (queue *DurableQueue) list() ([]uint64, error) {
+	paths, err := filepath.Glob(filepath.Join(queue.dir, "*"+queueSegmentSuffix))
+	if err != nil {
+		return nil, err
+	}
+	var indexes []uint64
+	for _, path := range paths {
+		var index uint64
+		if _, err := fmt.Sscanf(filepath.Base(path), "%016x"+queueSegmentSuffix, &index); err == nil {
+			indexes = append(indexes, index)
+		}
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+	return indexes, nil
+}
+
+// Get path of segment with given index.
+func (queue *DurableQueue) segmentPath(index uint64) string {
+	return filepath.Join(queue.dir, fmt.Sprintf("%016x"+queueSegmentSuffix, index))
+}
+
+// Open or create cursor file.
+func (queue *DurableQueue) openCursor(indexes []uint64) error {
+	file, err := os.OpenFile(filepath.Join(queue.dir, queueCursorName), os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	created := info.Size() == 0
+	if created {
+		if err := file.Truncate(queueHeaderSize); err != nil {
+			return err
+		}
+		queue.dirDirty = true
+	} else if info.Size() != queueHeaderSize {
+		return &ErrorInvalidSize{Size: syspack.Size(info.Size())}
+	}
+	if queue.cursor, err = NewMapping(file.Fd(), 0, queueHeaderSize, &Options{Mode: ModeReadWrite}); err != nil {
+		return err
+	}
+	queue.cursorData, _ = queue.cursor.Direct(0, queueHeaderSize)
+	if created {
+		queue.ack = queuePosition{offset: queueHeaderSize}
+		if len(indexes) > 0 {
+			queue.ack.segment = indexes[0]
+		}
+		binary.LittleEndian.PutUint32(queue.cursorData[queueVersionOffset:], queueVersion)
+		queue.storeCursor()
+		binary.LittleEndian.PutUint32(queue.cursorData[queueMagicOffset:], queueCursorMagic)
+		return nil
+	}
+	if magic := binary.LittleEndian.Uint32(queue.cursorData[queueMagicOffset:]); magic != queueCursorMagic {
+		return &ErrorBadMagic{Magic: magic}
+	}
+	if version := binary.LittleEndian.Uint32(queue.cursorData[queueVersionOffset:]); version != queueVersion {
+		return &ErrorVersionMismatch{Version: version, Expected: queueVersion}
+	}
+	queue.ack.segment = binary.LittleEndian.Uint64(queue.cursorData[queueIndexOffset:])
+	queue.ack.offset = binary.LittleEndian.Uint64(queue.cursorData[queueOffsetOffset:])
+	if queue.ack.offset < queueHeaderSize || queue.ack.offset > uint64(queue.options.SegmentSize) {
+		return &ErrorInvalidOffset{Offset: syspack.Offset(queue.ack.offset)}
+	}
+	if len(indexes) > 0 && indexes[0] > queue.ack.segment {
+		queue.ack = queuePosition{segment: indexes[0], offset: queueHeaderSize}
+		queue.storeCursor()
+	}
+	return nil
+}
+
+// Store acknowledged position in cursor.
+func (queue *DurableQueue) storeCursor() {
+	binary.LittleEndian.PutUint64(queue.cursorData[queueIndexOffset:], queue.ack.segment)
+	binary.LittleEndian.PutUint64(queue.cursorData[queueOffsetOffset:], queue.ack.offset)
+}
+
+// Create segment with given index.
+func (queue *DurableQueue) createSegment(index uint64) (*queueSegment, error) {
+	file, err := os.OpenFile(queue.segmentPath(index), os.O_CREATE|os.O_EXCL|os.O_RDWR, 0666)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if err := file.Truncate(int64(queue.options.SegmentSize)); err != nil {
+		return nil, err
+	}
+	mapping, err := NewMapping(file.Fd(), 0, queue.options.SegmentSize, &Options{Mode: ModeReadWrite})
+	if err != nil {
+		return nil, err
+	}
+	segment := &queueSegment{mapping: mapping, dirty: true}
+	segment.data, _ = mapping.Direct(0, syspack.Offset(mapping.Len()))
+	binary.LittleEndian.PutUint32(segment.data[queueVersionOffset:], queueVersion)
+	binary.LittleEndian.PutUint64(segment.data[queueIndexOffset:], index)
+	binary.LittleEndian.PutUint32(segment.data[queueMagicOffset:], queueSegmentMagic)
+	queue.segments[index] = segment
+	queue.dirDirty = true
+	return segment, nil
+}
+
+// Get segment with given index opening it if necessary.
+// Returns nil segment if there is no such one.
+func (queue *DurableQueue) segment(index uint64) (*queueSegment, error) {
+	if segment, ok := queue.segments[index]; ok {
+		return segment, nil
+	}
+	file, err := os.OpenFile(queue.segmentPath(index), os.O_RDWR, 0)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if syspack.Size(info.Size()) != queue.options.SegmentSize {
+		return nil, &ErrorInvalidSize{Size: syspack.Size(info.Size())}
+	}
+	mapping, err := NewMapping(file.Fd(), 0, queue.options.SegmentSize, &Options{Mode: ModeReadWrite})
+	if err != nil {
+		return nil, err
+	}
+	segment := &queueSegment{mapping: mapping}
+	segment.data, _ = mapping.Direct(0, syspack.Offset(mapping.Len()))
+	if magic := binary.LittleEndian.Uint32(segment.data[queueMagicOffset:]); magic != queueSegmentMagic {
+		mapping.Close()
+		return nil, &ErrorBadMagic{Magic: magic}
+	}
+	if version := binary.LittleEndian.Uint32(segment.data[queueVersionOffset:]); version != queueVersion {
+		mapping.Close()
+		return nil, &ErrorVersionMismatch{Version: version, Expected: queueVersion}
+	}
+	queue.segments[index] = segment
+	return segment, nil
+}
+
+// Get record at given offset of segment and offset of the next one.
+// Returns zero next offset if there is no record.
+func (queue *DurableQueue) record(segment *queueSegment, offset uint64) ([]byte, uint64, error) {
+	size := uint64(len(segment.data))
+	if offset+queueRecordHeaderSize > size {
+		return nil, 0, nil
+	}
+	header := segment.data[offset : offset+queueRecordHeaderSize]
+	if binary.LittleEndian.Uint32(header[queueStateOffset:]) != queueRecordWritten {
+		return nil, 0, nil
+	}
+	length := uint64(binary.LittleEndian.Uint32(header[queueLengthOffset:]))
+	start := offset + queueRecordHeaderSize
+	if length > size-start {
+		return nil, 0, &ErrorInvalidOffset{Offset: syspack.Offset(offset)}
+	}
+	data := segment.data[start : start+length : start+length]
+	checksum := crc32.Update(crc32.Checksum(header[queueLengthOffset:queueChecksumOffset], castagnoli), castagnoli, data)
+	if expected := binary.LittleEndian.Uint32(header[queueChecksumOffset:]); checksum != expected {
+		return nil, 0, &ErrorChecksum{Checksum: checksum, Expected: expected}
+	}
+	return data, (start + length + queueRecordAlignment - 1) &^ (queueRecordAlignment - 1), nil
+}
+
+// Get message at given position and position of the next one.
+// Returns false if there is no message.
+func (queue *DurableQueue) next(position queuePosition) ([]byte, queuePosition, bool, error) {
+	for {
+		segment, err := queue.segment(position.segment)
+		if err != nil {
+			return nil, position, false, err
+		}
+		if segment != nil {
+			data, next, err := queue.record(segment, position.offset)
+			if err != nil {
+				return nil, position, false, err
+			}
+			if next != 0 {
+				return data, queuePosition{segment: position.segment, offset: next}, true, nil
+			}
+		}
+		if position.segment >= queue.write.segment {
+			return nil, position, false, nil
+		}
+		position = queuePosition{segment: position.segment + 1, offset: queueHeaderSize}
+	}
+}
+
+// Count operation syncing if durability mode requires it.
+func (queue *DurableQueue) operation() error {
+	queue.operations++
+	switch queue.options.Durability {
+	case DurabilitySync:
+		return queue.sync()
+	case DurabilityBatch:
+		if queue.operations >= queue.options.BatchSize {
+			return queue.sync()
+		}
+	case DurabilityPeriodic:
+		return queue.takeSyncErr()
+	}
+	return nil
+}
+
+// Get and reset background sync error.
+func (queue *DurableQueue) takeSyncErr() error {
+	err := queue.syncErr
+	queue.syncErr = nil
+	return err
+}
+
+// Append message.
+func (queue *DurableQueue) Enqueue(message []byte) error {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	if queue.cursorData == nil {
+		return &ErrorClosed{}
+	}
+	size := (queueRecordHeaderSize + uint64(len(message)) + queueRecordAlignment - 1) &^ (queueRecordAlignment - 1)
+	if size > uint64(queue.options.SegmentSize)-queueHeaderSize || uint64(len(message)) > uint64(syspack.MaxDword) {
+		return &ErrorInvalidSize{Size: syspack.Len(message)}
+	}
+	segment, err := queue.segment(queue.write.segment)
+	if err != nil {
+		return err
+	}
+	if queue.write.offset+size > uint64(queue.options.SegmentSize) {
+		if segment, err = queue.createSegment(queue.write.segment + 1); err != nil {
+			return err
+		}
+		queue.write = queuePosition{segment: queue.write.segment + 1, offset: queueHeaderSize}
+	}
+	header := segment.data[queue.write.offset : queue.write.offset+queueRecordHeaderSize]
+	start := queue.write.offset + queueRecordHeaderSize
+	copy(segment.data[start:], message)
+	binary.LittleEndian.PutUint32(header[queueLengthOffset:], uint32(len(message)))
+	binary.LittleEndian.PutUint32(
+		header[queueChecksumOffset:],
+		crc32.Update(crc32.Checksum(header[queueLengthOffset:queueChecksumOffset], castagnoli), castagnoli, message),
+	)
+	binary.LittleEndian.PutUint32(header[queueStateOffset:], queueRecordWritten)
+	queue.write.offset += size
+	segment.dirty = true
+	return queue.operation()
+}
+
+// Get the next message without dequeuing it, appending it to buffer.
+// Returns false if queue is empty.
+func (queue *DurableQueue) Peek(buffer []byte) ([]byte, bool, error) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	if queue.cursorData == nil {
+		return buffer, false, &ErrorClosed{}
+	}
+	data, _, ok, err := queue.next(queue.read)
+	return append(buffer, data...), ok, err
+}
+
+// Dequeue the next message appending it to buffer.
+// Returns false if queue is empty.
+// Message is delivered again after reopening unless acknowledged.
+func (queue *DurableQueue) Dequeue(buffer []byte) ([]byte, bool, error) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	if queue.cursorData == nil {
+		return buffer, false, &ErrorClosed{}
+	}
+	data, next, ok, err := queue.next(queue.read)
+	if ok {
+		queue.read = next
+	}
+	return append(buffer, data...), ok, err
+}
+
+// Acknowledge all dequeued messages.
+// Fully acknowledged segments are deleted.
+func (queue *DurableQueue) Ack() error {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	if queue.cursorData == nil {
+		return &ErrorClosed{}
+	}
+	if queue.ack == queue.read {
+		return nil
+	}
+	queue.ack = queue.read
+	queue.storeCursor()
+	indexes, err := queue.list()
+	if err != nil {
+		return err
+	}
+	for len(indexes) > 0 && indexes[len(indexes)-1] >= queue.ack.segment {
+		indexes = indexes[:len(indexes)-1]
+	}
+	if len(indexes) > 0 {
+		// Cursor must be durable before segments are deleted.
+		if err := queue.cursor.syncAll(); err != nil {
+			return err
+		}
+	}
+	for _, index := range indexes {
+		if segment, ok := queue.segments[index]; ok {
+			if err := segment.mapping.Close(); err != nil {
+				return err
+			}
+			delete(queue.segments, index)
+		}
+		if err := os.Remove(queue.segmentPath(index)); err != nil {
+			return err
+		}
+		queue.dirDirty = true
+	}
+	return queue.operation()
+}
+
+// Sync segments and cursor.
+func (queue *DurableQueue) Sync() error {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	if queue.cursorData == nil {
+		return &ErrorClosed{}
+	}
+	if err := queue.takeSyncErr(); err != nil {
+		return err
+	}
+	return queue.sync()
+}
+
+// Sync modified segments, cursor and directory.
+func (queue *DurableQueue) sync() error {
+	for _, segment := range queue.segments {
+		if !segment.dirty {
+			continue
+		}
+		if err := segment.mapping.syncAll(); err != nil {
+			return err
+		}
+		segment.dirty = false
+	}
+	if err := queue.cursor.syncAll(); err != nil {
+		return err
+	}
+	if queue.dirDirty {
+		if err := syncDir(queue.dir); err != nil {
+			return err
+		}
+		queue.dirDirty = false
+	}
+	queue.operations = 0
+	return nil
+}
+
+// Sync queue periodically until done channel is closed.
+func (queue *DurableQueue) syncPeriodically(done <-chan struct{}) {
+	defer queue.stopped.Done()
+	ticker := time.NewTicker(queue.options.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			queue.mutex.Lock()
+			if queue.operations > 0 {
+				if err := queue.sync(); err != nil && queue.syncErr == nil {
+					queue.syncErr = err
+				}
+			}
+			queue.mutex.Unlock()
+		}
+	}
+}
+
+// Close all mappings.
+func (queue *DurableQueue) closeAll() error {
+	var lastErr error
+	for index, segment := range queue.segments {
+		if err := segment.mapping.Close(); err != nil {
+			lastErr = err
+		}
+		delete(queue.segments, index)
+	}
+	if queue.cursor != nil {
+		if err := queue.cursor.Close(); err != nil {
+			lastErr = err
+		}
+		queue.cursor, queue.cursorData = nil, nil
+	}
+	return lastErr
+}
+
+// Sync and close queue.
+// Queue is closed even if sync fails, the first error is returned.
+func (queue *DurableQueue) Close() error {
+	queue.mutex.Lock()
+	if queue.cursorData == nil {
+		queue.mutex.Unlock()
+		return &ErrorClosed{}
+	}
+	if done := queue.done; done != nil {
+		queue.done = nil
+		close(done)
+		queue.mutex.Unlock()
+		queue.stopped.Wait()
+		queue.mutex.Lock()
+	}
+	defer queue.mutex.Unlock()
+	err := queue.takeSyncErr()
+	if syncErr := queue.sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := queue.closeAll(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,111 @@
+package mmap
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testDurableQueueDir = filepath.Join(os.TempDir(), "test.queue")
+
+func TestDurableQueue(t *testing.T) {
+	os.RemoveAll(testDurableQueueDir)
+	defer os.RemoveAll(testDurableQueueDir)
+	options := &DurableQueueOptions{SegmentSize: 4096, Durability: DurabilityBatch, BatchSize: 10}
+	queue, err := OpenDurableQueue(testDurableQueueDir, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer queue.Close()
+	const count = 200
+	for i := 0; i < count; i++ {
+		if err := queue.Enqueue(makeTestMessage(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	peeked, ok, err := queue.Peek(nil)
+	if err != nil || !ok || len(peeked) != 0 {
+		t.Fatalf("empty message must be peeked, %v %v %v found", peeked, ok, err)
+	}
+	var message []byte
+	for i := 0; i < count/2; i++ {
+		message, ok, err = queue.Dequeue(message[:0])
+		if err != nil || !ok || bytes.Compare(message, makeTestMessage(i)) != 0 {
+			t.Fatalf("message %d must be dequeued, %v %v found", i, ok, err)
+		}
+	}
+	if err := queue.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	for i := count / 2; i < count/2+10; i++ {
+		if _, ok, err := queue.Dequeue(nil); err != nil || !ok {
+			t.Fatalf("message %d must be dequeued, %v %v found", i, ok, err)
+		}
+	}
+	if err := queue.Close(); err != nil {
+		t.Fatal(err)
+	}
+	indexes, _ := (&DurableQueue{dir: testDurableQueueDir}).list()
+	if len(indexes) == 0 || indexes[0] == 0 {
+		t.Fatalf("consumed segments must be deleted, %v found", indexes)
+	}
+	if queue, err = OpenDurableQueue(testDurableQueueDir, options); err != nil {
+		t.Fatal(err)
+	}
+	for i := count / 2; i < count; i++ {
+		message, ok, err = queue.Dequeue(message[:0])
+		if err != nil || !ok || bytes.Compare(message, makeTestMessage(i)) != 0 {
+			t.Fatalf("message %d must be redelivered, %v %v found", i, ok, err)
+		}
+	}
+	if _, ok, err := queue.Dequeue(nil); err != nil || ok {
+		t.Fatalf("queue must be empty, %v %v found", ok, err)
+	}
+	if err := queue.Enqueue(testBuffer); err != nil {
+		t.Fatal(err)
+	}
+	if message, ok, err = queue.Dequeue(message[:0]); err != nil || !ok || bytes.Compare(message, testBuffer) != 0 {
+		t.Fatalf("appended message must be dequeued, %v %v found", ok, err)
+	}
+}
+
+func TestDurableQueueTornTail(t *testing.T) {
+	os.RemoveAll(testDurableQueueDir)
+	defer os.RemoveAll(testDurableQueueDir)
+	options := &DurableQueueOptions{SegmentSize: 4096, Durability: DurabilityPeriodic, SyncInterval: time.Millisecond}
+	queue, err := OpenDurableQueue(testDurableQueueDir, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 2; i++ {
+		if err := queue.Enqueue(makeTestMessage(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := queue.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.OpenFile(queue.segmentPath(0), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteAt([]byte{0xff}, queueHeaderSize+24+queueRecordHeaderSize); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	if queue, err = OpenDurableQueue(testDurableQueueDir, options); err != nil {
+		t.Fatal(err)
+	}
+	defer queue.Close()
+	if err := queue.Enqueue(testBuffer); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range [][]byte{makeTestMessage(1), testBuffer} {
+		message, ok, err := queue.Dequeue(nil)
+		if err != nil || !ok || bytes.Compare(message, expected) != 0 {
+			t.Fatalf("message %v must be dequeued, %v %v %v found", expected, message, ok, err)
+		}
+	}
+}
@@ -53,3 +53,87 @@ type ErrorNotAllowed struct{ Operation string }
 func (err *ErrorNotAllowed) Error() string {
 	return fmt.Sprintf("mmap: %s is not allowed", err.Operation)
 }
+
+// Error occurred when offset is not properly aligned.
+type ErrorUnalignedOffset struct{ Offset syspack.Offset }
+
+// Get error message.
+func (err *ErrorUnalignedOffset) Error() string {
+	return fmt.Sprintf("mmap: unaligned offset 0x%x", err.Offset)
+}
+
+// Error occurred when operation timed out.
+type ErrorTimeout struct{}
+
+// Get error message.
+func (err *ErrorTimeout) Error() string {
+	return "mmap: operation timed out"
+}
+
+// Error occurred when magic number is invalid.
+type ErrorBadMagic struct{ Magic uint32 }
+
+// Get error message.
+func (err *ErrorBadMagic) Error() string {
+	return fmt.Sprintf("mmap: bad magic 0x%08x", err.Magic)
+}
+
+// Error occurred when format version is not supported.
+type ErrorVersionMismatch struct{ Version, Expected uint32 }
+
+// Get error message.
+func (err *ErrorVersionMismatch) Error() string {
+	return fmt.Sprintf("mmap: version %d mismatch, %d expected", err.Version, err.Expected)
+}
+
+// Error occurred when reader was overrun by writer.
+type ErrorOverrun struct{ Lost uint64 }
+
+// Get error message.
+func (err *ErrorOverrun) Error() string {
+	return fmt.Sprintf("mmap: reader overrun, %d messages lost", err.Lost)
+}
+
+// Error occurred when there is no space left.
+type ErrorNoSpace struct{ Size syspack.Size }
+
+// Get error message.
+func (err *ErrorNoSpace) Error() string {
+	return fmt.Sprintf("mmap: no space for %d bytes", err.Size)
+}
+
+// Error occurred when guard page of guarded buffer was accessed.
+type ErrorGuardFault struct {
+	Label   string
+	Address uintptr
+	Offset  syspack.Offset
+}
+
+// Get error message.
+func (err *ErrorGuardFault) Error() string {
+	return fmt.Sprintf("mmap: guard page fault at offset %d of buffer %q", err.Offset, err.Label)
+}
+
+// Error occurred when checksum does not match.
+type ErrorChecksum struct{ Checksum, Expected uint32 }
+
+// Get error message.
+func (err *ErrorChecksum) Error() string {
+	return fmt.Sprintf("mmap: checksum 0x%08x mismatch, 0x%08x expected", err.Checksum, err.Expected)
+}
+
+// Error occurred when file is shorter than expected.
+type ErrorTruncated struct{ Size, Expected syspack.Size }
+
+// Get error message.
+func (err *ErrorTruncated) Error() string {
+	return fmt.Sprintf("mmap: truncated to %d bytes, %d expected", err.Size, err.Expected)
+}
+
+// Error occurred when argument is invalid.
+type ErrorInvalidArgument struct{ Name string }
+
+// Get error message.
+func (err *ErrorInvalidArgument) Error() string {
+	return fmt.Sprintf("mmap: invalid argument %s", err.Name)
+}
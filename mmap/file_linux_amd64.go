@@ -0,0 +1,69 @@
+package mmap
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+// Sync directory, making renames and links in it durable.
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// Create unnamed temporary file in directory.
+// Hidden named file is created if file system does not support unnamed ones,
+// its path is returned along with file.
+func createTempFile(dir string) (*os.File, string, error) {
+	fd, err := syscall.Open(dir, syspack.OTmpfile|syscall.O_RDWR|syscall.O_CLOEXEC, 0666)
+	if err == nil {
+		return os.NewFile(uintptr(fd), dir), "", nil
+	}
+	if err != syscall.EISDIR && err != syscall.EOPNOTSUPP {
+		return nil, "", &os.PathError{Op: "open", Path: dir, Err: err}
+	}
+	file, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return nil, "", err
+	}
+	return file, file.Name(), nil
+}
+
+// Give temporary file given path replacing existing file and close it.
+func publishTempFile(file *os.File, tempPath, path string) error {
+	defer file.Close()
+	if tempPath != "" {
+		return os.Rename(tempPath, path)
+	}
+	procPath := "/proc/self/fd/" + strconv.Itoa(int(file.Fd()))
+	err := syspack.LinkatE(syspack.AtFdcwd, procPath, syspack.AtFdcwd, path, syspack.AtSymlinkFollow)
+	if err == nil || !os.IsExist(err) {
+		return err
+	}
+	// Link can not replace existing file, so file is linked under hidden name and renamed.
+	for {
+		tempPath = filepath.Join(filepath.Dir(path), ".tmp-"+strconv.FormatInt(time.Now().UnixNano(), 36))
+		err := syspack.LinkatE(syspack.AtFdcwd, procPath, syspack.AtFdcwd, tempPath, syspack.AtSymlinkFollow)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return nil
+}
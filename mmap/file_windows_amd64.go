@@ -0,0 +1,31 @@
+package mmap
+
+import (
+	"io/ioutil"
+	"os"
+)
+
+// Sync directory, making renames and links in it durable.
+// Directories can not be synced on Windows, metadata is journaled by file system.
+func syncDir(path string) error {
+	return nil
+}
+
+// Create hidden temporary file in directory.
+// Windows does not support unnamed files, so path of file is always returned along with it.
+func createTempFile(dir string) (*os.File, string, error) {
+	file, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return nil, "", err
+	}
+	return file, file.Name(), nil
+}
+
+// Give temporary file given path replacing existing file and close it.
+// File is closed before renaming since open file can not be renamed on Windows.
+func publishTempFile(file *os.File, tempPath, path string) error {
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, path)
+}
@@ -0,0 +1,126 @@
+package mmap
+
+import (
+	"sync"
+	"time"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+// Default flusher options.
+const (
+	defaultFlushInterval     = time.Second
+	defaultFlushPollInterval = 10 * time.Millisecond
+)
+
+type FlusherOptions struct {
+	// Flusher options.
+
+	// Sync mapping at this interval.
+	// Defaults to one second if neither interval nor threshold is set.
+	Interval time.Duration
+
+	// Sync mapping when size of dirty pages exceeds this threshold.
+	Threshold syspack.Size
+
+	// Interval of dirty size checks, 10 milliseconds by default.
+	PollInterval time.Duration
+
+	// Function called with error of background sync.
+	// If not set, the first background sync error is returned by Stop.
+	OnError func(err error)
+}
+
+type Flusher struct {
+	// Background goroutine writing dirty ranges of mapping back to file.
+	// Flusher must be stopped before mapping is closed.
+
+	// Mapping.
+	mapping *Mapping
+
+	// Options.
+	options FlusherOptions
+
+	// Stop signal.
+	stop chan struct{}
+
+	// Goroutine completion.
+	done sync.WaitGroup
+
+	// Stopping is in progress or done.
+	stopped sync.Once
+
+	// The first background sync error not passed to error function.
+	err error
+}
+
+// Start flusher of given mapping.
+func NewFlusher(mapping *Mapping, options *FlusherOptions) (*Flusher, error) {
+	if !mapping.CanWrite() {
+		return nil, &ErrorNotAllowed{Operation: "sync"}
+	}
+	flusher := &Flusher{mapping: mapping, stop: make(chan struct{})}
+	if options != nil {
+		flusher.options = *options
+	}
+	if flusher.options.Interval < 0 {
+		return nil, &ErrorInvalidArgument{Name: "interval"}
+	}
+	if flusher.options.Interval == 0 && flusher.options.Threshold == 0 {
+		flusher.options.Interval = defaultFlushInterval
+	}
+	if flusher.options.PollInterval <= 0 {
+		flusher.options.PollInterval = defaultFlushPollInterval
+	}
+	flusher.done.Add(1)
+	go flusher.run()
+	return flusher, nil
+}
+
+// Run flusher loop.
+func (flusher *Flusher) run() {
+	defer flusher.done.Done()
+	period := flusher.options.Interval
+	if flusher.options.Threshold != 0 && (period == 0 || flusher.options.PollInterval < period) {
+		period = flusher.options.PollInterval
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-flusher.stop:
+			return
+		case now := <-ticker.C:
+			expired := flusher.options.Interval != 0 && now.Sub(last) >= flusher.options.Interval
+			exceeded := flusher.options.Threshold != 0 && flusher.mapping.dirtySize() > flusher.options.Threshold
+			if !expired && !exceeded {
+				continue
+			}
+			last = now
+			if err := flusher.mapping.Sync(); err != nil {
+				if flusher.options.OnError != nil {
+					flusher.options.OnError(err)
+				} else if flusher.err == nil {
+					flusher.err = err
+				}
+			}
+		}
+	}
+}
+
+// Stop flusher and sync mapping for the last time.
+// Returns the first error of kept background sync error and final sync error,
+// ErrorClosed is returned if flusher is already stopped.
+func (flusher *Flusher) Stop() error {
+	err := error(&ErrorClosed{})
+	flusher.stopped.Do(func() {
+		close(flusher.stop)
+		flusher.done.Wait()
+		err = flusher.err
+		if syncErr := flusher.mapping.Sync(); err == nil {
+			err = syncErr
+		}
+	})
+	return err
+}
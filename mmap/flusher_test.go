@@ -0,0 +1,70 @@
+package mmap
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+func waitClean(mapping *Mapping, timeout time.Duration) bool {
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if mapping.dirtySize() == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func TestFlusher(t *testing.T) {
+	mapping, err := makeTestMapping(ModeReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mapping.Close()
+	if _, err := NewFlusher(mapping, &FlusherOptions{Interval: -time.Second}); err == nil {
+		t.Fatal("negative interval must be rejected")
+	} else if _, ok := err.(*ErrorInvalidArgument); !ok {
+		t.Fatalf("invalid argument error must be returned, %v found", err)
+	}
+	pageSize := syspack.Size(os.Getpagesize())
+	flusher, err := NewFlusher(mapping, &FlusherOptions{Threshold: 2 * pageSize, PollInterval: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mapping.WriteAt(testBuffer, 0); err != nil {
+		t.Fatal(err)
+	}
+	if waitClean(mapping, 50*time.Millisecond) {
+		t.Fatal("dirty size below threshold must not be flushed")
+	}
+	if _, err := mapping.WriteAt(make([]byte, 2*pageSize), syspack.Offset(pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	if !waitClean(mapping, 5*time.Second) {
+		t.Fatal("dirty size above threshold must be flushed")
+	}
+	if _, err := mapping.WriteAt(testBuffer, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := flusher.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if mapping.dirtySize() != 0 {
+		t.Fatal("stop must flush mapping")
+	}
+	if err := flusher.Stop(); err == nil {
+		t.Fatal("second stop must fail")
+	}
+	if flusher, err = NewFlusher(mapping, &FlusherOptions{Interval: 5 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	defer flusher.Stop()
+	if _, err := mapping.WriteAt(testBuffer, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !waitClean(mapping, 5*time.Second) {
+		t.Fatal("mapping must be flushed at interval")
+	}
+}
@@ -0,0 +1,192 @@
+package mmap
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"time"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+// Formatted file header layout.
+// Header checksum covers all preceding header fields, payload checksum is updated by Seal.
+const (
+	formattedMagicOffset           = 0
+	formattedVersionOffset         = 4
+	formattedHeaderSizeOffset      = 8
+	formattedCreatedOffset         = 16
+	formattedPayloadSizeOffset     = 24
+	formattedPayloadChecksumOffset = 32
+	formattedHeaderChecksumOffset  = 36
+	formattedHeaderSize            = cacheLineSize
+)
+
+type FileFormat struct {
+	// Format of file payload.
+
+	// Magic number identifying format.
+	Magic uint32
+
+	// The latest supported version of format, files of older versions can be opened too.
+	Version uint32
+}
+
+type FormattedFile struct {
+	// Mapped file with self-describing header followed by payload.
+
+	// Mapping.
+	mapping *Mapping
+
+	// Header.
+	header []byte
+
+	// Payload.
+	payload *Section
+}
+
+// Get header checksum.
+func formattedHeaderChecksum(header []byte) uint32 {
+	return crc32.Checksum(header[:formattedHeaderChecksumOffset], castagnoli)
+}
+
+// Create formatted file with payload of given size, replacing existing one.
+// Payload is zeroed and sealed.
+func CreateFormattedFile(path string, format *FileFormat, size syspack.Size) (*FormattedFile, error) {
+	if format == nil || format.Version == 0 {
+		return nil, &ErrorInvalidArgument{Name: "format"}
+	}
+	if size == 0 || size > syspack.Size(syspack.MaxInt)-formattedHeaderSize {
+		return nil, &ErrorInvalidSize{Size: size}
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0666)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if err := file.Truncate(int64(formattedHeaderSize + size)); err != nil {
+		return nil, err
+	}
+	mapping, err := NewMapping(file.Fd(), 0, formattedHeaderSize+size, &Options{Mode: ModeReadWrite})
+	if err != nil {
+		return nil, err
+	}
+	formatted := &FormattedFile{mapping: mapping}
+	formatted.header, _ = mapping.Direct(0, formattedHeaderSize)
+	formatted.payload, _ = NewSection(mapping, formattedHeaderSize, size)
+	binary.LittleEndian.PutUint32(formatted.header[formattedVersionOffset:], format.Version)
+	binary.LittleEndian.PutUint32(formatted.header[formattedHeaderSizeOffset:], formattedHeaderSize)
+	binary.LittleEndian.PutUint64(formatted.header[formattedCreatedOffset:], uint64(time.Now().UnixNano()))
+	binary.LittleEndian.PutUint64(formatted.header[formattedPayloadSizeOffset:], uint64(size))
+	binary.LittleEndian.PutUint32(formatted.header[formattedMagicOffset:], format.Magic)
+	if err := formatted.Seal(); err != nil {
+		mapping.Close()
+		return nil, err
+	}
+	return formatted, nil
+}
+
+// Open formatted file in given mode validating its header and payload.
+func OpenFormattedFile(path string, format *FileFormat, mode Mode) (*FormattedFile, error) {
+	if format == nil {
+		return nil, &ErrorInvalidArgument{Name: "format"}
+	}
+	flag := os.O_RDWR
+	if mode == ModeReadOnly {
+		flag = os.O_RDONLY
+	}
+	file, err := os.OpenFile(path, flag, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < formattedHeaderSize {
+		return nil, &ErrorTruncated{Size: syspack.Size(info.Size()), Expected: formattedHeaderSize}
+	}
+	if info.Size() > int64(syspack.MaxInt) {
+		return nil, &ErrorInvalidSize{Size: syspack.Size(info.Size())}
+	}
+	mapping, err := NewMapping(file.Fd(), 0, syspack.Size(info.Size()), &Options{Mode: mode})
+	if err != nil {
+		return nil, err
+	}
+	formatted := &FormattedFile{mapping: mapping}
+	if err := formatted.validate(format); err != nil {
+		mapping.Close()
+		return nil, err
+	}
+	return formatted, nil
+}
+
+// Validate header and payload of mapped file.
+func (formatted *FormattedFile) validate(format *FileFormat) error {
+	header, _ := formatted.mapping.Direct(0, formattedHeaderSize)
+	if magic := binary.LittleEndian.Uint32(header[formattedMagicOffset:]); magic != format.Magic {
+		return &ErrorBadMagic{Magic: magic}
+	}
+	if version := binary.LittleEndian.Uint32(header[formattedVersionOffset:]); version == 0 || version > format.Version {
+		return &ErrorVersionMismatch{Version: version, Expected: format.Version}
+	}
+	checksum := formattedHeaderChecksum(header)
+	if expected := binary.LittleEndian.Uint32(header[formattedHeaderChecksumOffset:]); checksum != expected {
+		return &ErrorChecksum{Checksum: checksum, Expected: expected}
+	}
+	headerSize := syspack.Size(binary.LittleEndian.Uint32(header[formattedHeaderSizeOffset:]))
+	if headerSize < formattedHeaderSize || headerSize > syspack.Size(formatted.mapping.Len()) {
+		return &ErrorInvalidSize{Size: headerSize}
+	}
+	size := syspack.Size(binary.LittleEndian.Uint64(header[formattedPayloadSizeOffset:]))
+	if expected := headerSize + size; size == 0 || expected < headerSize || expected > syspack.Size(formatted.mapping.Len()) {
+		return &ErrorTruncated{Size: syspack.Size(formatted.mapping.Len()), Expected: expected}
+	}
+	formatted.header = header
+	formatted.payload, _ = NewSection(formatted.mapping, syspack.Offset(headerSize), size)
+	payload, _ := formatted.payload.Bytes()
+	checksum = crc32.Checksum(payload, castagnoli)
+	if expected := binary.LittleEndian.Uint32(header[formattedPayloadChecksumOffset:]); checksum != expected {
+		return &ErrorChecksum{Checksum: checksum, Expected: expected}
+	}
+	return nil
+}
+
+// Get format version of file.
+func (formatted *FormattedFile) Version() uint32 {
+	return binary.LittleEndian.Uint32(formatted.header[formattedVersionOffset:])
+}
+
+// Get file creation time.
+func (formatted *FormattedFile) Created() time.Time {
+	return time.Unix(0, int64(binary.LittleEndian.Uint64(formatted.header[formattedCreatedOffset:])))
+}
+
+// Get payload section.
+func (formatted *FormattedFile) Payload() *Section {
+	return formatted.payload
+}
+
+// Update payload checksum and sync file.
+// Payload modified after the last seal fails validation on open.
+func (formatted *FormattedFile) Seal() error {
+	if !formatted.mapping.canWrite {
+		return &ErrorNotAllowed{Operation: "seal"}
+	}
+	payload, err := formatted.payload.Bytes()
+	if err != nil {
+		return err
+	}
+	if err := formatted.payload.Sync(); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(formatted.header[formattedPayloadChecksumOffset:], crc32.Checksum(payload, castagnoli))
+	binary.LittleEndian.PutUint32(formatted.header[formattedHeaderChecksumOffset:], formattedHeaderChecksum(formatted.header))
+	return formatted.mapping.SyncRange(0, formattedHeaderSize)
+}
+
+// Close file without sealing.
+func (formatted *FormattedFile) Close() error {
+	return formatted.mapping.Close()
+}
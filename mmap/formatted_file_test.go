@@ -0,0 +1,99 @@
+package mmap
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testFormattedPath = filepath.Join(os.TempDir(), "test.formatted")
+
+var testFormat = &FileFormat{Magic: 0x54534554, Version: 2}
+
+func TestFormattedFile(t *testing.T) {
+	defer os.Remove(testFormattedPath)
+	if _, err := CreateFormattedFile(testFormattedPath, nil, 1<<12); err == nil {
+		t.Fatal("creation without format must fail")
+	} else if _, ok := err.(*ErrorInvalidArgument); !ok {
+		t.Fatalf("invalid argument error must be returned, %v found", err)
+	}
+	if _, err := CreateFormattedFile(testFormattedPath, &FileFormat{Magic: testFormat.Magic}, 1<<12); err == nil {
+		t.Fatal("creation with zero version must fail")
+	} else if _, ok := err.(*ErrorInvalidArgument); !ok {
+		t.Fatalf("invalid argument error must be returned, %v found", err)
+	}
+	formatted, err := CreateFormattedFile(testFormattedPath, testFormat, 1<<12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(formatted.Created()) > time.Minute {
+		t.Fatalf("creation time must be recent, %v found", formatted.Created())
+	}
+	if _, err := formatted.Payload().WriteAt(testBuffer, 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := formatted.Seal(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := formatted.Payload().WriteAt(testBuffer, 200); err != nil {
+		t.Fatal(err)
+	}
+	if err := formatted.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenFormattedFile(testFormattedPath, testFormat, ModeReadOnly); err == nil {
+		t.Fatal("open of unsealed file must fail")
+	} else if _, ok := err.(*ErrorChecksum); !ok {
+		t.Fatalf("checksum error must be returned, %v found", err)
+	}
+	if formatted, err = OpenFormattedFile(testFormattedPath, &FileFormat{Magic: testFormat.Magic, Version: 3}, ModeReadWritePrivate); err == nil {
+		t.Fatal("open of unsealed file must fail")
+	}
+	if formatted, err = CreateFormattedFile(testFormattedPath, testFormat, 1<<12); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := formatted.Payload().WriteAt(testBuffer, 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := formatted.Seal(); err != nil {
+		t.Fatal(err)
+	}
+	formatted.Close()
+	if formatted, err = OpenFormattedFile(testFormattedPath, &FileFormat{Magic: testFormat.Magic, Version: 3}, ModeReadOnly); err != nil {
+		t.Fatal(err)
+	}
+	if formatted.Version() != testFormat.Version {
+		t.Fatalf("version must be a %d, %d found", testFormat.Version, formatted.Version())
+	}
+	buffer := make([]byte, len(testBuffer))
+	if _, err := formatted.Payload().ReadAt(buffer, 100); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Compare(buffer, testBuffer) != 0 {
+		t.Fatal("payload must be read")
+	}
+	if err := formatted.Seal(); err == nil {
+		t.Fatal("seal of read-only file must fail")
+	}
+	formatted.Close()
+	if _, err := OpenFormattedFile(testFormattedPath, &FileFormat{Magic: testFormat.Magic, Version: 1}, ModeReadOnly); err == nil {
+		t.Fatal("open of newer version must fail")
+	} else if _, ok := err.(*ErrorVersionMismatch); !ok {
+		t.Fatalf("version mismatch error must be returned, %v found", err)
+	}
+	if _, err := OpenFormattedFile(testFormattedPath, &FileFormat{Magic: 1, Version: 2}, ModeReadOnly); err == nil {
+		t.Fatal("open with wrong magic must fail")
+	} else if _, ok := err.(*ErrorBadMagic); !ok {
+		t.Fatalf("bad magic error must be returned, %v found", err)
+	}
+	if err := os.Truncate(testFormattedPath, 1<<11); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenFormattedFile(testFormattedPath, testFormat, ModeReadOnly); err == nil {
+		t.Fatal("open of truncated file must fail")
+	} else if _, ok := err.(*ErrorTruncated); !ok {
+		t.Fatalf("truncation error must be returned, %v found", err)
+	}
+}
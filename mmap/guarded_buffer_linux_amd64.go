@@ -0,0 +1,175 @@
+package mmap
+
+import (
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"syscall"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+type GuardOptions struct {
+	// Guarded buffer options.
+
+	// Label reported on guard page fault.
+	Label string
+
+	// Place inaccessible guard page before buffer too.
+	Leading bool
+}
+
+type GuardedBuffer struct {
+	// Buffer placed at the end of anonymous mapping followed by inaccessible guard page.
+	// Overrun through direct or unsafe access faults immediately.
+
+	// Label.
+	label string
+
+	// Mapped address including guard pages.
+	address uintptr
+
+	// Mapped size including guard pages.
+	size syspack.Size
+
+	// Data.
+	data []byte
+
+	// Data address.
+	dataAddress uintptr
+}
+
+type guardedRange struct {
+	// Mapped address range of live guarded buffer.
+	// Buffer itself is not referenced so it can be finalized.
+
+	// Mapped size including guard pages.
+	size syspack.Size
+
+	// Label.
+	label string
+
+	// Data address.
+	dataAddress uintptr
+}
+
+var (
+	// Live guarded buffers by mapped address.
+	guardedBuffers = make(map[uintptr]guardedRange)
+
+	// Guarded buffers lock.
+	guardedBuffersMutex sync.Mutex
+)
+
+// Make new guarded buffer of given length.
+func NewGuardedBuffer(length syspack.Size, options *GuardOptions) (*GuardedBuffer, error) {
+	pageSize := syspack.Size(os.Getpagesize())
+	if length == 0 || length > syspack.Size(syspack.MaxInt)-3*pageSize {
+		return nil, &ErrorInvalidSize{Size: length}
+	}
+	buffer := &GuardedBuffer{}
+	pagesSize := (length + pageSize - 1) &^ (pageSize - 1)
+	leadingSize := syspack.Size(0)
+	if options != nil {
+		buffer.label = options.Label
+		if options.Leading {
+			leadingSize = pageSize
+		}
+	}
+	buffer.size = leadingSize + pagesSize + pageSize
+	var err error
+	buffer.address, err = syspack.MmapE(
+		0, buffer.size,
+		syscall.PROT_NONE, syscall.MAP_PRIVATE|syscall.MAP_ANONYMOUS,
+		^uintptr(0), 0,
+	)
+	if err != nil {
+		return nil, err
+	}
+	pagesAddress := buffer.address + leadingSize
+	err = syspack.MprotectE(pagesAddress, pagesSize, syscall.PROT_READ|syscall.PROT_WRITE)
+	if err != nil {
+		syspack.Munmap(buffer.address, buffer.size)
+		return nil, err
+	}
+	buffer.dataAddress = pagesAddress + pagesSize - length
+	buffer.data = makeSlice(buffer.dataAddress, int(length))
+	guardedBuffersMutex.Lock()
+	guardedBuffers[buffer.address] = guardedRange{
+		size:        buffer.size,
+		label:       buffer.label,
+		dataAddress: buffer.dataAddress,
+	}
+	guardedBuffersMutex.Unlock()
+	runtime.SetFinalizer(buffer, (*GuardedBuffer).Free)
+	return buffer, nil
+}
+
+// Get buffer label.
+func (buffer *GuardedBuffer) Label() string {
+	return buffer.label
+}
+
+// Get buffer length.
+func (buffer *GuardedBuffer) Len() int {
+	return len(buffer.data)
+}
+
+// Get buffer data.
+func (buffer *GuardedBuffer) Bytes() []byte {
+	return buffer.data
+}
+
+// Free buffer.
+func (buffer *GuardedBuffer) Free() error {
+	if buffer.data == nil {
+		return &ErrorClosed{}
+	}
+	if err := syspack.MunmapE(buffer.address, buffer.size); err != nil {
+		return err
+	}
+	guardedBuffersMutex.Lock()
+	delete(guardedBuffers, buffer.address)
+	guardedBuffersMutex.Unlock()
+	buffer.data = nil
+	runtime.SetFinalizer(buffer, nil)
+	return nil
+}
+
+// Find guarded buffer range which mapping contains given address.
+func findGuardedBuffer(address uintptr) (guardedRange, bool) {
+	guardedBuffersMutex.Lock()
+	defer guardedBuffersMutex.Unlock()
+	for start, buffer := range guardedBuffers {
+		if address >= start && syspack.Size(address-start) < buffer.size {
+			return buffer, true
+		}
+	}
+	return guardedRange{}, false
+}
+
+// Call function recovering guard page faults of guarded buffers.
+// Fault in guard page is returned as ErrorGuardFault, other panics are propagated.
+func GuardedCall(function func()) (err error) {
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		value := recover()
+		if value == nil {
+			return
+		}
+		if fault, ok := value.(interface{ Addr() uintptr }); ok {
+			if buffer, ok := findGuardedBuffer(fault.Addr()); ok {
+				err = &ErrorGuardFault{
+					Label:   buffer.label,
+					Address: fault.Addr(),
+					Offset:  syspack.Offset(fault.Addr()) - syspack.Offset(buffer.dataAddress),
+				}
+				return
+			}
+		}
+		panic(value)
+	}()
+	function()
+	return nil
+}
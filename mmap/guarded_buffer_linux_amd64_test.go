@@ -0,0 +1,62 @@
+package mmap
+
+import (
+	"runtime"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestGuardedBuffer(t *testing.T) {
+	buffer, err := NewGuardedBuffer(100, &GuardOptions{Label: "test", Leading: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buffer.Free()
+	data := buffer.Bytes()
+	err = GuardedCall(func() {
+		for i := range data {
+			data[i] = byte(i)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = GuardedCall(func() {
+		base := unsafe.Pointer(&data[0])
+		*(*byte)(unsafe.Pointer(uintptr(base) + uintptr(len(data)))) = 1
+	})
+	if err == nil {
+		t.Fatal("expected guard fault, no error found")
+	}
+	fault, ok := err.(*ErrorGuardFault)
+	if !ok {
+		t.Fatalf("expected guard fault, [%v] error found", err)
+	}
+	if fault.Label != "test" || fault.Offset != 100 {
+		t.Fatalf("fault of buffer %q at offset 100 expected, %v found", "test", err)
+	}
+	if err := buffer.Free(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGuardedBufferFinalizer(t *testing.T) {
+	buffer, err := NewGuardedBuffer(100, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := buffer.address
+	buffer = nil
+	for i := 0; i < 100; i++ {
+		runtime.GC()
+		guardedBuffersMutex.Lock()
+		_, ok := guardedBuffers[address]
+		guardedBuffersMutex.Unlock()
+		if !ok {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("unreachable buffer must be freed by finalizer")
+}
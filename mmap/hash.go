@@ -0,0 +1,18 @@
+package mmap
+
+// Get 64-bit hash of bytes with given seed.
+// Hash is stable, so it may be stored in files.
+func hashBytes(seed uint64, data []byte) uint64 {
+	// FNV-1a with seeded basis and final avalanche.
+	hash := uint64(14695981039346656037) ^ seed
+	for _, b := range data {
+		hash ^= uint64(b)
+		hash *= 1099511628211
+	}
+	hash ^= hash >> 33
+	hash *= 0xff51afd7ed558ccd
+	hash ^= hash >> 33
+	hash *= 0xc4ceb9fe1a85ec53
+	hash ^= hash >> 33
+	return hash
+}
@@ -0,0 +1,534 @@
+package mmap
+
+import (
+	"encoding/binary"
+	"os"
+	"sync"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+// Hash map format.
+const (
+	hashMapMagic   = 0x50414d48 // "HMAP"
+	hashMapVersion = 1
+)
+
+// Hash map header layout.
+const (
+	hashMapMagicOffset     = 0
+	hashMapVersionOffset   = 4
+	hashMapSlotsOffset     = 8
+	hashMapKeySizeOffset   = 16
+	hashMapValueSizeOffset = 20
+	hashMapResizingOffset  = 24
+	hashMapHeaderSize      = cacheLineSize
+)
+
+// Hash map slot layout.
+// Slot state is written last, so slot is never seen half-inserted.
+const (
+	hashMapHashOffset        = 0
+	hashMapStateOffset       = 8
+	hashMapKeyLengthOffset   = 12
+	hashMapValueLengthOffset = 16
+	hashMapSlotHeaderSize    = 24
+)
+
+// Hash map slot states.
+const (
+	hashMapEmpty = iota
+	hashMapUsed
+	hashMapDeleted
+)
+
+// Hash map defaults.
+const (
+	defaultHashMapSlots = 64
+)
+
+type HashMapOptions struct {
+	// Hash map options.
+
+	// Maximum key length.
+	KeySize syspack.Size
+
+	// Maximum value length.
+	ValueSize syspack.Size
+
+	// Initial number of slots, rounded up to a power of two.
+	Slots uint64
+}
+
+type HashMap struct {
+	// Open-addressing hash map stored in mapped file.
+	// Keys and values have variable length up to configured maximum.
+	// Map grows by doubling: file is extended, remapped and rehashed in place.
+
+	// File path.
+	path string
+
+	// Mapping.
+	mapping *Mapping
+
+	// Data.
+	data []byte
+
+	// Number of slots.
+	slots uint64
+
+	// Maximum key length.
+	keySize uint64
+
+	// Maximum value length.
+	valueSize uint64
+
+	// Slot stride.
+	stride uint64
+
+	// Number of used slots.
+	count uint64
+
+	// Number of deleted slots.
+	deleted uint64
+
+	// Lock.
+	mutex sync.RWMutex
+}
+
+// Create hash map file.
+func CreateHashMap(path string, options *HashMapOptions) (*HashMap, error) {
+	if options == nil || options.KeySize == 0 || options.KeySize > syspack.Size(syspack.MaxDword) {
+		return nil, &ErrorInvalidSize{Size: 0}
+	}
+	if options.ValueSize > syspack.Size(syspack.MaxDword) {
+		return nil, &ErrorInvalidSize{Size: options.ValueSize}
+	}
+	slots := uint64(defaultHashMapSlots)
+	for slots < options.Slots {
+		slots <<= 1
+	}
+	hashMap := &HashMap{
+		path:      path,
+		keySize:   uint64(options.KeySize),
+		valueSize: uint64(options.ValueSize),
+	}
+	hashMap.stride = hashMapStride(hashMap.keySize, hashMap.valueSize)
+	if err := hashMap.create(path, slots, os.O_EXCL); err != nil {
+		return nil, err
+	}
+	return hashMap, nil
+}
+
+// Open existing hash map file.
+// Interrupted resize is completed.
+func OpenHashMap(path string) (*HashMap, error) {
+	hashMap := &HashMap{path: path}
+	if err := hashMap.open(path); err != nil {
+		return nil, err
+	}
+	return hashMap, nil
+}
+
+// Get slot stride.
+func hashMapStride(keySize, valueSize uint64) uint64 {
+	return (hashMapSlotHeaderSize + keySize + valueSize + 7) &^ 7
+}
+
+// Create and map hash map file with given number of slots.
+func (hashMap *HashMap) create(path string, slots uint64, flags int) error {
+	size := hashMapHeaderSize + slots*hashMap.stride
+	if size/hashMap.stride < slots || size > uint64(syspack.MaxInt) {
+		return &ErrorInvalidSize{Size: syspack.Size(size)}
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|flags, 0666)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := file.Truncate(int64(size)); err != nil {
+		return err
+	}
+	mapping, err := NewMapping(file.Fd(), 0, syspack.Size(size), &Options{Mode: ModeReadWrite})
+	if err != nil {
+		return err
+	}
+	data, _ := mapping.Direct(0, syspack.Offset(size))
+	binary.LittleEndian.PutUint32(data[hashMapMagicOffset:], hashMapMagic)
+	binary.LittleEndian.PutUint32(data[hashMapVersionOffset:], hashMapVersion)
+	binary.LittleEndian.PutUint64(data[hashMapSlotsOffset:], slots)
+	binary.LittleEndian.PutUint32(data[hashMapKeySizeOffset:], uint32(hashMap.keySize))
+	binary.LittleEndian.PutUint32(data[hashMapValueSizeOffset:], uint32(hashMap.valueSize))
+	hashMap.mapping = mapping
+	hashMap.data = data
+	hashMap.slots = slots
+	hashMap.count = 0
+	hashMap.deleted = 0
+	return nil
+}
+
+// Open and map existing hash map file.
+func (hashMap *HashMap) open(path string) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < hashMapHeaderSize || info.Size() > int64(syspack.MaxInt) {
+		return &ErrorInvalidSize{Size: syspack.Size(info.Size())}
+	}
+	mapping, err := NewMapping(file.Fd(), 0, syspack.Size(info.Size()), &Options{Mode: ModeReadWrite})
+	if err != nil {
+		return err
+	}
+	data, _ := mapping.Direct(0, syspack.Offset(info.Size()))
+	if magic := binary.LittleEndian.Uint32(data[hashMapMagicOffset:]); magic != hashMapMagic {
+		mapping.Close()
+		return &ErrorBadMagic{Magic: magic}
+	}
+	if version := binary.LittleEndian.Uint32(data[hashMapVersionOffset:]); version != hashMapVersion {
+		mapping.Close()
+		return &ErrorVersionMismatch{Version: version, Expected: hashMapVersion}
+	}
+	slots := binary.LittleEndian.Uint64(data[hashMapSlotsOffset:])
+	keySize := uint64(binary.LittleEndian.Uint32(data[hashMapKeySizeOffset:]))
+	valueSize := uint64(binary.LittleEndian.Uint32(data[hashMapValueSizeOffset:]))
+	stride := hashMapStride(keySize, valueSize)
+	resizing := binary.LittleEndian.Uint32(data[hashMapResizingOffset:]) != 0
+	if resizing {
+		// File may be already extended while header is not updated yet.
+		slots = (uint64(info.Size()) - hashMapHeaderSize) / stride
+	}
+	if slots == 0 || slots&(slots-1) != 0 || keySize == 0 ||
+		(uint64(info.Size())-hashMapHeaderSize)/stride != slots {
+		mapping.Close()
+		return &ErrorInvalidSize{Size: syspack.Size(info.Size())}
+	}
+	hashMap.mapping = mapping
+	hashMap.data = data
+	hashMap.slots = slots
+	hashMap.keySize = keySize
+	hashMap.valueSize = valueSize
+	hashMap.stride = stride
+	if resizing {
+		binary.LittleEndian.PutUint64(data[hashMapSlotsOffset:], slots)
+		if err := hashMap.rehash(); err != nil {
+			mapping.Close()
+			return err
+		}
+		return nil
+	}
+	hashMap.count, hashMap.deleted = 0, 0
+	for i := uint64(0); i < slots; i++ {
+		switch hashMap.state(i) {
+		case hashMapUsed:
+			hashMap.count++
+		case hashMapDeleted:
+			hashMap.deleted++
+		}
+	}
+	return nil
+}
+
+// Get slot data.
+func (hashMap *HashMap) slot(index uint64) []byte {
+	start := hashMapHeaderSize + index*hashMap.stride
+	return hashMap.data[start : start+hashMap.stride]
+}
+
+// Get slot state.
+func (hashMap *HashMap) state(index uint64) uint32 {
+	return binary.LittleEndian.Uint32(hashMap.slot(index)[hashMapStateOffset:])
+}
+
+// Get slot key and value.
+func (hashMap *HashMap) entry(slot []byte) ([]byte, []byte) {
+	keyLength := uint64(binary.LittleEndian.Uint32(slot[hashMapKeyLengthOffset:]))
+	valueLength := uint64(binary.LittleEndian.Uint32(slot[hashMapValueLengthOffset:]))
+	if keyLength > hashMap.keySize {
+		keyLength = hashMap.keySize
+	}
+	if valueLength > hashMap.valueSize {
+		valueLength = hashMap.valueSize
+	}
+	key := slot[hashMapSlotHeaderSize : hashMapSlotHeaderSize+keyLength]
+	value := slot[hashMapSlotHeaderSize+hashMap.keySize:]
+	return key, value[:valueLength]
+}
+
+// Find slot of key.
+// Returns index of used slot with key or index of the first free slot on probe path.
+func (hashMap *HashMap) find(key []byte, hash uint64) (uint64, bool) {
+	free := hashMap.slots
+	for i, index := uint64(0), hash&(hashMap.slots-1); i < hashMap.slots; i, index = i+1, (index+1)&(hashMap.slots-1) {
+		slot := hashMap.slot(index)
+		switch binary.LittleEndian.Uint32(slot[hashMapStateOffset:]) {
+		case hashMapEmpty:
+			if free == hashMap.slots {
+				free = index
+			}
+			return free, false
+		case hashMapDeleted:
+			if free == hashMap.slots {
+				free = index
+			}
+		case hashMapUsed:
+			if binary.LittleEndian.Uint64(slot[hashMapHashOffset:]) != hash {
+				continue
+			}
+			if slotKey, _ := hashMap.entry(slot); string(slotKey) == string(key) {
+				return index, true
+			}
+		}
+	}
+	return free, false
+}
+
+// Get number of entries.
+func (hashMap *HashMap) Len() uint64 {
+	hashMap.mutex.RLock()
+	defer hashMap.mutex.RUnlock()
+	return hashMap.count
+}
+
+// Get number of slots.
+func (hashMap *HashMap) Slots() uint64 {
+	hashMap.mutex.RLock()
+	defer hashMap.mutex.RUnlock()
+	return hashMap.slots
+}
+
+// Get copy of value of key.
+// Returns false if there is no such key.
+func (hashMap *HashMap) Get(key []byte) ([]byte, bool, error) {
+	hashMap.mutex.RLock()
+	defer hashMap.mutex.RUnlock()
+	if hashMap.data == nil {
+		return nil, false, &ErrorClosed{}
+	}
+	index, ok := hashMap.find(key, hashBytes(0, key))
+	if !ok {
+		return nil, false, nil
+	}
+	_, value := hashMap.entry(hashMap.slot(index))
+	return append([]byte(nil), value...), true, nil
+}
+
+// Put value of key.
+func (hashMap *HashMap) Put(key, value []byte) error {
+	hashMap.mutex.Lock()
+	defer hashMap.mutex.Unlock()
+	if hashMap.data == nil {
+		return &ErrorClosed{}
+	}
+	if len(key) == 0 || uint64(len(key)) > hashMap.keySize {
+		return &ErrorInvalidSize{Size: syspack.Len(key)}
+	}
+	if uint64(len(value)) > hashMap.valueSize {
+		return &ErrorInvalidSize{Size: syspack.Len(value)}
+	}
+	hash := hashBytes(0, key)
+	index, ok := hashMap.find(key, hash)
+	if !ok && (hashMap.count+hashMap.deleted+1)*10 > hashMap.slots*7 {
+		slots := hashMap.slots
+		if (hashMap.count+1)*2 > slots {
+			slots <<= 1
+		}
+		if err := hashMap.resize(slots); err != nil {
+			return err
+		}
+		index, ok = hashMap.find(key, hash)
+	}
+	slot := hashMap.slot(index)
+	if ok {
+		copy(slot[hashMapSlotHeaderSize+hashMap.keySize:], value)
+		binary.LittleEndian.PutUint32(slot[hashMapValueLengthOffset:], uint32(len(value)))
+		return nil
+	}
+	if hashMap.put(slot, key, value, hash) == hashMapDeleted {
+		hashMap.deleted--
+	}
+	hashMap.count++
+	return nil
+}
+
+// Put entry into free slot returning its previous state.
+func (hashMap *HashMap) put(slot []byte, key, value []byte, hash uint64) uint32 {
+	state := binary.LittleEndian.Uint32(slot[hashMapStateOffset:])
+	binary.LittleEndian.PutUint64(slot[hashMapHashOffset:], hash)
+	binary.LittleEndian.PutUint32(slot[hashMapKeyLengthOffset:], uint32(len(key)))
+	binary.LittleEndian.PutUint32(slot[hashMapValueLengthOffset:], uint32(len(value)))
+	copy(slot[hashMapSlotHeaderSize:], key)
+	copy(slot[hashMapSlotHeaderSize+hashMap.keySize:], value)
+	binary.LittleEndian.PutUint32(slot[hashMapStateOffset:], hashMapUsed)
+	return state
+}
+
+// Delete key.
+// Returns false if there was no such key.
+func (hashMap *HashMap) Delete(key []byte) (bool, error) {
+	hashMap.mutex.Lock()
+	defer hashMap.mutex.Unlock()
+	if hashMap.data == nil {
+		return false, &ErrorClosed{}
+	}
+	index, ok := hashMap.find(key, hashBytes(0, key))
+	if !ok {
+		return false, nil
+	}
+	binary.LittleEndian.PutUint32(hashMap.slot(index)[hashMapStateOffset:], hashMapDeleted)
+	hashMap.count--
+	hashMap.deleted++
+	return true, nil
+}
+
+// Call function for every entry.
+// Key and value refer directly to mapping and are valid during the call only.
+// Iteration stops on first error returned by function, map must not be modified by it.
+func (hashMap *HashMap) ForEach(function func(key, value []byte) error) error {
+	hashMap.mutex.RLock()
+	defer hashMap.mutex.RUnlock()
+	if hashMap.data == nil {
+		return &ErrorClosed{}
+	}
+	for i := uint64(0); i < hashMap.slots; i++ {
+		slot := hashMap.slot(i)
+		if binary.LittleEndian.Uint32(slot[hashMapStateOffset:]) != hashMapUsed {
+			continue
+		}
+		if err := function(hashMap.entry(slot)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Set or clear resizing flag and sync header.
+func (hashMap *HashMap) setResizing(resizing bool) error {
+	flag := uint32(0)
+	if resizing {
+		flag = 1
+	}
+	binary.LittleEndian.PutUint32(hashMap.data[hashMapResizingOffset:], flag)
+	return hashMap.mapping.SyncRange(0, hashMapHeaderSize)
+}
+
+// Rebuild table with given number of slots dropping deleted entries.
+// Resizing flag is set while table is inconsistent and rehash restarts on open after crash,
+// so crash at any moment loses no entry.
+func (hashMap *HashMap) resize(slots uint64) error {
+	size := hashMapHeaderSize + slots*hashMap.stride
+	if size/hashMap.stride < slots || size > uint64(syspack.MaxInt) {
+		return &ErrorInvalidSize{Size: syspack.Size(size)}
+	}
+	if err := hashMap.setResizing(true); err != nil {
+		return err
+	}
+	if slots != hashMap.slots {
+		if err := hashMap.extend(size); err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint64(hashMap.data[hashMapSlotsOffset:], slots)
+		hashMap.slots = slots
+	}
+	return hashMap.rehash()
+}
+
+// Extend file to given size and remap it.
+// Old mapping is kept if extension fails.
+func (hashMap *HashMap) extend(size uint64) error {
+	file, err := os.OpenFile(hashMap.path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := file.Truncate(int64(size)); err != nil {
+		return err
+	}
+	mapping, err := NewMapping(file.Fd(), 0, syspack.Size(size), &Options{Mode: ModeReadWrite})
+	if err != nil {
+		return err
+	}
+	if err := hashMap.mapping.Close(); err != nil {
+		mapping.Close()
+		return err
+	}
+	hashMap.mapping = mapping
+	hashMap.data, _ = mapping.Direct(0, syspack.Offset(size))
+	return nil
+}
+
+// Move entries to slots reachable from their home slots until table is consistent,
+// then sync it and clear resizing flag.
+// Moved entries are synced before their old slots are deleted, so interrupted rehash
+// leaves duplicates only, which are dropped when rehash is restarted.
+func (hashMap *HashMap) rehash() error {
+	for moved := true; moved; {
+		moved = false
+		for i := uint64(0); i < hashMap.slots; i++ {
+			if hashMap.state(i) == hashMapDeleted {
+				binary.LittleEndian.PutUint32(hashMap.slot(i)[hashMapStateOffset:], hashMapEmpty)
+			}
+		}
+		for i := uint64(0); i < hashMap.slots; i++ {
+			slot := hashMap.slot(i)
+			if binary.LittleEndian.Uint32(slot[hashMapStateOffset:]) != hashMapUsed {
+				continue
+			}
+			key, value := hashMap.entry(slot)
+			hash := binary.LittleEndian.Uint64(slot[hashMapHashOffset:])
+			if index, ok := hashMap.find(key, hash); !ok {
+				hashMap.put(hashMap.slot(index), key, value, hash)
+				moved = true
+			}
+		}
+		if err := hashMap.mapping.syncAll(); err != nil {
+			return err
+		}
+		for i := uint64(0); i < hashMap.slots; i++ {
+			slot := hashMap.slot(i)
+			if binary.LittleEndian.Uint32(slot[hashMapStateOffset:]) != hashMapUsed {
+				continue
+			}
+			key, _ := hashMap.entry(slot)
+			if index, _ := hashMap.find(key, binary.LittleEndian.Uint64(slot[hashMapHashOffset:])); index != i {
+				binary.LittleEndian.PutUint32(slot[hashMapStateOffset:], hashMapDeleted)
+			}
+		}
+	}
+	hashMap.count, hashMap.deleted = 0, 0
+	for i := uint64(0); i < hashMap.slots; i++ {
+		switch hashMap.state(i) {
+		case hashMapUsed:
+			hashMap.count++
+		case hashMapDeleted:
+			hashMap.deleted++
+		}
+	}
+	if err := hashMap.mapping.syncAll(); err != nil {
+		return err
+	}
+	return hashMap.setResizing(false)
+}
+
+// Sync hash map file.
+func (hashMap *HashMap) Sync() error {
+	hashMap.mutex.Lock()
+	defer hashMap.mutex.Unlock()
+	return hashMap.mapping.syncAll()
+}
+
+// Close hash map file.
+func (hashMap *HashMap) Close() error {
+	hashMap.mutex.Lock()
+	defer hashMap.mutex.Unlock()
+	if err := hashMap.mapping.Close(); err != nil {
+		return err
+	}
+	hashMap.data = nil
+	return nil
+}
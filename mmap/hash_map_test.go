@@ -0,0 +1,118 @@
+package mmap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testHashMapPath = filepath.Join(os.TempDir(), "test.hmap")
+
+func TestHashMap(t *testing.T) {
+	os.Remove(testHashMapPath)
+	defer os.Remove(testHashMapPath)
+	hashMap, err := CreateHashMap(testHashMapPath, &HashMapOptions{KeySize: 16, ValueSize: 300})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hashMap.Close()
+	const count = 1000
+	for i := 0; i < count; i++ {
+		if err := hashMap.Put([]byte(fmt.Sprint("key", i)), makeTestMessage(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < count; i += 2 {
+		if ok, err := hashMap.Delete([]byte(fmt.Sprint("key", i))); err != nil || !ok {
+			t.Fatalf("key %d must be deleted, %v %v found", i, ok, err)
+		}
+	}
+	if err := hashMap.Put([]byte("key1"), testBuffer); err != nil {
+		t.Fatal(err)
+	}
+	if hashMap.Slots() < count {
+		t.Fatalf("map must grow, %d slots found", hashMap.Slots())
+	}
+	if err := hashMap.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if hashMap, err = OpenHashMap(testHashMapPath); err != nil {
+		t.Fatal(err)
+	}
+	if hashMap.Len() != count/2 {
+		t.Fatalf("length must be a %d, %d found", count/2, hashMap.Len())
+	}
+	for i := 0; i < count; i++ {
+		value, ok, err := hashMap.Get([]byte(fmt.Sprint("key", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := makeTestMessage(i)
+		if i == 1 {
+			expected = testBuffer
+		}
+		if i%2 == 0 {
+			if ok {
+				t.Fatalf("key %d must be deleted, %v found", i, value)
+			}
+		} else if !ok || bytes.Compare(value, expected) != 0 {
+			t.Fatalf("value %d must be a %v, %v found", i, expected, value)
+		}
+	}
+	if err := hashMap.Put(make([]byte, 17), nil); err == nil {
+		t.Fatal("expected invalid size, no error found")
+	}
+}
+
+func TestHashMapInterruptedResize(t *testing.T) {
+	os.Remove(testHashMapPath)
+	defer os.Remove(testHashMapPath)
+	hashMap, err := CreateHashMap(testHashMapPath, &HashMapOptions{KeySize: 16, ValueSize: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hashMap.Close()
+	const count = 40
+	for i := 0; i < count; i++ {
+		if err := hashMap.Put([]byte(fmt.Sprint("key", i)), []byte(fmt.Sprint("value", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	slots, stride := hashMap.Slots(), hashMap.stride
+	if err := hashMap.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Crash after file is extended and entry is moved, but before its old slot is deleted.
+	data, err := ioutil.ReadFile(testHashMapPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, make([]byte, slots*stride)...)
+	binary.LittleEndian.PutUint32(data[hashMapResizingOffset:], 1)
+	for i := uint64(0); i < slots; i++ {
+		slot := data[hashMapHeaderSize+i*stride:][:stride]
+		if binary.LittleEndian.Uint32(slot[hashMapStateOffset:]) == hashMapUsed {
+			copy(data[uint64(len(data))-stride:], slot)
+			break
+		}
+	}
+	if err := ioutil.WriteFile(testHashMapPath, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if hashMap, err = OpenHashMap(testHashMapPath); err != nil {
+		t.Fatal(err)
+	}
+	if hashMap.Slots() != slots*2 || hashMap.Len() != count {
+		t.Fatalf("resize must be completed, %d slots and %d entries found", hashMap.Slots(), hashMap.Len())
+	}
+	for i := 0; i < count; i++ {
+		expected := []byte(fmt.Sprint("value", i))
+		if value, ok, err := hashMap.Get([]byte(fmt.Sprint("key", i))); err != nil || !ok || bytes.Compare(value, expected) != 0 {
+			t.Fatalf("value %d must be a %q, %q %v %v found", i, expected, value, ok, err)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package mmap
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+// Journal format.
+const (
+	journalMagic   = 0x4c4e524a // "JRNL"
+	journalVersion = 1
+)
+
+// Journal header layout.
+// Header is followed by entries, checksum covers header fields except itself and all entries.
+const (
+	journalMagicOffset    = 0
+	journalVersionOffset  = 4
+	journalCountOffset    = 8
+	journalChecksumOffset = 12
+	journalSizeOffset     = 16
+	journalHeaderSize     = 24
+)
+
+// Journal entry layout.
+const (
+	journalEntryOffsetOffset = 0
+	journalEntryLengthOffset = 8
+	journalEntryHeaderSize   = 16
+)
+
+type Journal struct {
+	// Redo journal making updates of several ranges of mapping atomic.
+	// Committed updates are written and synced to journal file before they reach mapping,
+	// so recovery on open replays them if mapping was not synced completely.
+
+	// Journal file.
+	file *os.File
+
+	// Mapping.
+	mapping *Mapping
+
+	// Lock held by active transaction.
+	mutex sync.Mutex
+
+	// Transaction is active.
+	active uint32
+}
+
+type JournalTx struct {
+	// Journal transaction.
+
+	// Journal.
+	journal *Journal
+
+	// Encoded entries.
+	entries []byte
+
+	// Number of entries.
+	count uint32
+}
+
+// Open journal file for given mapping, replaying committed transaction found in it.
+func OpenJournal(path string, mapping *Mapping) (*Journal, error) {
+	if !mapping.CanWrite() {
+		return nil, &ErrorNotAllowed{Operation: "write"}
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0666)
+	created := err == nil
+	if os.IsExist(err) {
+		file, err = os.OpenFile(path, os.O_RDWR, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		if err := syncDir(filepath.Dir(path)); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	journal := &Journal{file: file, mapping: mapping}
+	if err := journal.recover(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return journal, nil
+}
+
+// Get journal checksum.
+func journalChecksum(header, entries []byte) uint32 {
+	checksum := crc32.Checksum(header[:journalChecksumOffset], castagnoli)
+	checksum = crc32.Update(checksum, castagnoli, header[journalSizeOffset:journalHeaderSize])
+	return crc32.Update(checksum, castagnoli, entries)
+}
+
+// Replay committed transaction and clear journal.
+// Incomplete transaction is discarded.
+func (journal *Journal) recover() error {
+	info, err := journal.file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() >= journalHeaderSize {
+		header := make([]byte, journalHeaderSize)
+		if _, err := journal.file.ReadAt(header, 0); err != nil {
+			return err
+		}
+		if err := journal.replay(header, info.Size()); err != nil {
+			return err
+		}
+	}
+	return journal.clear()
+}
+
+// Replay transaction described by given header if it is complete.
+func (journal *Journal) replay(header []byte, fileSize int64) error {
+	magic := binary.LittleEndian.Uint32(header[journalMagicOffset:])
+	if magic == 0 {
+		return nil
+	}
+	if magic != journalMagic {
+		return &ErrorBadMagic{Magic: magic}
+	}
+	if version := binary.LittleEndian.Uint32(header[journalVersionOffset:]); version != journalVersion {
+		return &ErrorVersionMismatch{Version: version, Expected: journalVersion}
+	}
+	size := binary.LittleEndian.Uint64(header[journalSizeOffset:])
+	if size > uint64(fileSize-journalHeaderSize) {
+		return nil
+	}
+	entries := make([]byte, size)
+	if _, err := journal.file.ReadAt(entries, journalHeaderSize); err != nil && err != io.EOF {
+		return err
+	}
+	if journalChecksum(header, entries) != binary.LittleEndian.Uint32(header[journalChecksumOffset:]) {
+		return nil
+	}
+	return journal.apply(entries, binary.LittleEndian.Uint32(header[journalCountOffset:]))
+}
+
+// Apply entries to mapping and sync updated ranges.
+func (journal *Journal) apply(entries []byte, count uint32) error {
+	for ; count > 0; count-- {
+		if len(entries) < journalEntryHeaderSize {
+			return &ErrorInvalidSize{Size: syspack.Len(entries)}
+		}
+		offset := syspack.Offset(binary.LittleEndian.Uint64(entries[journalEntryOffsetOffset:]))
+		length := binary.LittleEndian.Uint64(entries[journalEntryLengthOffset:])
+		if length > uint64(len(entries)-journalEntryHeaderSize) {
+			return &ErrorInvalidSize{Size: syspack.Size(length)}
+		}
+		high := offset + syspack.Offset(length)
+		data, err := journal.mapping.Direct(offset, high)
+		if err != nil {
+			return err
+		}
+		copy(data, entries[journalEntryHeaderSize:])
+		if err := journal.mapping.SyncRange(offset, high); err != nil {
+			return err
+		}
+		entries = entries[journalEntryHeaderSize+length:]
+	}
+	return nil
+}
+
+// Clear journal file.
+func (journal *Journal) clear() error {
+	if err := journal.file.Truncate(0); err != nil {
+		return err
+	}
+	return journal.file.Sync()
+}
+
+// Write transaction entries to journal file and sync it.
+func (journal *Journal) write(entries []byte, count uint32) error {
+	header := make([]byte, journalHeaderSize)
+	binary.LittleEndian.PutUint32(header[journalMagicOffset:], journalMagic)
+	binary.LittleEndian.PutUint32(header[journalVersionOffset:], journalVersion)
+	binary.LittleEndian.PutUint32(header[journalCountOffset:], count)
+	binary.LittleEndian.PutUint64(header[journalSizeOffset:], uint64(len(entries)))
+	binary.LittleEndian.PutUint32(header[journalChecksumOffset:], journalChecksum(header, entries))
+	if _, err := journal.file.WriteAt(append(header, entries...), 0); err != nil {
+		return err
+	}
+	return journal.file.Sync()
+}
+
+// Begin transaction.
+// Only one transaction may be active at a time, so Begin blocks until the previous one is finished.
+func (journal *Journal) Begin() *JournalTx {
+	journal.mutex.Lock()
+	atomic.StoreUint32(&journal.active, 1)
+	return &JournalTx{journal: journal}
+}
+
+// Close journal.
+// Active transaction must be finished before.
+func (journal *Journal) Close() error {
+	if atomic.LoadUint32(&journal.active) != 0 {
+		return &ErrorNotAllowed{Operation: "close with active transaction"}
+	}
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	return journal.file.Close()
+}
+
+// Write len(buffer) bytes to mapping at given offset on commit.
+func (tx *JournalTx) WriteAt(buffer []byte, offset syspack.Offset) (int, error) {
+	if tx.journal == nil {
+		return 0, &ErrorClosed{}
+	}
+	if len(buffer) == 0 {
+		return 0, nil
+	}
+	if _, err := tx.journal.mapping.Direct(offset, offset+syspack.Offset(len(buffer))); err != nil {
+		return 0, err
+	}
+	var header [journalEntryHeaderSize]byte
+	binary.LittleEndian.PutUint64(header[journalEntryOffsetOffset:], uint64(offset))
+	binary.LittleEndian.PutUint64(header[journalEntryLengthOffset:], uint64(len(buffer)))
+	tx.entries = append(append(tx.entries, header[:]...), buffer...)
+	tx.count++
+	return len(buffer), nil
+}
+
+// Commit transaction.
+// Updates are durable once journal file is synced, error after that is reported
+// but updates are still replayed by recovery on the next open.
+func (tx *JournalTx) Commit() error {
+	if tx.journal == nil {
+		return &ErrorClosed{}
+	}
+	journal, entries, count := tx.journal, tx.entries, tx.count
+	defer journal.mutex.Unlock()
+	defer atomic.StoreUint32(&journal.active, 0)
+	tx.journal, tx.entries = nil, nil
+	if count == 0 {
+		return nil
+	}
+	if err := journal.write(entries, count); err != nil {
+		return err
+	}
+	if err := journal.apply(entries, count); err != nil {
+		return err
+	}
+	return journal.clear()
+}
+
+// Rollback transaction discarding its updates.
+func (tx *JournalTx) Rollback() error {
+	if tx.journal == nil {
+		return &ErrorClosed{}
+	}
+	atomic.StoreUint32(&tx.journal.active, 0)
+	tx.journal.mutex.Unlock()
+	tx.journal, tx.entries = nil, nil
+	return nil
+}
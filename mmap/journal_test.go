@@ -0,0 +1,107 @@
+package mmap
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testJournalPath = filepath.Join(os.TempDir(), "test.journal")
+
+func TestJournal(t *testing.T) {
+	defer os.Remove(testJournalPath)
+	os.Remove(testJournalPath)
+	mapping, err := makeTestMapping(ModeReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mapping.Close()
+	journal, err := OpenJournal(testJournalPath, mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offsets := []int64{0, 5000, 100000}
+	tx := journal.Begin()
+	for _, offset := range offsets {
+		if _, err := tx.WriteAt(testBuffer, offset); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := tx.WriteAt(testBuffer, int64(testLength)); err == nil {
+		t.Fatal("write out of mapping must fail")
+	}
+	if data, _ := mapping.Direct(0, 1); data[0] == testBuffer[0] {
+		t.Fatal("write must not be visible before commit")
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err == nil {
+		t.Fatal("second commit must fail")
+	}
+	buffer := make([]byte, len(testBuffer))
+	for _, offset := range offsets {
+		if _, err := mapping.ReadAt(buffer, offset); err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Compare(buffer, testBuffer) != 0 {
+			t.Fatalf("committed write at %d must be visible", offset)
+		}
+	}
+	tx = journal.Begin()
+	if _, err := tx.WriteAt(make([]byte, len(testBuffer)), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mapping.ReadAt(buffer, 0); err != nil || bytes.Compare(buffer, testBuffer) != 0 {
+		t.Fatal("rolled back write must not be visible")
+	}
+	// Simulate crash after journal was synced but before mapping was updated.
+	zero := make([]byte, len(testBuffer))
+	tx = journal.Begin()
+	for _, offset := range offsets {
+		tx.WriteAt(zero, offset)
+	}
+	if err := journal.write(tx.entries, tx.count); err != nil {
+		t.Fatal(err)
+	}
+	if err := journal.Close(); err == nil {
+		t.Fatal("close with active transaction must fail")
+	}
+	tx.Rollback()
+	if err := journal.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if journal, err = OpenJournal(testJournalPath, mapping); err != nil {
+		t.Fatal(err)
+	}
+	for _, offset := range offsets {
+		if _, err := mapping.ReadAt(buffer, offset); err != nil || bytes.Compare(buffer, zero) != 0 {
+			t.Fatalf("committed write at %d must be replayed", offset)
+		}
+	}
+	// Simulate crash during journal write.
+	tx = journal.Begin()
+	tx.WriteAt(testBuffer, 0)
+	if err := journal.write(tx.entries, tx.count); err != nil {
+		t.Fatal(err)
+	}
+	tx.Rollback()
+	journal.Close()
+	if err := os.Truncate(testJournalPath, journalHeaderSize+journalEntryHeaderSize+1); err != nil {
+		t.Fatal(err)
+	}
+	if journal, err = OpenJournal(testJournalPath, mapping); err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+	if _, err := mapping.ReadAt(buffer, 0); err != nil || bytes.Compare(buffer, zero) != 0 {
+		t.Fatal("incomplete transaction must be discarded")
+	}
+	if info, err := os.Stat(testJournalPath); err != nil || info.Size() != 0 {
+		t.Fatal("journal must be cleared")
+	}
+}
@@ -0,0 +1,544 @@
+package mmap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+// Log format.
+const (
+	logMagic   = 0x4745534c // "LSEG"
+	logVersion = 1
+)
+
+// Log segment layout.
+// Record checksum covers record length, offset and data.
+const (
+	logMagicOffset       = 0
+	logVersionOffset     = 4
+	logBaseOffset        = 8
+	logCreatedOffset     = 16
+	logHeaderSize        = cacheLineSize
+	logLengthOffset      = 0
+	logChecksumOffset    = 4
+	logRecordOffset      = 8
+	logRecordHeaderSize  = 16
+	logRecordAlignment   = 8
+	logIndexEntrySize    = 8
+	logSegmentSuffix     = ".log"
+	logIndexSuffix       = ".index"
+	defaultLogSegment    = 64 << 20
+	defaultIndexInterval = 4096
+)
+
+type LogOptions struct {
+	// Log options.
+
+	// Segment file size, 64 MiB by default.
+	SegmentSize syspack.Size
+
+	// Minimum number of bytes between sparse index entries, 4 KiB by default.
+	IndexInterval syspack.Size
+
+	// Maximum age of segment after which the next append rolls it, unlimited by default.
+	MaxAge time.Duration
+}
+
+type logSegment struct {
+	// Log segment with its sparse index.
+
+	// Offset of the first record.
+	base uint64
+
+	// Creation time.
+	created time.Time
+
+	// Mapping.
+	mapping *Mapping
+
+	// Data.
+	data []byte
+
+	// Index mapping.
+	indexMapping *Mapping
+
+	// Index data.
+	index []byte
+
+	// Number of index entries.
+	entries int
+
+	// Write position.
+	position uint64
+
+	// Position of the last indexed record.
+	indexed uint64
+
+	// Offset of the next record.
+	next uint64
+}
+
+type Log struct {
+	// Append-only log of records addressed by sequential logical offsets.
+	// Records are stored in fixed-size segment files named by base offset,
+	// each accompanied by sparse index mapping relative offsets to positions.
+	// Records are read directly from mappings and are valid until log is truncated or closed.
+
+	// Directory.
+	dir string
+
+	// Options.
+	options LogOptions
+
+	// Segments in offset order.
+	segments []*logSegment
+
+	// Lock.
+	mutex sync.RWMutex
+}
+
+// Open log in given directory creating it if necessary.
+func OpenLog(dir string, options *LogOptions) (*Log, error) {
+	log := &Log{dir: dir}
+	if options != nil {
+		log.options = *options
+	}
+	if log.options.SegmentSize == 0 {
+		log.options.SegmentSize = defaultLogSegment
+	}
+	if log.options.IndexInterval == 0 {
+		log.options.IndexInterval = defaultIndexInterval
+	}
+	if log.options.SegmentSize <= logHeaderSize+logRecordHeaderSize ||
+		log.options.SegmentSize > syspack.Size(syspack.MaxDword) {
+		return nil, &ErrorInvalidSize{Size: log.options.SegmentSize}
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	if err := log.open(); err != nil {
+		log.closeAll()
+		return nil, err
+	}
+	return log, nil
+}
+
+// Open existing segments recovering the last one.
+func (log *Log) open() error {
+	paths, err := filepath.Glob(filepath.Join(log.dir, "*"+logSegmentSuffix))
+	if err != nil {
+		return err
+	}
+	var bases []uint64
+	for _, path := range paths {
+		var base uint64
+		if _, err := fmt.Sscanf(filepath.Base(path), "%020d"+logSegmentSuffix, &base); err == nil {
+			bases = append(bases, base)
+		}
+	}
+	sort.Slice(bases, func(i, j int) bool { return bases[i] < bases[j] })
+	for i, base := range bases {
+		segment, err := log.openSegment(base)
+		if err != nil {
+			return err
+		}
+		log.segments = append(log.segments, segment)
+		if i > 0 {
+			log.segments[i-1].next = base
+		}
+	}
+	if len(log.segments) == 0 {
+		return log.roll(0)
+	}
+	log.recover(log.segments[len(log.segments)-1])
+	return nil
+}
+
+// Get paths of segment and index files with given base offset.
+func (log *Log) paths(base uint64) (string, string) {
+	name := filepath.Join(log.dir, fmt.Sprintf("%020d", base))
+	return name + logSegmentSuffix, name + logIndexSuffix
+}
+
+// Get index file size.
+func (log *Log) indexSize() syspack.Size {
+	entries := (log.options.SegmentSize-logHeaderSize)/log.options.IndexInterval + 1
+	return entries * logIndexEntrySize
+}
+
+// Map file of given size.
+func mapLogFile(path string, size syspack.Size, flags int) (*Mapping, []byte, error) {
+	file, err := os.OpenFile(path, flags|os.O_RDWR, 0666)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	if flags&os.O_CREATE != 0 {
+		if err := file.Truncate(int64(size)); err != nil {
+			return nil, nil, err
+		}
+	} else if syspack.Size(info.Size()) != size {
+		return nil, nil, &ErrorInvalidSize{Size: syspack.Size(info.Size())}
+	}
+	mapping, err := NewMapping(file.Fd(), 0, size, &Options{Mode: ModeReadWrite})
+	if err != nil {
+		return nil, nil, err
+	}
+	data, _ := mapping.Direct(0, syspack.Offset(size))
+	return mapping, data, nil
+}
+
+// Open existing segment with given base offset.
+func (log *Log) openSegment(base uint64) (*logSegment, error) {
+	segmentPath, indexPath := log.paths(base)
+	segment := &logSegment{base: base, next: base}
+	var err error
+	if segment.mapping, segment.data, err = mapLogFile(segmentPath, log.options.SegmentSize, 0); err != nil {
+		return nil, err
+	}
+	if segment.indexMapping, segment.index, err = mapLogFile(indexPath, log.indexSize(), os.O_CREATE); err != nil {
+		segment.mapping.Close()
+		return nil, err
+	}
+	if magic := binary.LittleEndian.Uint32(segment.data[logMagicOffset:]); magic != logMagic {
+		segment.close()
+		return nil, &ErrorBadMagic{Magic: magic}
+	}
+	if version := binary.LittleEndian.Uint32(segment.data[logVersionOffset:]); version != logVersion {
+		segment.close()
+		return nil, &ErrorVersionMismatch{Version: version, Expected: logVersion}
+	}
+	if stored := binary.LittleEndian.Uint64(segment.data[logBaseOffset:]); stored != base {
+		segment.close()
+		return nil, &ErrorInvalidOffset{Offset: syspack.Offset(stored)}
+	}
+	segment.created = time.Unix(0, int64(binary.LittleEndian.Uint64(segment.data[logCreatedOffset:])))
+	for segment.entries*logIndexEntrySize < len(segment.index) &&
+		binary.LittleEndian.Uint32(segment.index[segment.entries*logIndexEntrySize+4:]) != 0 {
+		segment.entries++
+	}
+	return segment, nil
+}
+
+// Rebuild write position and index of segment from its valid records discarding torn tail.
+func (log *Log) recover(segment *logSegment) {
+	for i := range segment.index {
+		segment.index[i] = 0
+	}
+	segment.entries, segment.position, segment.next = 0, logHeaderSize, segment.base
+	for {
+		_, next, ok := segment.record(segment.position, segment.next)
+		if !ok {
+			break
+		}
+		log.indexRecord(segment)
+		segment.position = next
+		segment.next++
+	}
+	tail := segment.data[segment.position:]
+	for i := range tail {
+		tail[i] = 0
+	}
+}
+
+// Add index entry for record at write position of segment if interval is passed.
+func (log *Log) indexRecord(segment *logSegment) {
+	if segment.entries > 0 && segment.position-segment.indexed < uint64(log.options.IndexInterval) {
+		return
+	}
+	entry := segment.index[segment.entries*logIndexEntrySize:]
+	binary.LittleEndian.PutUint32(entry, uint32(segment.next-segment.base))
+	binary.LittleEndian.PutUint32(entry[4:], uint32(segment.position))
+	segment.entries++
+	segment.indexed = segment.position
+}
+
+// Sync the last segment and create new one with given base offset.
+func (log *Log) roll(base uint64) error {
+	if len(log.segments) > 0 {
+		last := log.segments[len(log.segments)-1]
+		if err := last.mapping.syncAll(); err != nil {
+			return err
+		}
+		if err := last.indexMapping.syncAll(); err != nil {
+			return err
+		}
+	}
+	segmentPath, indexPath := log.paths(base)
+	segment := &logSegment{base: base, created: time.Now(), position: logHeaderSize, next: base}
+	var err error
+	if segment.mapping, segment.data, err = mapLogFile(segmentPath, log.options.SegmentSize, os.O_CREATE|os.O_EXCL); err != nil {
+		return err
+	}
+	if segment.indexMapping, segment.index, err = mapLogFile(indexPath, log.indexSize(), os.O_CREATE|os.O_TRUNC); err != nil {
+		segment.mapping.Close()
+		os.Remove(segmentPath)
+		return err
+	}
+	if err := segment.writeHeader(); err != nil {
+		segment.close()
+		os.Remove(segmentPath)
+		os.Remove(indexPath)
+		return err
+	}
+	log.segments = append(log.segments, segment)
+	return syncDir(log.dir)
+}
+
+// Write and sync header of new segment.
+// Segment without durable header fails to open after power loss.
+func (segment *logSegment) writeHeader() error {
+	binary.LittleEndian.PutUint32(segment.data[logVersionOffset:], logVersion)
+	binary.LittleEndian.PutUint64(segment.data[logBaseOffset:], segment.base)
+	binary.LittleEndian.PutUint64(segment.data[logCreatedOffset:], uint64(segment.created.UnixNano()))
+	binary.LittleEndian.PutUint32(segment.data[logMagicOffset:], logMagic)
+	return segment.mapping.syncAll()
+}
+
+// Get record data of given offset at given position and position of the next record.
+// Returns false if there is no valid record.
+func (segment *logSegment) record(position, offset uint64) ([]byte, uint64, bool) {
+	size := uint64(len(segment.data))
+	if position < logHeaderSize || position+logRecordHeaderSize > size {
+		return nil, 0, false
+	}
+	header := segment.data[position : position+logRecordHeaderSize]
+	length := uint64(binary.LittleEndian.Uint32(header[logLengthOffset:]))
+	start := position + logRecordHeaderSize
+	if binary.LittleEndian.Uint64(header[logRecordOffset:]) != offset || length > size-start {
+		return nil, 0, false
+	}
+	data := segment.data[start : start+length : start+length]
+	checksum := crc32.Checksum(header[logLengthOffset:logChecksumOffset], castagnoli)
+	checksum = crc32.Update(checksum, castagnoli, header[logRecordOffset:])
+	checksum = crc32.Update(checksum, castagnoli, data)
+	if checksum != binary.LittleEndian.Uint32(header[logChecksumOffset:]) {
+		return nil, 0, false
+	}
+	return data, (start + length + logRecordAlignment - 1) &^ (logRecordAlignment - 1), true
+}
+
+// Close segment mappings.
+func (segment *logSegment) close() error {
+	err := segment.mapping.Close()
+	if indexErr := segment.indexMapping.Close(); err == nil {
+		err = indexErr
+	}
+	return err
+}
+
+// Get offset of the next appended record.
+func (log *Log) NextOffset() uint64 {
+	log.mutex.RLock()
+	defer log.mutex.RUnlock()
+	if len(log.segments) == 0 {
+		return 0
+	}
+	return log.segments[len(log.segments)-1].next
+}
+
+// Get offset of the oldest record.
+func (log *Log) OldestOffset() uint64 {
+	log.mutex.RLock()
+	defer log.mutex.RUnlock()
+	if len(log.segments) == 0 {
+		return 0
+	}
+	return log.segments[0].base
+}
+
+// Append record returning its offset.
+// Segment is rolled when record does not fit in it or it is older than maximum age.
+func (log *Log) Append(record []byte) (uint64, error) {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	if len(log.segments) == 0 {
+		return 0, &ErrorClosed{}
+	}
+	size := (logRecordHeaderSize + uint64(len(record)) + logRecordAlignment - 1) &^ (logRecordAlignment - 1)
+	if size > uint64(log.options.SegmentSize)-logHeaderSize {
+		return 0, &ErrorInvalidSize{Size: syspack.Len(record)}
+	}
+	segment := log.segments[len(log.segments)-1]
+	expired := log.options.MaxAge > 0 && time.Since(segment.created) >= log.options.MaxAge
+	if segment.position+size > uint64(len(segment.data)) || (expired && segment.next > segment.base) {
+		if err := log.roll(segment.next); err != nil {
+			return 0, err
+		}
+		segment = log.segments[len(log.segments)-1]
+	}
+	header := segment.data[segment.position : segment.position+logRecordHeaderSize]
+	start := segment.position + logRecordHeaderSize
+	copy(segment.data[start:], record)
+	binary.LittleEndian.PutUint32(header[logLengthOffset:], uint32(len(record)))
+	binary.LittleEndian.PutUint64(header[logRecordOffset:], segment.next)
+	checksum := crc32.Checksum(header[logLengthOffset:logChecksumOffset], castagnoli)
+	checksum = crc32.Update(checksum, castagnoli, header[logRecordOffset:])
+	checksum = crc32.Update(checksum, castagnoli, record)
+	binary.LittleEndian.PutUint32(header[logChecksumOffset:], checksum)
+	log.indexRecord(segment)
+	offset := segment.next
+	segment.position += size
+	segment.next++
+	return offset, nil
+}
+
+// Find segment containing given offset.
+func (log *Log) find(offset uint64) (*logSegment, int) {
+	index := sort.Search(len(log.segments), func(i int) bool {
+		return log.segments[i].base > offset
+	}) - 1
+	if index < 0 || offset >= log.segments[index].next {
+		return nil, index
+	}
+	return log.segments[index], index
+}
+
+// Get position of record with given offset in segment using sparse index.
+func (segment *logSegment) locate(offset uint64) (uint64, bool) {
+	relative := uint32(offset - segment.base)
+	entry := sort.Search(segment.entries, func(i int) bool {
+		return binary.LittleEndian.Uint32(segment.index[i*logIndexEntrySize:]) > relative
+	}) - 1
+	position, current := uint64(logHeaderSize), segment.base
+	if entry >= 0 {
+		position = uint64(binary.LittleEndian.Uint32(segment.index[entry*logIndexEntrySize+4:]))
+		current += uint64(binary.LittleEndian.Uint32(segment.index[entry*logIndexEntrySize:]))
+	}
+	for ; current < offset; current++ {
+		_, next, ok := segment.record(position, current)
+		if !ok {
+			return 0, false
+		}
+		position = next
+	}
+	return position, true
+}
+
+// Read record of given offset.
+func (log *Log) Read(offset uint64) ([]byte, error) {
+	log.mutex.RLock()
+	defer log.mutex.RUnlock()
+	if len(log.segments) == 0 {
+		return nil, &ErrorClosed{}
+	}
+	segment, _ := log.find(offset)
+	if segment == nil {
+		return nil, &ErrorInvalidOffset{Offset: syspack.Offset(offset)}
+	}
+	position, ok := segment.locate(offset)
+	if !ok {
+		return nil, &ErrorInvalidOffset{Offset: syspack.Offset(offset)}
+	}
+	data, _, ok := segment.record(position, offset)
+	if !ok {
+		return nil, &ErrorInvalidOffset{Offset: syspack.Offset(offset)}
+	}
+	return data, nil
+}
+
+// Remove records with offsets not less than given one.
+func (log *Log) Truncate(offset uint64) error {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	if len(log.segments) == 0 {
+		return &ErrorClosed{}
+	}
+	last := log.segments[len(log.segments)-1]
+	if offset >= last.next {
+		return nil
+	}
+	if offset < log.segments[0].base {
+		return &ErrorInvalidOffset{Offset: syspack.Offset(offset)}
+	}
+	for len(log.segments) > 0 {
+		segment := log.segments[len(log.segments)-1]
+		if segment.base < offset || (segment.base == offset && len(log.segments) == 1) {
+			break
+		}
+		if err := segment.close(); err != nil {
+			return err
+		}
+		log.segments = log.segments[:len(log.segments)-1]
+		segmentPath, indexPath := log.paths(segment.base)
+		if err := os.Remove(segmentPath); err != nil {
+			return err
+		}
+		if err := os.Remove(indexPath); err != nil {
+			return err
+		}
+	}
+	segment := log.segments[len(log.segments)-1]
+	position, ok := segment.locate(offset)
+	if !ok {
+		return &ErrorInvalidOffset{Offset: syspack.Offset(offset)}
+	}
+	tail := segment.data[position:]
+	for i := range tail {
+		tail[i] = 0
+	}
+	log.recover(segment)
+	if err := segment.mapping.syncAll(); err != nil {
+		return err
+	}
+	return syncDir(log.dir)
+}
+
+// Sync the last segment and its index.
+// Previous segments are synced when rolled.
+func (log *Log) Sync() error {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	if len(log.segments) == 0 {
+		return &ErrorClosed{}
+	}
+	return log.sync()
+}
+
+// Sync active segment and its index.
+func (log *Log) sync() error {
+	segment := log.segments[len(log.segments)-1]
+	if err := segment.mapping.syncAll(); err != nil {
+		return err
+	}
+	return segment.indexMapping.syncAll()
+}
+
+// Close all segments.
+func (log *Log) closeAll() error {
+	var lastErr error
+	for _, segment := range log.segments {
+		if err := segment.close(); err != nil {
+			lastErr = err
+		}
+	}
+	log.segments = nil
+	return lastErr
+}
+
+// Sync and close log.
+// Log is closed even if sync fails, the first error is returned.
+func (log *Log) Close() error {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	if len(log.segments) == 0 {
+		return &ErrorClosed{}
+	}
+	err := log.sync()
+	if closeErr := log.closeAll(); err == nil {
+		err = closeErr
+	}
+	return err
+}
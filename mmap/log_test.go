@@ -0,0 +1,136 @@
+package mmap
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+var testLogDir = filepath.Join(os.TempDir(), "test.log")
+
+func TestLog(t *testing.T) {
+	os.RemoveAll(testLogDir)
+	defer os.RemoveAll(testLogDir)
+	options := &LogOptions{SegmentSize: 8192, IndexInterval: 512}
+	log, err := OpenLog(testLogDir, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	const count = 300
+	for i := 0; i < count; i++ {
+		offset, err := log.Append(makeTestMessage(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if offset != uint64(i) {
+			t.Fatalf("offset must be a %d, %d found", i, offset)
+		}
+	}
+	if len(log.segments) < 2 {
+		t.Fatalf("log must be rolled, %d segments found", len(log.segments))
+	}
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if log, err = OpenLog(testLogDir, options); err != nil {
+		t.Fatal(err)
+	}
+	if log.NextOffset() != count {
+		t.Fatalf("next offset must be a %d, %d found", count, log.NextOffset())
+	}
+	for i := count - 1; i >= 0; i-- {
+		record, err := log.Read(uint64(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Compare(record, makeTestMessage(i)) != 0 {
+			t.Fatalf("record %d must be read", i)
+		}
+	}
+	if _, err := log.Read(count); err == nil {
+		t.Fatal("read after end must fail")
+	}
+	const truncated = 100
+	if err := log.Truncate(truncated); err != nil {
+		t.Fatal(err)
+	}
+	if log.NextOffset() != truncated {
+		t.Fatalf("next offset must be a %d, %d found", truncated, log.NextOffset())
+	}
+	if _, err := log.Read(truncated); err == nil {
+		t.Fatal("read of truncated record must fail")
+	}
+	if offset, err := log.Append(testBuffer); err != nil || offset != truncated {
+		t.Fatalf("offset must be a %d, %d %v found", truncated, offset, err)
+	}
+	if record, err := log.Read(truncated); err != nil || bytes.Compare(record, testBuffer) != 0 {
+		t.Fatalf("appended record must be read, %v found", err)
+	}
+	if record, err := log.Read(truncated - 1); err != nil || bytes.Compare(record, makeTestMessage(truncated-1)) != 0 {
+		t.Fatalf("record before truncation must be kept, %v found", err)
+	}
+}
+
+func TestLogMaxAge(t *testing.T) {
+	os.RemoveAll(testLogDir)
+	defer os.RemoveAll(testLogDir)
+	log, err := OpenLog(testLogDir, &LogOptions{SegmentSize: 8192, MaxAge: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	for i := 0; i < 3; i++ {
+		if _, err := log.Append(testBuffer); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	if len(log.segments) != 3 {
+		t.Fatalf("every append must roll expired segment, %d segments found", len(log.segments))
+	}
+}
+
+func TestLogSegmentHeaderCrash(t *testing.T) {
+	os.RemoveAll(testLogDir)
+	defer os.RemoveAll(testLogDir)
+	if err := os.MkdirAll(testLogDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	options := &LogOptions{SegmentSize: 8192, IndexInterval: 512}
+	log := &Log{dir: testLogDir, options: *options}
+	segmentPath, _ := log.paths(0)
+	segment := &logSegment{created: time.Now(), position: logHeaderSize}
+	var err error
+	if segment.mapping, segment.data, err = mapLogFile(segmentPath, options.SegmentSize, os.O_CREATE|os.O_EXCL); err != nil {
+		t.Fatal(err)
+	}
+	defer segment.mapping.Close()
+	simulator, err := NewCrashSimulator(segment.mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer simulator.Close()
+	if err := segment.writeHeader(); err != nil {
+		t.Fatal(err)
+	}
+	image, err := simulator.CrashImage(func(int, syspack.Offset) bool { return false })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(segmentPath, image, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if log, err = OpenLog(testLogDir, options); err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	if log.NextOffset() != 0 {
+		t.Fatalf("next offset must be a 0, %d found", log.NextOffset())
+	}
+}
@@ -0,0 +1,489 @@
+package mmap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+// Metrics format.
+const (
+	metricsMagic   = 0x5254454d // "METR"
+	metricsVersion = 1
+)
+
+// Metrics header layout.
+// Entries below registered count are immutable except their values.
+const (
+	metricsMagicOffset    = 0
+	metricsVersionOffset  = 4
+	metricsCapacityOffset = 8
+	metricsCountOffset    = 12
+	metricsLockOffset     = 16
+	metricsHeaderSize     = cacheLineSize
+)
+
+// Metric entry layout.
+// Histogram bucket i counts observations not greater than bound i, the last bucket counts the rest.
+const (
+	metricKindOffset    = 0
+	metricNameLenOffset = 4
+	metricBoundsLen     = 8
+	metricNameOffset    = 16
+	metricValueOffset   = 80
+	metricSumOffset     = 88
+	metricBoundsOffset  = 96
+	metricBucketsOffset = 256
+	metricEntrySize     = 512
+	MaxMetricNameLength = metricValueOffset - metricNameOffset
+	MaxHistogramBounds  = (metricBucketsOffset - metricBoundsOffset) / 8
+	metricsSuffix       = ".metrics"
+	metricsTempSuffix   = ".tmp"
+)
+
+// Metric kind.
+type MetricKind uint32
+
+// Available metric kinds.
+const (
+	MetricCounter MetricKind = iota + 1
+	MetricGauge
+	MetricHistogram
+)
+
+type Metrics struct {
+	// Named metrics in mapped file.
+	// File may be private to process or shared by several ones,
+	// registration is serialized by lock in file and values are updated atomically.
+
+	// Mapping.
+	mapping *Mapping
+
+	// Data.
+	data []byte
+
+	// Number of registered entries.
+	count *uint32
+
+	// Registration lock.
+	mutex sharedMutex
+}
+
+type Counter struct {
+	// Monotonic counter.
+
+	// Value.
+	value *uint64
+}
+
+type Gauge struct {
+	// Gauge holding signed value.
+
+	// Value.
+	value *uint64
+}
+
+type Histogram struct {
+	// Histogram of observations.
+
+	// Bucket bounds.
+	bounds []float64
+
+	// Sum of observations as float bits.
+	sum *uint64
+
+	// Bucket counters.
+	buckets []*uint64
+}
+
+type MetricValue struct {
+	// Metric value aggregated over files.
+
+	// Name.
+	Name string
+
+	// Kind.
+	Kind MetricKind
+
+	// Sum of counter or gauge values.
+	Value float64
+
+	// Histogram bucket bounds.
+	Bounds []float64
+
+	// Histogram bucket counts, the last one counts observations above all bounds.
+	Buckets []uint64
+
+	// Histogram observation count.
+	Count uint64
+
+	// Histogram observation sum.
+	Sum float64
+}
+
+// Open metrics file creating it with room for given number of metrics if necessary.
+func OpenMetrics(path string, capacity int) (*Metrics, error) {
+	if capacity <= 0 || capacity > (syspack.MaxInt-metricsHeaderSize)/metricEntrySize || capacity > math.MaxUint32 {
+		return nil, &ErrorInvalidSize{Size: syspack.Size(capacity)}
+	}
+	metrics, err := openMetrics(path, ModeReadWrite)
+	if !os.IsNotExist(err) {
+		return metrics, err
+	}
+	if err := createMetrics(path, capacity); err != nil && !os.IsExist(err) {
+		return nil, err
+	}
+	return openMetrics(path, ModeReadWrite)
+}
+
+// Open metrics file of current process in given directory.
+// File left by previous process with the same identifier is replaced.
+func OpenProcessMetrics(dir string, capacity int) (*Metrics, error) {
+	path := filepath.Join(dir, strconv.Itoa(os.Getpid())+metricsSuffix)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return OpenMetrics(path, capacity)
+}
+
+// Create metrics file publishing it atomically, so concurrent openers never see it half-initialized.
+func createMetrics(path string, capacity int) error {
+	tempPath := fmt.Sprintf("%s.%d%s", path, os.Getpid(), metricsTempSuffix)
+	file, err := os.OpenFile(tempPath, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0666)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempPath)
+	header := make([]byte, metricsHeaderSize)
+	binary.LittleEndian.PutUint32(header[metricsMagicOffset:], metricsMagic)
+	binary.LittleEndian.PutUint32(header[metricsVersionOffset:], metricsVersion)
+	binary.LittleEndian.PutUint32(header[metricsCapacityOffset:], uint32(capacity))
+	_, err = file.Write(header)
+	if err == nil {
+		err = file.Truncate(metricsHeaderSize + int64(capacity)*metricEntrySize)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Link(tempPath, path)
+}
+
+// Open existing metrics file in given mode.
+func openMetrics(path string, mode Mode) (*Metrics, error) {
+	flag := os.O_RDWR
+	if mode == ModeReadOnly {
+		flag = os.O_RDONLY
+	}
+	file, err := os.OpenFile(path, flag, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < metricsHeaderSize || info.Size() > int64(syspack.MaxInt) {
+		return nil, &ErrorInvalidSize{Size: syspack.Size(info.Size())}
+	}
+	mapping, err := NewMapping(file.Fd(), 0, syspack.Size(info.Size()), &Options{Mode: mode})
+	if err != nil {
+		return nil, err
+	}
+	data, _ := mapping.Direct(0, syspack.Offset(mapping.Len()))
+	if magic := binary.LittleEndian.Uint32(data[metricsMagicOffset:]); magic != metricsMagic {
+		mapping.Close()
+		return nil, &ErrorBadMagic{Magic: magic}
+	}
+	if version := binary.LittleEndian.Uint32(data[metricsVersionOffset:]); version != metricsVersion {
+		mapping.Close()
+		return nil, &ErrorVersionMismatch{Version: version, Expected: metricsVersion}
+	}
+	capacity := int64(binary.LittleEndian.Uint32(data[metricsCapacityOffset:]))
+	if info.Size() != metricsHeaderSize+capacity*metricEntrySize {
+		mapping.Close()
+		return nil, &ErrorInvalidSize{Size: syspack.Size(info.Size())}
+	}
+	count, _ := mapping.word32(metricsCountOffset)
+	lock, _ := mapping.word32(metricsLockOffset)
+	return &Metrics{mapping: mapping, data: data, count: count, mutex: sharedMutex{state: lock}}, nil
+}
+
+// Get number of registered metrics.
+func (metrics *Metrics) Len() int {
+	return int(atomic.LoadUint32(metrics.count))
+}
+
+// Get entry of given index.
+func (metrics *Metrics) entry(index int) []byte {
+	start := metricsHeaderSize + index*metricEntrySize
+	return metrics.data[start : start+metricEntrySize]
+}
+
+// Get pointer to 64-bit word at given offset of entry.
+func (metrics *Metrics) word(index, offset int) *uint64 {
+	word, _ := metrics.mapping.word64(syspack.Offset(metricsHeaderSize + index*metricEntrySize + offset))
+	return word
+}
+
+// Get name of entry.
+func metricName(entry []byte) string {
+	length := binary.LittleEndian.Uint32(entry[metricNameLenOffset:])
+	if length > MaxMetricNameLength {
+		length = MaxMetricNameLength
+	}
+	return string(entry[metricNameOffset : metricNameOffset+length])
+}
+
+// Get bucket bounds of entry.
+func metricBounds(entry []byte) []float64 {
+	count := binary.LittleEndian.Uint32(entry[metricBoundsLen:])
+	if count > MaxHistogramBounds {
+		count = MaxHistogramBounds
+	}
+	bounds := make([]float64, count)
+	for i := range bounds {
+		bounds[i] = math.Float64frombits(binary.LittleEndian.Uint64(entry[metricBoundsOffset+8*i:]))
+	}
+	return bounds
+}
+
+// Find or register metric returning its entry index.
+func (metrics *Metrics) register(name string, kind MetricKind, bounds []float64) (int, error) {
+	if metrics.data == nil {
+		return 0, &ErrorClosed{}
+	}
+	if !metrics.mapping.canWrite {
+		return 0, &ErrorNotAllowed{Operation: "registration"}
+	}
+	if len(name) == 0 || len(name) > MaxMetricNameLength {
+		return 0, &ErrorInvalidSize{Size: syspack.Size(len(name))}
+	}
+	if len(bounds) > MaxHistogramBounds || !sort.Float64sAreSorted(bounds) {
+		return 0, &ErrorInvalidSize{Size: syspack.Size(len(bounds))}
+	}
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	count := metrics.Len()
+	for i := 0; i < count; i++ {
+		entry := metrics.entry(i)
+		if metricName(entry) != name {
+			continue
+		}
+		if MetricKind(binary.LittleEndian.Uint32(entry[metricKindOffset:])) != kind {
+			return 0, &ErrorNotAllowed{Operation: fmt.Sprintf("registration of %q with another kind", name)}
+		}
+		return i, nil
+	}
+	if count >= int(binary.LittleEndian.Uint32(metrics.data[metricsCapacityOffset:])) {
+		return 0, &ErrorNoSpace{Size: metricEntrySize}
+	}
+	entry := metrics.entry(count)
+	binary.LittleEndian.PutUint32(entry[metricKindOffset:], uint32(kind))
+	binary.LittleEndian.PutUint32(entry[metricNameLenOffset:], uint32(len(name)))
+	binary.LittleEndian.PutUint32(entry[metricBoundsLen:], uint32(len(bounds)))
+	copy(entry[metricNameOffset:], name)
+	for i, bound := range bounds {
+		binary.LittleEndian.PutUint64(entry[metricBoundsOffset+8*i:], math.Float64bits(bound))
+	}
+	atomic.StoreUint32(metrics.count, uint32(count+1))
+	return count, nil
+}
+
+// Get counter of given name registering it if necessary.
+func (metrics *Metrics) Counter(name string) (*Counter, error) {
+	index, err := metrics.register(name, MetricCounter, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Counter{value: metrics.word(index, metricValueOffset)}, nil
+}
+
+// Get gauge of given name registering it if necessary.
+func (metrics *Metrics) Gauge(name string) (*Gauge, error) {
+	index, err := metrics.register(name, MetricGauge, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Gauge{value: metrics.word(index, metricValueOffset)}, nil
+}
+
+// Get histogram of given name registering it with given ascending bucket bounds if necessary.
+// Bounds of already registered histogram are kept.
+func (metrics *Metrics) Histogram(name string, bounds []float64) (*Histogram, error) {
+	index, err := metrics.register(name, MetricHistogram, bounds)
+	if err != nil {
+		return nil, err
+	}
+	histogram := &Histogram{bounds: metricBounds(metrics.entry(index)), sum: metrics.word(index, metricSumOffset)}
+	for i := 0; i <= len(histogram.bounds); i++ {
+		histogram.buckets = append(histogram.buckets, metrics.word(index, metricBucketsOffset+8*i))
+	}
+	return histogram, nil
+}
+
+// Sync metrics file.
+func (metrics *Metrics) Sync() error {
+	return metrics.mapping.syncAll()
+}
+
+// Close metrics file.
+// Counters, gauges and histograms obtained from it must not be used after.
+func (metrics *Metrics) Close() error {
+	if err := metrics.mapping.Close(); err != nil {
+		return err
+	}
+	metrics.data = nil
+	return nil
+}
+
+// Add delta to counter.
+func (counter *Counter) Add(delta uint64) {
+	atomic.AddUint64(counter.value, delta)
+}
+
+// Increment counter.
+func (counter *Counter) Inc() {
+	atomic.AddUint64(counter.value, 1)
+}
+
+// Get counter value.
+func (counter *Counter) Value() uint64 {
+	return atomic.LoadUint64(counter.value)
+}
+
+// Set gauge value.
+func (gauge *Gauge) Set(value int64) {
+	atomic.StoreUint64(gauge.value, uint64(value))
+}
+
+// Add delta to gauge.
+func (gauge *Gauge) Add(delta int64) {
+	atomic.AddUint64(gauge.value, uint64(delta))
+}
+
+// Get gauge value.
+func (gauge *Gauge) Value() int64 {
+	return int64(atomic.LoadUint64(gauge.value))
+}
+
+// Get bucket bounds.
+func (histogram *Histogram) Bounds() []float64 {
+	return histogram.bounds
+}
+
+// Observe value.
+func (histogram *Histogram) Observe(value float64) {
+	atomic.AddUint64(histogram.buckets[sort.SearchFloat64s(histogram.bounds, value)], 1)
+	for {
+		old := atomic.LoadUint64(histogram.sum)
+		if atomic.CompareAndSwapUint64(histogram.sum, old, math.Float64bits(math.Float64frombits(old)+value)) {
+			return
+		}
+	}
+}
+
+// Read and aggregate metrics of all metrics files in given directory.
+// Values of metrics with the same name and kind are summed,
+// histograms with bounds different from the first seen ones are skipped.
+// Every value is read atomically, histogram count is the sum of its buckets.
+func ReadMetrics(dir string) ([]MetricValue, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+metricsSuffix))
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]*MetricValue)
+	for _, path := range paths {
+		metrics, err := openMetrics(path, ModeReadOnly)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		metrics.aggregate(values)
+		metrics.Close()
+	}
+	result := make([]MetricValue, 0, len(values))
+	for _, value := range values {
+		result = append(result, *value)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+// Add metrics values to aggregated ones.
+func (metrics *Metrics) aggregate(values map[string]*MetricValue) {
+	count := metrics.Len()
+	for i := 0; i < count; i++ {
+		entry := metrics.entry(i)
+		name := metricName(entry)
+		kind := MetricKind(binary.LittleEndian.Uint32(entry[metricKindOffset:]))
+		key := fmt.Sprintf("%d:%s", kind, name)
+		value, ok := values[key]
+		if !ok {
+			value = &MetricValue{Name: name, Kind: kind}
+			if kind == MetricHistogram {
+				value.Bounds = metricBounds(entry)
+				value.Buckets = make([]uint64, len(value.Bounds)+1)
+			}
+			values[key] = value
+		}
+		switch kind {
+		case MetricCounter:
+			value.Value += float64(atomic.LoadUint64(metrics.word(i, metricValueOffset)))
+		case MetricGauge:
+			value.Value += float64(int64(atomic.LoadUint64(metrics.word(i, metricValueOffset))))
+		case MetricHistogram:
+			bounds := metricBounds(entry)
+			if len(bounds) != len(value.Bounds) {
+				continue
+			}
+			same := true
+			for j := range bounds {
+				same = same && bounds[j] == value.Bounds[j]
+			}
+			if !same {
+				continue
+			}
+			for j := range value.Buckets {
+				bucket := atomic.LoadUint64(metrics.word(i, metricBucketsOffset+8*j))
+				value.Buckets[j] += bucket
+				value.Count += bucket
+			}
+			value.Sum += math.Float64frombits(atomic.LoadUint64(metrics.word(i, metricSumOffset)))
+		}
+	}
+}
+
+// Remove metrics files of processes which no longer exist from given directory.
+// Only files named by process identifier are considered, returns number of removed files.
+func CleanupMetrics(dir string) (int, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+metricsSuffix))
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, path := range paths {
+		pid, err := strconv.Atoi(strings.TrimSuffix(filepath.Base(path), metricsSuffix))
+		if err != nil || pid <= 0 || processAlive(pid) {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
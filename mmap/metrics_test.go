@@ -0,0 +1,92 @@
+package mmap
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+var testMetricsDir = filepath.Join(os.TempDir(), "test.metrics.d")
+
+func TestMetrics(t *testing.T) {
+	os.RemoveAll(testMetricsDir)
+	if err := os.MkdirAll(testMetricsDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testMetricsDir)
+	own, err := OpenProcessMetrics(testMetricsDir, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer own.Close()
+	// Identifier above maximum process identifier simulates dead process.
+	deadPath := filepath.Join(testMetricsDir, "99999999"+metricsSuffix)
+	shared := make([]*Metrics, 2)
+	for i := range shared {
+		if shared[i], err = OpenMetrics(deadPath, 16); err != nil {
+			t.Fatal(err)
+		}
+		defer shared[i].Close()
+	}
+	bounds := []float64{1, 10, 100}
+	var wait sync.WaitGroup
+	for _, metrics := range []*Metrics{own, shared[0], shared[1]} {
+		counter, err := metrics.Counter("requests")
+		if err != nil {
+			t.Fatal(err)
+		}
+		gauge, err := metrics.Gauge("connections")
+		if err != nil {
+			t.Fatal(err)
+		}
+		histogram, err := metrics.Histogram("latency", bounds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for i := 0; i < 1000; i++ {
+				counter.Inc()
+				gauge.Add(1)
+				histogram.Observe(float64(i % 200))
+			}
+			gauge.Add(-500)
+		}()
+	}
+	wait.Wait()
+	if _, err := own.Gauge("requests"); err == nil {
+		t.Fatal("registration with another kind must fail")
+	}
+	if shared[0].Len() != 3 {
+		t.Fatalf("shared file must contain 3 metrics, %d found", shared[0].Len())
+	}
+	values, err := ReadMetrics(testMetricsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 3 {
+		t.Fatalf("3 metrics must be aggregated, %d found", len(values))
+	}
+	connections, latency, requests := values[0], values[1], values[2]
+	if requests.Name != "requests" || requests.Value != 3000 {
+		t.Fatalf("requests must be a 3000, %v found", requests.Value)
+	}
+	if connections.Name != "connections" || connections.Value != 1500 {
+		t.Fatalf("connections must be a 1500, %v found", connections.Value)
+	}
+	if latency.Count != 3000 || latency.Buckets[0] != 30 || latency.Buckets[3] != 1485 {
+		t.Fatalf("latency histogram must be aggregated, %d %v found", latency.Count, latency.Buckets)
+	}
+	removed, err := CleanupMetrics(testMetricsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Fatalf("1 stale file must be removed, %d found", removed)
+	}
+	if _, err := os.Stat(deadPath); !os.IsNotExist(err) {
+		t.Fatal("stale file must be removed")
+	}
+}
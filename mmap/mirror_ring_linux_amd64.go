@@ -0,0 +1,196 @@
+package mmap
+
+import (
+	"io"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+type MirrorRing struct {
+	// Ring buffer mapped twice into adjacent address ranges.
+	// Any range of up to capacity bytes starting at any offset is contiguous in memory.
+	// Exactly one goroutine may produce and exactly one goroutine may consume.
+
+	// Reserved address.
+	address uintptr
+
+	// Data mapped twice.
+	data []byte
+
+	// Ring capacity.
+	capacity uint64
+
+	// Consumer position.
+	head uint64
+
+	// Producer position.
+	tail uint64
+}
+
+// Make new mirrored ring buffer.
+// Capacity must be a multiple of page size.
+func NewMirrorRing(capacity syspack.Size) (*MirrorRing, error) {
+	pageSize := syspack.Size(os.Getpagesize())
+	if capacity == 0 || capacity%pageSize != 0 || capacity > syspack.Size(syspack.MaxInt)/2 {
+		return nil, &ErrorInvalidSize{Size: capacity}
+	}
+	fd, err := syspack.MemfdCreateE("mmap-mirror-ring", syspack.MfdCloexec)
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.Close(int(fd))
+	if err := syscall.Ftruncate(int(fd), int64(capacity)); err != nil {
+		return nil, os.NewSyscallError("ftruncate", err)
+	}
+	address, err := syspack.MmapE(
+		0, 2*capacity,
+		syscall.PROT_NONE, syscall.MAP_PRIVATE|syscall.MAP_ANONYMOUS,
+		^uintptr(0), 0,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for i := syspack.Size(0); i < 2; i++ {
+		_, err := syspack.MmapE(
+			address+i*capacity, capacity,
+			syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_FIXED,
+			fd, 0,
+		)
+		if err != nil {
+			syspack.Munmap(address, 2*capacity)
+			return nil, err
+		}
+	}
+	ring := &MirrorRing{
+		address:  address,
+		data:     makeSlice(address, int(2*capacity)),
+		capacity: uint64(capacity),
+	}
+	runtime.SetFinalizer(ring, (*MirrorRing).Close)
+	return ring, nil
+}
+
+// Get ring capacity.
+func (ring *MirrorRing) Capacity() syspack.Size {
+	return syspack.Size(ring.capacity)
+}
+
+// Get number of bytes available for reading.
+func (ring *MirrorRing) Len() int {
+	return int(atomic.LoadUint64(&ring.tail) - atomic.LoadUint64(&ring.head))
+}
+
+// Get number of bytes available for writing.
+func (ring *MirrorRing) Free() int {
+	return int(ring.capacity) - ring.Len()
+}
+
+// Get contiguous slice of given length starting at given offset modulo capacity.
+func (ring *MirrorRing) Slice(offset syspack.Offset, length syspack.Size) ([]byte, error) {
+	if ring.data == nil {
+		return nil, &ErrorClosed{}
+	}
+	if offset < 0 {
+		return nil, &ErrorInvalidOffset{Offset: offset}
+	}
+	if length > syspack.Size(ring.capacity) {
+		return nil, &ErrorInvalidSize{Size: length}
+	}
+	start := uint64(offset) % ring.capacity
+	return ring.data[start : start+uint64(length)], nil
+}
+
+// Get contiguous slice of readable bytes.
+func (ring *MirrorRing) ReadSlice() []byte {
+	if ring.data == nil {
+		return nil
+	}
+	head := atomic.LoadUint64(&ring.head)
+	tail := atomic.LoadUint64(&ring.tail)
+	start := head % ring.capacity
+	return ring.data[start : start+tail-head]
+}
+
+// Consume n bytes previously obtained by ReadSlice.
+func (ring *MirrorRing) Consume(n int) error {
+	if ring.data == nil {
+		return &ErrorClosed{}
+	}
+	if n < 0 || n > ring.Len() {
+		return &ErrorInvalidSize{Size: syspack.Size(n)}
+	}
+	atomic.AddUint64(&ring.head, uint64(n))
+	return nil
+}
+
+// Get contiguous slice of writable bytes.
+func (ring *MirrorRing) WriteSlice() []byte {
+	if ring.data == nil {
+		return nil
+	}
+	head := atomic.LoadUint64(&ring.head)
+	tail := atomic.LoadUint64(&ring.tail)
+	start := tail % ring.capacity
+	return ring.data[start : start+ring.capacity-(tail-head)]
+}
+
+// Commit n bytes previously written to slice obtained by WriteSlice.
+func (ring *MirrorRing) Commit(n int) error {
+	if ring.data == nil {
+		return &ErrorClosed{}
+	}
+	if n < 0 || n > ring.Free() {
+		return &ErrorInvalidSize{Size: syspack.Size(n)}
+	}
+	atomic.AddUint64(&ring.tail, uint64(n))
+	return nil
+}
+
+// Read up to len(buffer) bytes from ring.
+// Returns io.EOF if ring is empty.
+func (ring *MirrorRing) Read(buffer []byte) (int, error) {
+	if ring.data == nil {
+		return 0, &ErrorClosed{}
+	}
+	if len(buffer) == 0 {
+		return 0, nil
+	}
+	n := copy(buffer, ring.ReadSlice())
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return n, ring.Consume(n)
+}
+
+// Write len(buffer) bytes to ring.
+// Returns io.ErrShortWrite if there is not enough free space.
+func (ring *MirrorRing) Write(buffer []byte) (int, error) {
+	if ring.data == nil {
+		return 0, &ErrorClosed{}
+	}
+	n := copy(ring.WriteSlice(), buffer)
+	if err := ring.Commit(n); err != nil {
+		return 0, err
+	}
+	if n < len(buffer) {
+		return n, io.ErrShortWrite
+	}
+	return n, nil
+}
+
+// Close ring.
+func (ring *MirrorRing) Close() error {
+	if ring.data == nil {
+		return &ErrorClosed{}
+	}
+	if err := syspack.MunmapE(ring.address, syspack.Size(2*ring.capacity)); err != nil {
+		return err
+	}
+	ring.data = nil
+	runtime.SetFinalizer(ring, nil)
+	return nil
+}
@@ -0,0 +1,67 @@
+package mmap
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+func TestMirrorRing(t *testing.T) {
+	capacity := syspack.Size(os.Getpagesize())
+	ring, err := NewMirrorRing(capacity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ring.Close()
+	slice, err := ring.Slice(syspack.Offset(capacity)-2, syspack.Size(len(testBuffer)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(slice, testBuffer)
+	head, err := ring.Slice(0, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Compare(head, testBuffer[2:]) != 0 {
+		t.Fatalf("buffer must be a %q, %v found", testBuffer[2:], head)
+	}
+	if err := ring.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMirrorRingIO(t *testing.T) {
+	capacity := syspack.Size(os.Getpagesize())
+	ring, err := NewMirrorRing(capacity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ring.Close()
+	var written, read bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		message := makeTestMessage(i)
+		written.Write(message)
+		if n, err := ring.Write(message); err != nil {
+			t.Fatal(err)
+		} else if n != len(message) {
+			t.Fatalf("%d bytes must be written, %d found", len(message), n)
+		}
+		if ring.Free() < 300 {
+			if _, err := io.Copy(&read, ring); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if _, err := io.Copy(&read, ring); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Compare(read.Bytes(), written.Bytes()) != 0 {
+		t.Fatal("read bytes must be equal to written ones")
+	}
+	if _, err := ring.Write(make([]byte, capacity+1)); err != io.ErrShortWrite {
+		t.Fatalf("expected io.ErrShortWrite, [%v] error found", err)
+	}
+}
@@ -2,6 +2,8 @@ package mmap
 
 import (
 	"io"
+	"os"
+	"unsafe"
 
 	"github.com/alexeymaximov/syspack"
 )
@@ -64,6 +66,18 @@ func (mapping *Mapping) Direct(low, high syspack.Offset) ([]byte, error) {
 	return mapping.data[low:high], nil
 }
 
+// Get page-aligned address and size of offset range [low, high).
+func (mapping *Mapping) alignedRange(low, high syspack.Offset) (uintptr, syspack.Size, error) {
+	if _, err := mapping.Direct(low, high); err != nil {
+		return 0, 0, err
+	}
+	pageSize := uintptr(os.Getpagesize())
+	start := uintptr(unsafe.Pointer(&mapping.data[0])) + uintptr(low)
+	end := start + uintptr(high-low)
+	start &^= pageSize - 1
+	return start, syspack.Size(end - start), nil
+}
+
 // Read single byte from mapping at given offset.
 func (mapping *Mapping) ReadByteAt(offset syspack.Offset) (byte, error) {
 	if mapping.data == nil {
@@ -87,6 +101,7 @@ func (mapping *Mapping) WriteByteAt(byte byte, offset syspack.Offset) error {
 		return &ErrorInvalidOffset{Offset: offset}
 	}
 	mapping.data[offset] = byte
+	mapping.markDirty(offset, offset+1)
 	return nil
 }
 
@@ -117,8 +132,30 @@ func (mapping *Mapping) WriteAt(buffer []byte, offset syspack.Offset) (int, erro
 		return 0, &ErrorInvalidOffset{Offset: offset}
 	}
 	n := copy(mapping.data[offset:], buffer)
+	if n > 0 {
+		mapping.markDirty(offset, offset+syspack.Offset(n))
+	}
 	if n < len(buffer) {
 		return n, io.EOF
 	}
 	return n, nil
 }
+
+// Runtime slice header.
+type sliceHeader struct {
+	data uintptr
+	len  int
+	cap  int
+}
+
+// Make byte slice of given length at given address.
+func makeSlice(address uintptr, length int) []byte {
+	header := sliceHeader{data: address, len: length, cap: length}
+	return *(*[]byte)(unsafe.Pointer(&header))
+}
+
+// Make 64-bit word slice of given length at given address.
+func makeWordSlice(address uintptr, length int) []uint64 {
+	header := sliceHeader{data: address, len: length, cap: length}
+	return *(*[]uint64)(unsafe.Pointer(&header))
+}
@@ -3,8 +3,8 @@ package mmap
 import (
 	"os"
 	"runtime"
+	"sync"
 	"syscall"
-	"unsafe"
 
 	"github.com/alexeymaximov/syspack"
 )
@@ -26,6 +26,15 @@ type Mapping struct {
 
 	// Execution is allowed.
 	canExecute bool
+
+	// Dirty page bits.
+	dirty []uint32
+
+	// Function notified about every flushed offset range.
+	syncHook syncHook
+
+	// Lock of sync hook.
+	hookMutex sync.Mutex
 }
 
 // Make new mapping.
@@ -67,15 +76,8 @@ func NewMapping(fd uintptr, offset syspack.Offset, size syspack.Size, options *O
 	if err != nil {
 		return nil, err
 	}
-	var sliceHeader struct {
-		data uintptr
-		len  int
-		cap  int
-	}
-	sliceHeader.data = mapping.alignedAddress + uintptr(innerOffset)
-	sliceHeader.len = int(size)
-	sliceHeader.cap = sliceHeader.len
-	mapping.data = *(*[]byte)(unsafe.Pointer(&sliceHeader))
+	mapping.data = makeSlice(mapping.alignedAddress+uintptr(innerOffset), int(size))
+	mapping.initDirty()
 	runtime.SetFinalizer(mapping, (*Mapping).Close)
 	return mapping, nil
 }
@@ -96,7 +98,7 @@ func (mapping *Mapping) Unlock() error {
 	return syspack.MunlockE(mapping.alignedAddress, mapping.alignedSize)
 }
 
-// Sync mapping.
+// Sync dirty ranges of mapping.
 func (mapping *Mapping) Sync() error {
 	if mapping.data == nil {
 		return &ErrorClosed{}
@@ -104,7 +106,35 @@ func (mapping *Mapping) Sync() error {
 	if !mapping.canWrite {
 		return &ErrorNotAllowed{Operation: "sync"}
 	}
-	return syspack.MsyncE(mapping.alignedAddress, mapping.alignedSize)
+	synced, err := mapping.syncDirty(func(low, high syspack.Offset) error {
+		address, size, err := mapping.alignedRange(low, high)
+		if err != nil {
+			return err
+		}
+		return syspack.MsyncE(address, size)
+	})
+	synced()
+	return err
+}
+
+// Sync mapping in offset range [low, high).
+func (mapping *Mapping) SyncRange(low, high syspack.Offset) error {
+	if mapping.data == nil {
+		return &ErrorClosed{}
+	}
+	if !mapping.canWrite {
+		return &ErrorNotAllowed{Operation: "sync"}
+	}
+	address, size, err := mapping.alignedRange(low, high)
+	if err != nil {
+		return err
+	}
+	synced := mapping.syncing(low, high)
+	if err := syspack.MsyncE(address, size); err != nil {
+		return err
+	}
+	synced()
+	return nil
 }
 
 // Close mapping.
@@ -200,3 +200,106 @@ func TestOffset(t *testing.T) {
 		t.Fatalf("buffer must be a %q, %v found", offBuffer, buffer)
 	}
 }
+
+func TestDirtySync(t *testing.T) {
+	mapping, err := makeTestMapping(ModeReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mapping.Close()
+	pageSize := syspack.Offset(os.Getpagesize())
+	if _, err := mapping.WriteAt(testBuffer, pageSize-1); err != nil {
+		t.Fatal(err)
+	}
+	if err := mapping.StoreUint64At(1, 3*pageSize); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := mapping.Direct(5*pageSize, 6*pageSize)
+	data[0] = 1
+	if err := mapping.MarkDirty(5*pageSize, 5*pageSize+1); err != nil {
+		t.Fatal(err)
+	}
+	dirty := mapping.Dirty()
+	expected := []DirtyRange{{0, 2 * pageSize}, {3 * pageSize, 4 * pageSize}, {5 * pageSize, 6 * pageSize}}
+	if len(dirty) != len(expected) {
+		t.Fatalf("dirty ranges must be a %v, %v found", expected, dirty)
+	}
+	for i := range dirty {
+		if dirty[i] != expected[i] {
+			t.Fatalf("dirty ranges must be a %v, %v found", expected, dirty)
+		}
+	}
+	if err := mapping.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if dirty := mapping.Dirty(); len(dirty) != 0 {
+		t.Fatalf("dirty ranges must be flushed, %v found", dirty)
+	}
+	if err := mapping.MarkDirty(0, syspack.Offset(testLength)+1); err == nil {
+		t.Fatal("marking out of mapping must fail")
+	}
+}
+
+func TestDirtyAtomic(t *testing.T) {
+	mapping, err := makeTestMapping(ModeReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mapping.Close()
+	pageSize := syspack.Offset(os.Getpagesize())
+	if _, err := NewRingQueue(mapping, 0, 1<<10); err != nil {
+		t.Fatal(err)
+	}
+	if err := mapping.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AttachRingQueue(mapping, 0); err != nil {
+		t.Fatal(err)
+	}
+	if swapped, err := mapping.CompareAndSwapUint64At(1, 2, pageSize); err != nil || swapped {
+		t.Fatalf("swap must fail, %v %v found", swapped, err)
+	}
+	if dirty := mapping.Dirty(); len(dirty) != 0 {
+		t.Fatalf("attachment and failed swap must not mark pages, %v found", dirty)
+	}
+	if _, err := mapping.AddUint32At(1, 2*pageSize); err != nil {
+		t.Fatal(err)
+	}
+	if dirty := mapping.Dirty(); len(dirty) != 1 || dirty[0] != (DirtyRange{2 * pageSize, 3 * pageSize}) {
+		t.Fatalf("added word page must be dirty, %v found", dirty)
+	}
+}
+
+func TestDirtySection(t *testing.T) {
+	mapping, err := makeTestMapping(ModeReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mapping.Close()
+	simulator, err := NewCrashSimulator(mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer simulator.Close()
+	pageSize := syspack.Offset(os.Getpagesize())
+	section, err := NewSection(mapping, pageSize, syspack.Size(pageSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := section.WriteAt(testBuffer, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := mapping.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if unsynced, err := simulator.Unsynced(); err != nil || len(unsynced) != 0 {
+		t.Fatalf("section write must be synced, %v %v found", unsynced, err)
+	}
+	image, err := simulator.CrashImage(func(int, syspack.Offset) bool { return false })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Compare(image[pageSize+10:pageSize+10+syspack.Offset(len(testBuffer))], testBuffer) != 0 {
+		t.Fatal("section write must reach file")
+	}
+}
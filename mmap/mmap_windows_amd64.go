@@ -3,8 +3,8 @@ package mmap
 import (
 	"os"
 	"runtime"
+	"sync"
 	"syscall"
-	"unsafe"
 
 	"github.com/alexeymaximov/syspack"
 )
@@ -35,6 +35,15 @@ type Mapping struct {
 
 	// Execution is allowed.
 	canExecute bool
+
+	// Dirty page bits.
+	dirty []uint32
+
+	// Function notified about every flushed offset range.
+	syncHook syncHook
+
+	// Lock of sync hook.
+	hookMutex sync.Mutex
 }
 
 // Make new mapping.
@@ -102,15 +111,8 @@ func NewMapping(fd uintptr, offset syspack.Offset, size syspack.Size, options *O
 	if err != nil {
 		return nil, err
 	}
-	var sliceHeader struct {
-		data uintptr
-		len  int
-		cap  int
-	}
-	sliceHeader.data = mapping.alignedAddress + uintptr(innerOffset)
-	sliceHeader.len = int(size)
-	sliceHeader.cap = sliceHeader.len
-	mapping.data = *(*[]byte)(unsafe.Pointer(&sliceHeader))
+	mapping.data = makeSlice(mapping.alignedAddress+uintptr(innerOffset), int(size))
+	mapping.initDirty()
 	runtime.SetFinalizer(mapping, (*Mapping).Close)
 	return mapping, nil
 }
@@ -148,7 +150,7 @@ func (mapping *Mapping) Unlock() error {
 	return syspack.VirtualUnlockE(mapping.alignedAddress, mapping.alignedSize)
 }
 
-// Sync mapping.
+// Sync dirty ranges of mapping.
 func (mapping *Mapping) Sync() error {
 	if mapping.data == nil {
 		return &ErrorClosed{}
@@ -156,12 +158,47 @@ func (mapping *Mapping) Sync() error {
 	if !mapping.canWrite {
 		return &ErrorNotAllowed{Operation: "sync"}
 	}
-	if err := syspack.FlushViewOfFileE(mapping.alignedAddress, mapping.alignedSize); err != nil {
+	flushed := false
+	synced, err := mapping.syncDirty(func(low, high syspack.Offset) error {
+		address, size, err := mapping.alignedRange(low, high)
+		if err != nil {
+			return err
+		}
+		flushed = true
+		return syspack.FlushViewOfFileE(address, size)
+	})
+	if err != nil {
+		return err
+	}
+	if flushed {
+		if err := syspack.FlushFileBuffersE(mapping.hFile); err != nil {
+			return err
+		}
+	}
+	synced()
+	return nil
+}
+
+// Sync mapping in offset range [low, high).
+func (mapping *Mapping) SyncRange(low, high syspack.Offset) error {
+	if mapping.data == nil {
+		return &ErrorClosed{}
+	}
+	if !mapping.canWrite {
+		return &ErrorNotAllowed{Operation: "sync"}
+	}
+	address, size, err := mapping.alignedRange(low, high)
+	if err != nil {
+		return err
+	}
+	synced := mapping.syncing(low, high)
+	if err := syspack.FlushViewOfFileE(address, size); err != nil {
 		return err
 	}
 	if err := syspack.FlushFileBuffersE(mapping.hFile); err != nil {
 		return err
 	}
+	synced()
 	return nil
 }
 
@@ -0,0 +1,37 @@
+package mmap
+
+import (
+	"sync/atomic"
+)
+
+// Mutex states.
+const (
+	mutexUnlocked = iota
+	mutexLocked
+	mutexContended
+)
+
+type sharedMutex struct {
+	// Mutual exclusion lock in shared memory.
+	// Lock held by crashed process is never released.
+
+	// Mutex state.
+	state *uint32
+}
+
+// Lock mutex.
+func (mutex sharedMutex) Lock() {
+	if atomic.CompareAndSwapUint32(mutex.state, mutexUnlocked, mutexLocked) {
+		return
+	}
+	for atomic.SwapUint32(mutex.state, mutexContended) != mutexUnlocked {
+		wait32(mutex.state, mutexContended, -1)
+	}
+}
+
+// Unlock mutex.
+func (mutex sharedMutex) Unlock() {
+	if atomic.SwapUint32(mutex.state, mutexUnlocked) == mutexContended {
+		wake32(mutex.state, 1)
+	}
+}
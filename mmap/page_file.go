@@ -0,0 +1,396 @@
+package mmap
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"sync"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+// Page file format.
+const (
+	pageFileMagic   = 0x4c464750 // "PGFL"
+	pageFileVersion = 1
+)
+
+// Page file header layout.
+// Header occupies page zero, so zero page number is never allocated.
+const (
+	pageFileMagicOffset     = 0
+	pageFileVersionOffset   = 4
+	pageFilePageSizeOffset  = 8
+	pageFilePagesOffset     = 16
+	pageFileFreeHeadOffset  = 24
+	pageFileFreeCountOffset = 32
+	pageFileHeaderSize      = cacheLineSize
+)
+
+// Page trailer layout.
+// Free page stores next free page number at the beginning of its payload.
+const (
+	pageChecksumOffset = 0
+	pageStateOffset    = 4
+	pageTrailerSize    = 8
+)
+
+// Page states.
+const (
+	pageFree = iota
+	pageAllocated
+)
+
+// Page file defaults.
+const (
+	defaultPageFilePages = 16
+)
+
+type PageFileOptions struct {
+	// Page file options.
+
+	// Page size, multiple of operating system page size, which is the default.
+	PageSize int
+
+	// Initial number of pages including header page.
+	Pages uint64
+}
+
+type PageFile struct {
+	// File managed as fixed-size pages.
+	// Every page ends with trailer holding payload checksum and allocation state.
+	// Free pages form persistent singly linked list headed in header page.
+	// File grows by doubling when there are no free pages,
+	// which remaps file and invalidates previously returned page slices.
+
+	// File.
+	file *os.File
+
+	// Mapping.
+	mapping *Mapping
+
+	// Data.
+	data []byte
+
+	// Page size.
+	pageSize int
+
+	// Pages whose checksum is to be updated on sync.
+	dirty map[uint64]struct{}
+
+	// Lock.
+	mutex sync.Mutex
+}
+
+// Create page file.
+func CreatePageFile(path string, options *PageFileOptions) (*PageFile, error) {
+	pageSize, pages := os.Getpagesize(), uint64(defaultPageFilePages)
+	if options != nil {
+		if options.PageSize != 0 {
+			pageSize = options.PageSize
+		}
+		if options.Pages != 0 {
+			pages = options.Pages
+		}
+	}
+	if pageSize <= 0 || pageSize%os.Getpagesize() != 0 {
+		return nil, &ErrorInvalidSize{Size: syspack.Size(pageSize)}
+	}
+	if pages < 2 || pages > uint64(syspack.MaxInt)/uint64(pageSize) {
+		return nil, &ErrorInvalidSize{Size: syspack.Size(pages)}
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0666)
+	if err != nil {
+		return nil, err
+	}
+	pageFile := &PageFile{file: file, pageSize: pageSize, dirty: make(map[uint64]struct{})}
+	if err := pageFile.remap(1); err != nil {
+		file.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	binary.LittleEndian.PutUint32(pageFile.data[pageFileMagicOffset:], pageFileMagic)
+	binary.LittleEndian.PutUint32(pageFile.data[pageFileVersionOffset:], pageFileVersion)
+	binary.LittleEndian.PutUint32(pageFile.data[pageFilePageSizeOffset:], uint32(pageSize))
+	binary.LittleEndian.PutUint64(pageFile.data[pageFilePagesOffset:], 1)
+	if err := pageFile.grow(pages); err != nil {
+		if pageFile.mapping != nil {
+			pageFile.mapping.Close()
+		}
+		file.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	return pageFile, nil
+}
+
+// Open existing page file.
+func OpenPageFile(path string) (*PageFile, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	pageFile, err := openPageFile(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return pageFile, nil
+}
+
+// Open page file over opened file.
+func openPageFile(file *os.File) (*PageFile, error) {
+	header := make([]byte, pageFileHeaderSize)
+	if _, err := file.ReadAt(header, 0); err != nil {
+		return nil, err
+	}
+	if magic := binary.LittleEndian.Uint32(header[pageFileMagicOffset:]); magic != pageFileMagic {
+		return nil, &ErrorBadMagic{Magic: magic}
+	}
+	if version := binary.LittleEndian.Uint32(header[pageFileVersionOffset:]); version != pageFileVersion {
+		return nil, &ErrorVersionMismatch{Version: version, Expected: pageFileVersion}
+	}
+	pageSize := int(binary.LittleEndian.Uint32(header[pageFilePageSizeOffset:]))
+	if pageSize <= 0 || pageSize%os.Getpagesize() != 0 {
+		return nil, &ErrorInvalidSize{Size: syspack.Size(pageSize)}
+	}
+	pages := binary.LittleEndian.Uint64(header[pageFilePagesOffset:])
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if pages < 1 || pages > uint64(syspack.MaxInt)/uint64(pageSize) || uint64(info.Size()) < pages*uint64(pageSize) {
+		return nil, &ErrorInvalidSize{Size: syspack.Size(info.Size())}
+	}
+	// Growth interrupted before header was written back leaves tail of pages beyond header count.
+	if uint64(info.Size()) > pages*uint64(pageSize) {
+		if err := file.Truncate(int64(pages * uint64(pageSize))); err != nil {
+			return nil, err
+		}
+	}
+	pageFile := &PageFile{file: file, pageSize: pageSize, dirty: make(map[uint64]struct{})}
+	if err := pageFile.remap(pages); err != nil {
+		return nil, err
+	}
+	return pageFile, nil
+}
+
+// Resize file to given number of pages and map it again.
+// Old mapping is kept and file size is restored if new one fails.
+func (pageFile *PageFile) remap(pages uint64) error {
+	size := syspack.Size(pages) * syspack.Size(pageFile.pageSize)
+	if err := pageFile.file.Truncate(int64(size)); err != nil {
+		return err
+	}
+	mapping, err := NewMapping(pageFile.file.Fd(), 0, size, &Options{Mode: ModeReadWrite})
+	if err != nil {
+		if pageFile.mapping != nil {
+			pageFile.file.Truncate(int64(pageFile.mapping.Len()))
+		}
+		return err
+	}
+	if pageFile.mapping != nil {
+		if err := pageFile.mapping.Close(); err != nil {
+			mapping.Close()
+			return err
+		}
+	}
+	pageFile.mapping = mapping
+	pageFile.data, _ = mapping.Direct(0, syspack.Offset(size))
+	return nil
+}
+
+// Grow file to given number of pages pushing new pages to free list.
+func (pageFile *PageFile) grow(pages uint64) error {
+	old := pageFile.Pages()
+	if err := pageFile.remap(pages); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint64(pageFile.data[pageFilePagesOffset:], pages)
+	for pgno := pages - 1; pgno >= old; pgno-- {
+		pageFile.push(pgno)
+	}
+	return nil
+}
+
+// Get page slice including trailer.
+func (pageFile *PageFile) page(pgno uint64) []byte {
+	start := pgno * uint64(pageFile.pageSize)
+	return pageFile.data[start : start+uint64(pageFile.pageSize) : start+uint64(pageFile.pageSize)]
+}
+
+// Get page trailer.
+func (pageFile *PageFile) trailer(pgno uint64) []byte {
+	return pageFile.page(pgno)[pageFile.pageSize-pageTrailerSize:]
+}
+
+// Push page to free list.
+func (pageFile *PageFile) push(pgno uint64) {
+	page := pageFile.page(pgno)
+	head := binary.LittleEndian.Uint64(pageFile.data[pageFileFreeHeadOffset:])
+	binary.LittleEndian.PutUint64(page, head)
+	binary.LittleEndian.PutUint32(pageFile.trailer(pgno)[pageStateOffset:], pageFree)
+	binary.LittleEndian.PutUint64(pageFile.data[pageFileFreeHeadOffset:], pgno)
+	binary.LittleEndian.PutUint64(
+		pageFile.data[pageFileFreeCountOffset:],
+		binary.LittleEndian.Uint64(pageFile.data[pageFileFreeCountOffset:])+1,
+	)
+}
+
+// Check whether page of given number is allocated.
+func (pageFile *PageFile) check(pgno uint64) error {
+	if pageFile.data == nil {
+		return &ErrorClosed{}
+	}
+	if pgno == 0 || pgno >= pageFile.Pages() ||
+		binary.LittleEndian.Uint32(pageFile.trailer(pgno)[pageStateOffset:]) != pageAllocated {
+		return &ErrorInvalidOffset{Offset: syspack.Offset(pgno) * syspack.Offset(pageFile.pageSize)}
+	}
+	return nil
+}
+
+// Get page size including trailer.
+func (pageFile *PageFile) PageSize() int {
+	return pageFile.pageSize
+}
+
+// Get usable page size.
+func (pageFile *PageFile) PayloadSize() int {
+	return pageFile.pageSize - pageTrailerSize
+}
+
+// Get total number of pages including header page.
+func (pageFile *PageFile) Pages() uint64 {
+	if pageFile.data == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(pageFile.data[pageFilePagesOffset:])
+}
+
+// Get number of free pages.
+func (pageFile *PageFile) FreePages() uint64 {
+	if pageFile.data == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(pageFile.data[pageFileFreeCountOffset:])
+}
+
+// Allocate zeroed page returning its number.
+func (pageFile *PageFile) Alloc() (uint64, error) {
+	pageFile.mutex.Lock()
+	defer pageFile.mutex.Unlock()
+	if pageFile.data == nil {
+		return 0, &ErrorClosed{}
+	}
+	if pageFile.FreePages() == 0 {
+		pages := pageFile.Pages() * 2
+		if pages > uint64(syspack.MaxInt)/uint64(pageFile.pageSize) {
+			return 0, &ErrorNoSpace{Size: syspack.Size(pageFile.pageSize)}
+		}
+		if err := pageFile.grow(pages); err != nil {
+			return 0, err
+		}
+	}
+	pgno := binary.LittleEndian.Uint64(pageFile.data[pageFileFreeHeadOffset:])
+	page := pageFile.page(pgno)
+	binary.LittleEndian.PutUint64(pageFile.data[pageFileFreeHeadOffset:], binary.LittleEndian.Uint64(page))
+	binary.LittleEndian.PutUint64(pageFile.data[pageFileFreeCountOffset:], pageFile.FreePages()-1)
+	for i := range page {
+		page[i] = 0
+	}
+	binary.LittleEndian.PutUint32(pageFile.trailer(pgno)[pageStateOffset:], pageAllocated)
+	pageFile.dirty[pgno] = struct{}{}
+	return pgno, nil
+}
+
+// Free page of given number.
+func (pageFile *PageFile) Free(pgno uint64) error {
+	pageFile.mutex.Lock()
+	defer pageFile.mutex.Unlock()
+	if err := pageFile.check(pgno); err != nil {
+		return err
+	}
+	delete(pageFile.dirty, pgno)
+	pageFile.push(pgno)
+	return nil
+}
+
+// Get payload of allocated page.
+// Page is considered modified and its checksum is updated on sync.
+// Slice is valid until file grows or is closed.
+func (pageFile *PageFile) Page(pgno uint64) ([]byte, error) {
+	pageFile.mutex.Lock()
+	defer pageFile.mutex.Unlock()
+	if err := pageFile.check(pgno); err != nil {
+		return nil, err
+	}
+	pageFile.dirty[pgno] = struct{}{}
+	return pageFile.page(pgno)[:pageFile.PayloadSize()], nil
+}
+
+// Update checksum of page.
+func (pageFile *PageFile) seal(pgno uint64) {
+	page := pageFile.page(pgno)
+	binary.LittleEndian.PutUint32(
+		pageFile.trailer(pgno)[pageChecksumOffset:],
+		crc32.Checksum(page[:pageFile.PayloadSize()], castagnoli),
+	)
+	delete(pageFile.dirty, pgno)
+}
+
+// Verify checksum of allocated page.
+// Page modified since last sync is not verified.
+func (pageFile *PageFile) Verify(pgno uint64) error {
+	pageFile.mutex.Lock()
+	defer pageFile.mutex.Unlock()
+	if err := pageFile.check(pgno); err != nil {
+		return err
+	}
+	if _, ok := pageFile.dirty[pgno]; ok {
+		return nil
+	}
+	checksum := crc32.Checksum(pageFile.page(pgno)[:pageFile.PayloadSize()], castagnoli)
+	if expected := binary.LittleEndian.Uint32(pageFile.trailer(pgno)[pageChecksumOffset:]); checksum != expected {
+		return &ErrorChecksum{Checksum: checksum, Expected: expected}
+	}
+	return nil
+}
+
+// Update checksum of page and sync it.
+func (pageFile *PageFile) SyncPage(pgno uint64) error {
+	pageFile.mutex.Lock()
+	defer pageFile.mutex.Unlock()
+	if err := pageFile.check(pgno); err != nil {
+		return err
+	}
+	pageFile.seal(pgno)
+	low := syspack.Offset(pgno) * syspack.Offset(pageFile.pageSize)
+	return pageFile.mapping.SyncRange(low, low+syspack.Offset(pageFile.pageSize))
+}
+
+// Update checksums of modified pages and sync whole file.
+func (pageFile *PageFile) Sync() error {
+	pageFile.mutex.Lock()
+	defer pageFile.mutex.Unlock()
+	if pageFile.data == nil {
+		return &ErrorClosed{}
+	}
+	for pgno := range pageFile.dirty {
+		pageFile.seal(pgno)
+	}
+	return pageFile.mapping.syncAll()
+}
+
+// Sync and close page file.
+func (pageFile *PageFile) Close() error {
+	if err := pageFile.Sync(); err != nil {
+		return err
+	}
+	pageFile.mutex.Lock()
+	defer pageFile.mutex.Unlock()
+	if err := pageFile.mapping.Close(); err != nil {
+		return err
+	}
+	pageFile.data = nil
+	return pageFile.file.Close()
+}
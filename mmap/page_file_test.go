@@ -0,0 +1,124 @@
+package mmap
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testPageFilePath = filepath.Join(os.TempDir(), "test.pages")
+
+func TestPageFile(t *testing.T) {
+	os.Remove(testPageFilePath)
+	defer os.Remove(testPageFilePath)
+	pageFile, err := CreatePageFile(testPageFilePath, &PageFileOptions{Pages: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pageFile.Close()
+	var pages []uint64
+	for i := 0; i < 10; i++ {
+		pgno, err := pageFile.Alloc()
+		if err != nil {
+			t.Fatal(err)
+		}
+		page, err := pageFile.Page(pgno)
+		if err != nil {
+			t.Fatal(err)
+		}
+		copy(page, makeTestMessage(i+1))
+		pages = append(pages, pgno)
+	}
+	if pageFile.Pages() != 16 {
+		t.Fatalf("file must grow to 16 pages, %d found", pageFile.Pages())
+	}
+	if err := pageFile.SyncPage(pages[0]); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(pages); i += 2 {
+		if err := pageFile.Free(pages[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pageFile.Free(pages[1]); err == nil {
+		t.Fatal("double free must fail")
+	}
+	if err := pageFile.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if pageFile, err = OpenPageFile(testPageFilePath); err != nil {
+		t.Fatal(err)
+	}
+	if pageFile.FreePages() != 10 {
+		t.Fatalf("10 pages must be free, %d found", pageFile.FreePages())
+	}
+	for i := 0; i < len(pages); i += 2 {
+		if err := pageFile.Verify(pages[i]); err != nil {
+			t.Fatal(err)
+		}
+		page, err := pageFile.Page(pages[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := makeTestMessage(i + 1)
+		if bytes.Compare(page[:len(expected)], expected) != 0 {
+			t.Fatalf("page %d must contain message %d", pages[i], i+1)
+		}
+	}
+	pgno, err := pageFile.Alloc()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pgno != pages[9] {
+		t.Fatalf("last freed page %d must be reused, %d found", pages[9], pgno)
+	}
+	if err := pageFile.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.OpenFile(testPageFilePath, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteAt([]byte{0xff}, int64(pages[0])*int64(pageFile.PageSize())); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	if pageFile, err = OpenPageFile(testPageFilePath); err != nil {
+		t.Fatal(err)
+	}
+	if err := pageFile.Verify(pages[0]); err == nil {
+		t.Fatal("corrupted page must fail verification")
+	}
+}
+
+func TestPageFileInterruptedGrowth(t *testing.T) {
+	os.Remove(testPageFilePath)
+	defer os.Remove(testPageFilePath)
+	pageFile, err := CreatePageFile(testPageFilePath, &PageFileOptions{Pages: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageSize := pageFile.PageSize()
+	if err := pageFile.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Extend file as growth does before header is updated.
+	if err := os.Truncate(testPageFilePath, int64(8*pageSize)); err != nil {
+		t.Fatal(err)
+	}
+	if pageFile, err = OpenPageFile(testPageFilePath); err != nil {
+		t.Fatal(err)
+	}
+	if pageFile.Pages() != 4 || pageFile.FreePages() != 3 {
+		t.Fatalf("file must have 4 pages with 3 free, %d and %d found", pageFile.Pages(), pageFile.FreePages())
+	}
+	if err := pageFile.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(testPageFilePath); err != nil {
+		t.Fatal(err)
+	} else if info.Size() != int64(4*pageSize) {
+		t.Fatalf("tail beyond header pages must be truncated, size %d found", info.Size())
+	}
+}
@@ -0,0 +1,11 @@
+package mmap
+
+import (
+	"syscall"
+)
+
+// Check whether process with given identifier exists.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
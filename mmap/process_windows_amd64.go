@@ -0,0 +1,16 @@
+package mmap
+
+import (
+	"os"
+)
+
+// Check whether process with given identifier exists.
+// Process handle can be opened only while process exists.
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	process.Release()
+	return true
+}
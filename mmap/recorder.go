@@ -0,0 +1,267 @@
+package mmap
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+// Flight recorder format.
+const (
+	recorderMagic   = 0x43455246 // "FREC"
+	recorderVersion = 1
+)
+
+// Flight recorder header layout.
+// Positions are monotonic, data in [tail, head) holds complete events.
+const (
+	recorderMagicOffset    = 0
+	recorderVersionOffset  = 4
+	recorderCapacityOffset = 8
+	recorderHeadOffset     = 16
+	recorderTailOffset     = 24
+	recorderSequenceOffset = 32
+	recorderHeaderSize     = cacheLineSize
+)
+
+// Flight recorder event layout.
+// Event with zero sequence is padding up to the end of ring,
+// space at the end of ring shorter than event header is skipped.
+const (
+	recorderSizeOffset       = 0
+	recorderKindOffset       = 4
+	recorderEventSeqOffset   = 8
+	recorderTimeOffset       = 16
+	recorderChecksumOffset   = 24
+	recorderLengthOffset     = 28
+	recorderEventHeaderSize  = 32
+	recorderEventAlignment   = 8
+	minRecorderCapacity      = 4096
+	recorderMaxEventFraction = 4
+)
+
+type RecorderEvent struct {
+	// Flight recorder event.
+
+	// Sequence number starting from one.
+	Sequence uint64
+
+	// Recording time.
+	Time time.Time
+
+	// Event kind.
+	Kind uint32
+
+	// Payload.
+	Data []byte
+}
+
+type Recorder struct {
+	// Flight recorder writing events into ring in shared file mapping.
+	// Written events reside in page cache, so they survive crash of the process
+	// and can be decoded afterwards by ReadRecorder.
+	// The oldest events are overwritten when ring is full.
+
+	// Mapping.
+	mapping *Mapping
+
+	// Header.
+	header []byte
+
+	// Ring.
+	ring []byte
+
+	// Lock.
+	mutex sync.Mutex
+}
+
+// Create flight recorder file with ring of given capacity, replacing existing one.
+func CreateRecorder(path string, capacity syspack.Size) (*Recorder, error) {
+	if capacity < minRecorderCapacity || capacity%recorderEventAlignment != 0 ||
+		capacity > syspack.Size(syspack.MaxInt)-recorderHeaderSize {
+		return nil, &ErrorInvalidSize{Size: capacity}
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0666)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	size := recorderHeaderSize + capacity
+	if err := file.Truncate(int64(size)); err != nil {
+		return nil, err
+	}
+	mapping, err := NewMapping(file.Fd(), 0, size, &Options{Mode: ModeReadWrite})
+	if err != nil {
+		return nil, err
+	}
+	data, _ := mapping.Direct(0, syspack.Offset(size))
+	recorder := &Recorder{mapping: mapping, header: data[:recorderHeaderSize], ring: data[recorderHeaderSize:]}
+	binary.LittleEndian.PutUint32(recorder.header[recorderVersionOffset:], recorderVersion)
+	binary.LittleEndian.PutUint64(recorder.header[recorderCapacityOffset:], uint64(capacity))
+	binary.LittleEndian.PutUint32(recorder.header[recorderMagicOffset:], recorderMagic)
+	return recorder, nil
+}
+
+// Get size of space to skip at given ring position.
+// Returns zero if there is event at position.
+func recorderSkip(ring []byte, position uint64) uint64 {
+	rest := uint64(len(ring)) - position%uint64(len(ring))
+	if rest < recorderEventHeaderSize {
+		return rest
+	}
+	return 0
+}
+
+// Get event checksum.
+func recorderChecksum(event []byte, data []byte) uint32 {
+	checksum := crc32.Checksum(event[:recorderChecksumOffset], castagnoli)
+	checksum = crc32.Update(checksum, castagnoli, event[recorderLengthOffset:recorderEventHeaderSize])
+	return crc32.Update(checksum, castagnoli, data)
+}
+
+// Advance tail until given number of bytes after head is free.
+func (recorder *Recorder) reserve(head, size uint64) {
+	capacity := uint64(len(recorder.ring))
+	tail := binary.LittleEndian.Uint64(recorder.header[recorderTailOffset:])
+	for head+size-tail > capacity {
+		skip := recorderSkip(recorder.ring, tail)
+		if skip == 0 {
+			skip = uint64(binary.LittleEndian.Uint32(recorder.ring[tail%capacity+recorderSizeOffset:]))
+		}
+		tail += skip
+	}
+	binary.LittleEndian.PutUint64(recorder.header[recorderTailOffset:], tail)
+}
+
+// Record event of given kind.
+func (recorder *Recorder) Record(kind uint32, data []byte) error {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	if recorder.ring == nil {
+		return &ErrorClosed{}
+	}
+	capacity := uint64(len(recorder.ring))
+	size := (recorderEventHeaderSize + uint64(len(data)) + recorderEventAlignment - 1) &^ (recorderEventAlignment - 1)
+	if size > capacity/recorderMaxEventFraction {
+		return &ErrorInvalidSize{Size: syspack.Len(data)}
+	}
+	head := binary.LittleEndian.Uint64(recorder.header[recorderHeadOffset:])
+	if skip := recorderSkip(recorder.ring, head); skip != 0 {
+		head += skip
+	} else if rest := capacity - head%capacity; rest < size {
+		recorder.reserve(head, rest)
+		padding := recorder.ring[head%capacity:]
+		for i := range padding[:recorderEventHeaderSize] {
+			padding[i] = 0
+		}
+		binary.LittleEndian.PutUint32(padding[recorderSizeOffset:], uint32(rest))
+		binary.LittleEndian.PutUint32(padding[recorderChecksumOffset:], recorderChecksum(padding, nil))
+		head += rest
+	}
+	recorder.reserve(head, size)
+	sequence := binary.LittleEndian.Uint64(recorder.header[recorderSequenceOffset:]) + 1
+	event := recorder.ring[head%capacity : head%capacity+size]
+	binary.LittleEndian.PutUint32(event[recorderSizeOffset:], uint32(size))
+	binary.LittleEndian.PutUint32(event[recorderKindOffset:], kind)
+	binary.LittleEndian.PutUint64(event[recorderEventSeqOffset:], sequence)
+	binary.LittleEndian.PutUint64(event[recorderTimeOffset:], uint64(time.Now().UnixNano()))
+	binary.LittleEndian.PutUint32(event[recorderLengthOffset:], uint32(len(data)))
+	copy(event[recorderEventHeaderSize:], data)
+	binary.LittleEndian.PutUint32(event[recorderChecksumOffset:], recorderChecksum(event, data))
+	binary.LittleEndian.PutUint64(recorder.header[recorderSequenceOffset:], sequence)
+	binary.LittleEndian.PutUint64(recorder.header[recorderHeadOffset:], head+size)
+	return nil
+}
+
+// Record buffer as event of zero kind.
+// Recorder may be used as output of standard logger this way.
+func (recorder *Recorder) Write(buffer []byte) (int, error) {
+	if err := recorder.Record(0, buffer); err != nil {
+		return 0, err
+	}
+	return len(buffer), nil
+}
+
+// Sync recorder file, which is only necessary to survive crash of the system.
+func (recorder *Recorder) Sync() error {
+	return recorder.mapping.syncAll()
+}
+
+// Close recorder.
+func (recorder *Recorder) Close() error {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	if err := recorder.mapping.Close(); err != nil {
+		return err
+	}
+	recorder.header, recorder.ring = nil, nil
+	return nil
+}
+
+// Read events from flight recorder file in recording order.
+// Decoding stops at the first corrupted event, events preceding it are returned along with error.
+func ReadRecorder(path string) ([]RecorderEvent, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < recorderHeaderSize+minRecorderCapacity || info.Size() > int64(syspack.MaxInt) {
+		return nil, &ErrorInvalidSize{Size: syspack.Size(info.Size())}
+	}
+	mapping, err := NewMapping(file.Fd(), 0, syspack.Size(info.Size()), &Options{Mode: ModeReadOnly})
+	if err != nil {
+		return nil, err
+	}
+	defer mapping.Close()
+	data, _ := mapping.Direct(0, syspack.Offset(mapping.Len()))
+	header, ring := data[:recorderHeaderSize], data[recorderHeaderSize:]
+	if magic := binary.LittleEndian.Uint32(header[recorderMagicOffset:]); magic != recorderMagic {
+		return nil, &ErrorBadMagic{Magic: magic}
+	}
+	if version := binary.LittleEndian.Uint32(header[recorderVersionOffset:]); version != recorderVersion {
+		return nil, &ErrorVersionMismatch{Version: version, Expected: recorderVersion}
+	}
+	capacity := binary.LittleEndian.Uint64(header[recorderCapacityOffset:])
+	head := binary.LittleEndian.Uint64(header[recorderHeadOffset:])
+	tail := binary.LittleEndian.Uint64(header[recorderTailOffset:])
+	if capacity != uint64(len(ring)) || head < tail || head-tail > capacity {
+		return nil, &ErrorInvalidSize{Size: syspack.Size(info.Size())}
+	}
+	var events []RecorderEvent
+	for position := tail; position < head; {
+		if skip := recorderSkip(ring, position); skip != 0 {
+			position += skip
+			continue
+		}
+		event := ring[position%capacity:]
+		size := uint64(binary.LittleEndian.Uint32(event[recorderSizeOffset:]))
+		length := uint64(binary.LittleEndian.Uint32(event[recorderLengthOffset:]))
+		if size < recorderEventHeaderSize || size > uint64(len(event)) || length > size-recorderEventHeaderSize {
+			return events, &ErrorInvalidOffset{Offset: syspack.Offset(position)}
+		}
+		payload := event[recorderEventHeaderSize : recorderEventHeaderSize+length]
+		checksum := recorderChecksum(event, payload)
+		if expected := binary.LittleEndian.Uint32(event[recorderChecksumOffset:]); checksum != expected {
+			return events, &ErrorChecksum{Checksum: checksum, Expected: expected}
+		}
+		if sequence := binary.LittleEndian.Uint64(event[recorderEventSeqOffset:]); sequence != 0 {
+			events = append(events, RecorderEvent{
+				Sequence: sequence,
+				Time:     time.Unix(0, int64(binary.LittleEndian.Uint64(event[recorderTimeOffset:]))),
+				Kind:     binary.LittleEndian.Uint32(event[recorderKindOffset:]),
+				Data:     append([]byte(nil), payload...),
+			})
+		}
+		position += size
+	}
+	return events, nil
+}
@@ -0,0 +1,52 @@
+package mmap
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testRecorderPath = filepath.Join(os.TempDir(), "test.recorder")
+
+func TestRecorder(t *testing.T) {
+	defer os.Remove(testRecorderPath)
+	recorder, err := CreateRecorder(testRecorderPath, 8192)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recorder.Close()
+	const count = 1000
+	for i := 0; i < count; i++ {
+		if err := recorder.Record(uint32(i), makeTestMessage(i%100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := recorder.Write(testBuffer); err != nil {
+		t.Fatal(err)
+	}
+	// Recorder is not closed, events are read from page cache like after crash.
+	events, err := ReadRecorder(testRecorderPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) < 10 || len(events) >= count {
+		t.Fatalf("ring must keep recent events only, %d found", len(events))
+	}
+	last := events[len(events)-1]
+	if last.Sequence != count+1 || last.Kind != 0 || bytes.Compare(last.Data, testBuffer) != 0 {
+		t.Fatalf("the last event must be written one, %d %d found", last.Sequence, last.Kind)
+	}
+	for i, event := range events[:len(events)-1] {
+		if event.Sequence != last.Sequence-uint64(len(events)-1-i) {
+			t.Fatalf("events must be consecutive, %d found at %d", event.Sequence, i)
+		}
+		kind := int(event.Sequence - 1)
+		if event.Kind != uint32(kind) || bytes.Compare(event.Data, makeTestMessage(kind%100)) != 0 {
+			t.Fatalf("event %d must be decoded", event.Sequence)
+		}
+	}
+	if err := recorder.Record(0, make([]byte, 4096)); err == nil {
+		t.Fatal("oversized event must fail")
+	}
+}
@@ -0,0 +1,322 @@
+package mmap
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+// Cache line size.
+const cacheLineSize = 64
+
+// Ring queue format.
+const (
+	ringQueueMagic   = 0x43505352 // "RSPC"
+	ringQueueVersion = 1
+)
+
+// Ring queue header layout.
+// Head and tail live in separate cache lines to avoid false sharing.
+const (
+	ringQueueMagicOffset         = 0
+	ringQueueVersionOffset       = 4
+	ringQueueCapacityOffset      = 8
+	ringQueueHeadOffset          = cacheLineSize
+	ringQueueHeadSignalOffset    = cacheLineSize + 8
+	ringQueueWriterWaitingOffset = cacheLineSize + 12
+	ringQueueTailOffset          = 2 * cacheLineSize
+	ringQueueTailSignalOffset    = 2*cacheLineSize + 8
+	ringQueueReaderWaitingOffset = 2*cacheLineSize + 12
+	ringQueueHeaderSize          = 3 * cacheLineSize
+)
+
+// Ring queue record layout.
+const (
+	ringQueuePrefixSize = 4
+	ringQueueAlignment  = 8
+	ringQueueMinimum    = 2 * cacheLineSize
+	ringQueuePadding    = syspack.MaxDword
+)
+
+type RingQueue struct {
+	// Single-producer single-consumer queue of variable-length records.
+	// Exactly one goroutine may send and exactly one goroutine may receive,
+	// possibly in different processes sharing the mapping.
+
+	// Mapping.
+	mapping *Mapping
+
+	// Ring data.
+	data []byte
+
+	// Ring capacity.
+	capacity uint64
+
+	// Consumer position.
+	head *uint64
+
+	// Producer position.
+	tail *uint64
+
+	// Counter incremented on every receive.
+	headSignal *uint32
+
+	// Counter incremented on every send.
+	tailSignal *uint32
+
+	// Producer is waiting for free space.
+	writerWaiting *uint32
+
+	// Consumer is waiting for records.
+	readerWaiting *uint32
+
+	// Last consumer position seen by producer.
+	cachedHead uint64
+
+	// Last producer position seen by consumer.
+	cachedTail uint64
+}
+
+// Get mapping size required for ring queue of given capacity.
+func RingQueueSize(capacity syspack.Size) syspack.Size {
+	return ringQueueHeaderSize + capacity
+}
+
+// Make new ring queue in mapping at given offset.
+// Capacity must be a power of two.
+func NewRingQueue(mapping *Mapping, offset syspack.Offset, capacity syspack.Size) (*RingQueue, error) {
+	queue, err := openRingQueue(mapping, offset, capacity)
+	if err != nil {
+		return nil, err
+	}
+	atomic.StoreUint64(queue.head, 0)
+	atomic.StoreUint64(queue.tail, 0)
+	atomic.StoreUint32(queue.headSignal, 0)
+	atomic.StoreUint32(queue.tailSignal, 0)
+	atomic.StoreUint32(queue.writerWaiting, 0)
+	atomic.StoreUint32(queue.readerWaiting, 0)
+	mapping.markDirty(offset, offset+ringQueueHeaderSize)
+	if err := mapping.StoreUint64At(uint64(capacity), offset+ringQueueCapacityOffset); err != nil {
+		return nil, err
+	}
+	if err := mapping.StoreUint32At(ringQueueVersion, offset+ringQueueVersionOffset); err != nil {
+		return nil, err
+	}
+	if err := mapping.StoreUint32At(ringQueueMagic, offset+ringQueueMagicOffset); err != nil {
+		return nil, err
+	}
+	return queue, nil
+}
+
+// Attach to ring queue existing in mapping at given offset.
+func AttachRingQueue(mapping *Mapping, offset syspack.Offset) (*RingQueue, error) {
+	magic, err := mapping.LoadUint32At(offset + ringQueueMagicOffset)
+	if err != nil {
+		return nil, err
+	}
+	if magic != ringQueueMagic {
+		return nil, &ErrorBadMagic{Magic: magic}
+	}
+	version, err := mapping.LoadUint32At(offset + ringQueueVersionOffset)
+	if err != nil {
+		return nil, err
+	}
+	if version != ringQueueVersion {
+		return nil, &ErrorVersionMismatch{Version: version, Expected: ringQueueVersion}
+	}
+	capacity, err := mapping.LoadUint64At(offset + ringQueueCapacityOffset)
+	if err != nil {
+		return nil, err
+	}
+	if capacity > uint64(syspack.MaxInt) {
+		return nil, &ErrorInvalidSize{Size: syspack.MaxSize}
+	}
+	queue, err := openRingQueue(mapping, offset, syspack.Size(capacity))
+	if err != nil {
+		return nil, err
+	}
+	queue.cachedHead = atomic.LoadUint64(queue.head)
+	queue.cachedTail = atomic.LoadUint64(queue.tail)
+	return queue, nil
+}
+
+// Open ring queue in mapping at given offset.
+func openRingQueue(mapping *Mapping, offset syspack.Offset, capacity syspack.Size) (*RingQueue, error) {
+	if capacity < ringQueueMinimum || capacity&(capacity-1) != 0 {
+		return nil, &ErrorInvalidSize{Size: capacity}
+	}
+	data, err := mapping.Direct(offset, offset+syspack.Offset(RingQueueSize(capacity)))
+	if err != nil {
+		return nil, err
+	}
+	queue := &RingQueue{mapping: mapping, data: data[ringQueueHeaderSize:], capacity: uint64(capacity)}
+	if queue.head, err = mapping.writableWord64(offset + ringQueueHeadOffset); err != nil {
+		return nil, err
+	}
+	if queue.tail, err = mapping.writableWord64(offset + ringQueueTailOffset); err != nil {
+		return nil, err
+	}
+	if queue.headSignal, err = mapping.writableWord32(offset + ringQueueHeadSignalOffset); err != nil {
+		return nil, err
+	}
+	if queue.tailSignal, err = mapping.writableWord32(offset + ringQueueTailSignalOffset); err != nil {
+		return nil, err
+	}
+	if queue.writerWaiting, err = mapping.writableWord32(offset + ringQueueWriterWaitingOffset); err != nil {
+		return nil, err
+	}
+	if queue.readerWaiting, err = mapping.writableWord32(offset + ringQueueReaderWaitingOffset); err != nil {
+		return nil, err
+	}
+	return queue, nil
+}
+
+// Get ring capacity.
+func (queue *RingQueue) Capacity() syspack.Size {
+	return syspack.Size(queue.capacity)
+}
+
+// Get maximum message size.
+func (queue *RingQueue) MaxMessageSize() int {
+	return int(queue.capacity/2) - ringQueuePrefixSize
+}
+
+// Try to send message without blocking.
+// Returns false if there is no free space in ring.
+func (queue *RingQueue) TrySend(message []byte) (bool, error) {
+	if len(message) > queue.MaxMessageSize() {
+		return false, &ErrorInvalidSize{Size: syspack.Len(message)}
+	}
+	size := alignRecord(uint64(len(message)))
+	tail := atomic.LoadUint64(queue.tail)
+	position := tail & (queue.capacity - 1)
+	rest := queue.capacity - position
+	required := size
+	if rest < size {
+		required += rest
+	}
+	if tail+required-queue.cachedHead > queue.capacity {
+		queue.cachedHead = atomic.LoadUint64(queue.head)
+		if tail+required-queue.cachedHead > queue.capacity {
+			return false, nil
+		}
+	}
+	if rest < size {
+		binary.LittleEndian.PutUint32(queue.data[position:], ringQueuePadding)
+		queue.mapping.markDirtyBytes(queue.data[position : position+ringQueuePrefixSize])
+		tail += rest
+		position = 0
+	}
+	binary.LittleEndian.PutUint32(queue.data[position:], uint32(len(message)))
+	copy(queue.data[position+ringQueuePrefixSize:], message)
+	queue.mapping.markDirtyBytes(queue.data[position : position+size])
+	atomic.StoreUint64(queue.tail, tail+size)
+	queue.mapping.markDirtyPointer(unsafe.Pointer(queue.tail), 8)
+	atomic.AddUint32(queue.tailSignal, 1)
+	if atomic.LoadUint32(queue.readerWaiting) != 0 {
+		if _, err := wake32(queue.tailSignal, 1); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// Send message, waiting for free space at most timeout.
+// Negative timeout means infinite wait.
+func (queue *RingQueue) Send(message []byte, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		if ok, err := queue.TrySend(message); ok || err != nil {
+			return err
+		}
+		atomic.StoreUint32(queue.writerWaiting, 1)
+		signal := atomic.LoadUint32(queue.headSignal)
+		if ok, err := queue.TrySend(message); ok || err != nil {
+			atomic.StoreUint32(queue.writerWaiting, 0)
+			return err
+		}
+		err := wait32(queue.headSignal, signal, remaining(deadline, timeout))
+		atomic.StoreUint32(queue.writerWaiting, 0)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Try to receive message without blocking.
+// Message is appended to buffer, returns false if there are no messages in ring.
+func (queue *RingQueue) TryReceive(buffer []byte) ([]byte, bool, error) {
+	head := atomic.LoadUint64(queue.head)
+	for {
+		if head == queue.cachedTail {
+			queue.cachedTail = atomic.LoadUint64(queue.tail)
+			if head == queue.cachedTail {
+				return buffer, false, nil
+			}
+		}
+		position := head & (queue.capacity - 1)
+		length := binary.LittleEndian.Uint32(queue.data[position:])
+		if length == ringQueuePadding {
+			head += queue.capacity - position
+			continue
+		}
+		if int(length) > queue.MaxMessageSize() {
+			return buffer, false, &ErrorInvalidSize{Size: syspack.Size(length)}
+		}
+		start := position + ringQueuePrefixSize
+		buffer = append(buffer, queue.data[start:start+uint64(length)]...)
+		atomic.StoreUint64(queue.head, head+alignRecord(uint64(length)))
+		queue.mapping.markDirtyPointer(unsafe.Pointer(queue.head), 8)
+		atomic.AddUint32(queue.headSignal, 1)
+		if atomic.LoadUint32(queue.writerWaiting) != 0 {
+			if _, err := wake32(queue.headSignal, 1); err != nil {
+				return buffer, true, err
+			}
+		}
+		return buffer, true, nil
+	}
+}
+
+// Receive message, waiting for it at most timeout.
+// Message is appended to buffer, negative timeout means infinite wait.
+func (queue *RingQueue) Receive(buffer []byte, timeout time.Duration) ([]byte, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		var ok bool
+		var err error
+		if buffer, ok, err = queue.TryReceive(buffer); ok || err != nil {
+			return buffer, err
+		}
+		atomic.StoreUint32(queue.readerWaiting, 1)
+		signal := atomic.LoadUint32(queue.tailSignal)
+		if buffer, ok, err = queue.TryReceive(buffer); ok || err != nil {
+			atomic.StoreUint32(queue.readerWaiting, 0)
+			return buffer, err
+		}
+		err = wait32(queue.tailSignal, signal, remaining(deadline, timeout))
+		atomic.StoreUint32(queue.readerWaiting, 0)
+		if err != nil {
+			return buffer, err
+		}
+	}
+}
+
+// Get aligned size of record with given payload length.
+func alignRecord(length uint64) uint64 {
+	return (ringQueuePrefixSize + length + ringQueueAlignment - 1) &^ (ringQueueAlignment - 1)
+}
+
+// Get time remaining until deadline.
+// Negative timeout means infinite wait.
+func remaining(deadline time.Time, timeout time.Duration) time.Duration {
+	if timeout < 0 {
+		return timeout
+	}
+	if duration := time.Until(deadline); duration > 0 {
+		return duration
+	}
+	return 0
+}
@@ -0,0 +1,100 @@
+package mmap
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+var testQueueCapacity = syspack.Size(1 << 12)
+
+func makeTestMessage(i int) []byte {
+	message := make([]byte, i%300)
+	for j := range message {
+		message[j] = byte(i + j)
+	}
+	return message
+}
+
+func TestRingQueue(t *testing.T) {
+	mapping, err := makeTestMapping(ModeReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mapping.Close()
+	queue, err := NewRingQueue(mapping, 0, testQueueCapacity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const count = 10000
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < count; i++ {
+			if err := queue.Send(makeTestMessage(i), time.Second); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	var buffer []byte
+	for i := 0; i < count; i++ {
+		if buffer, err = queue.Receive(buffer[:0], time.Second); err != nil {
+			t.Fatal(err)
+		}
+		if message := makeTestMessage(i); bytes.Compare(buffer, message) != 0 {
+			t.Fatalf("message %d must be a %v, %v found", i, message, buffer)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := queue.Receive(nil, time.Millisecond); err == nil {
+		t.Fatal("expected timeout, no error found")
+	} else if _, ok := err.(*ErrorTimeout); !ok {
+		t.Fatalf("expected timeout, [%v] error found", err)
+	}
+}
+
+func TestRingQueueAttach(t *testing.T) {
+	mapping, err := makeTestMapping(ModeReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mapping.Close()
+	if _, err := AttachRingQueue(mapping, 0); err == nil {
+		t.Fatal("expected bad magic, no error found")
+	} else if _, ok := err.(*ErrorBadMagic); !ok {
+		t.Fatalf("expected bad magic, [%v] error found", err)
+	}
+	producer, err := NewRingQueue(mapping, 0, testQueueCapacity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := producer.TrySend(testBuffer); err != nil {
+		t.Fatal(err)
+	} else if !ok {
+		t.Fatal("message must be sent")
+	}
+	consumer, err := AttachRingQueue(mapping, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buffer, ok, err := consumer.TryReceive(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || bytes.Compare(buffer, testBuffer) != 0 {
+		t.Fatalf("buffer must be a %q, %v found", testBuffer, buffer)
+	}
+	if err := mapping.StoreUint32At(ringQueueVersion+1, ringQueueVersionOffset); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AttachRingQueue(mapping, 0); err == nil {
+		t.Fatal("expected version mismatch, no error found")
+	} else if _, ok := err.(*ErrorVersionMismatch); !ok {
+		t.Fatalf("expected version mismatch, [%v] error found", err)
+	}
+}
@@ -0,0 +1,99 @@
+package mmap
+
+import (
+	"io"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+type Section struct {
+	// Bounded window of mapping addressed from zero.
+
+	// Mapping.
+	mapping *Mapping
+
+	// Offset of section in mapping.
+	offset syspack.Offset
+
+	// Section size.
+	size syspack.Size
+}
+
+// Make section of mapping of given size at given offset.
+func NewSection(mapping *Mapping, offset syspack.Offset, size syspack.Size) (*Section, error) {
+	if size == 0 {
+		return nil, &ErrorInvalidSize{Size: size}
+	}
+	if _, err := mapping.Direct(offset, offset+syspack.Offset(size)); err != nil {
+		return nil, err
+	}
+	return &Section{mapping: mapping, offset: offset, size: size}, nil
+}
+
+// Get underlying mapping.
+func (section *Section) Mapping() *Mapping {
+	return section.mapping
+}
+
+// Get offset of section in mapping.
+func (section *Section) Offset() syspack.Offset {
+	return section.offset
+}
+
+// Get section length.
+func (section *Section) Len() int {
+	return int(section.size)
+}
+
+// Get direct byte slice in section offset range [low, high).
+func (section *Section) Direct(low, high syspack.Offset) ([]byte, error) {
+	if low < 0 || low >= syspack.Offset(section.size) {
+		return nil, &ErrorInvalidOffset{Offset: low}
+	}
+	if high < 1 || high > syspack.Offset(section.size) {
+		return nil, &ErrorInvalidOffset{Offset: high}
+	}
+	return section.mapping.Direct(section.offset+low, section.offset+high)
+}
+
+// Get direct byte slice of whole section.
+func (section *Section) Bytes() ([]byte, error) {
+	return section.mapping.Direct(section.offset, section.offset+syspack.Offset(section.size))
+}
+
+// Read len(buffer) bytes from section at given offset.
+func (section *Section) ReadAt(buffer []byte, offset syspack.Offset) (int, error) {
+	data, err := section.Direct(offset, syspack.Offset(section.size))
+	if err != nil {
+		return 0, err
+	}
+	n := copy(buffer, data)
+	if n < len(buffer) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Write len(buffer) bytes to section at given offset.
+func (section *Section) WriteAt(buffer []byte, offset syspack.Offset) (int, error) {
+	if !section.mapping.canWrite {
+		return 0, &ErrorNotAllowed{Operation: "write"}
+	}
+	data, err := section.Direct(offset, syspack.Offset(section.size))
+	if err != nil {
+		return 0, err
+	}
+	n := copy(data, buffer)
+	if n > 0 {
+		section.mapping.markDirty(section.offset+offset, section.offset+offset+syspack.Offset(n))
+	}
+	if n < len(buffer) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Sync section.
+func (section *Section) Sync() error {
+	return section.mapping.SyncRange(section.offset, section.offset+syspack.Offset(section.size))
+}
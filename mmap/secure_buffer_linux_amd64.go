@@ -0,0 +1,137 @@
+package mmap
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+type SecureBuffer struct {
+	// Buffer for secrets in anonymous mapping.
+	// Memory is locked, excluded from core dumps, wiped in forked children
+	// and surrounded by inaccessible guard pages.
+
+	// Mapped address including guard pages.
+	address uintptr
+
+	// Mapped size including guard pages.
+	size syspack.Size
+
+	// Data pages.
+	pages []byte
+
+	// Data.
+	data []byte
+
+	// Buffer is read-only.
+	sealed bool
+}
+
+// Make new secure buffer of given length.
+func NewSecureBuffer(length syspack.Size) (*SecureBuffer, error) {
+	pageSize := syspack.Size(os.Getpagesize())
+	if length == 0 || length > syspack.Size(syspack.MaxInt)-3*pageSize {
+		return nil, &ErrorInvalidSize{Size: length}
+	}
+	pagesSize := (length + pageSize - 1) &^ (pageSize - 1)
+	buffer := &SecureBuffer{size: pagesSize + 2*pageSize}
+	var err error
+	buffer.address, err = syspack.MmapE(
+		0, buffer.size,
+		syscall.PROT_NONE, syscall.MAP_PRIVATE|syscall.MAP_ANONYMOUS,
+		^uintptr(0), 0,
+	)
+	if err != nil {
+		return nil, err
+	}
+	pagesAddress := buffer.address + pageSize
+	if err := buffer.prepare(pagesAddress, pagesSize); err != nil {
+		syspack.Munmap(buffer.address, buffer.size)
+		return nil, err
+	}
+	buffer.pages = makeSlice(pagesAddress, int(pagesSize))
+	buffer.data = buffer.pages[:length:length]
+	runtime.SetFinalizer(buffer, (*SecureBuffer).Destroy)
+	return buffer, nil
+}
+
+// Make data pages accessible and protect them from leaking.
+func (buffer *SecureBuffer) prepare(address uintptr, size syspack.Size) error {
+	if err := syspack.MprotectE(address, size, syscall.PROT_READ|syscall.PROT_WRITE); err != nil {
+		return err
+	}
+	if err := syspack.MadviseE(buffer.address, buffer.size, syspack.MadvDontdump); err != nil {
+		return err
+	}
+	if err := syspack.MadviseE(address, size, syspack.MadvWipeonfork); err != nil {
+		return err
+	}
+	return syspack.MlockE(address, size)
+}
+
+// Get buffer length.
+func (buffer *SecureBuffer) Len() int {
+	return len(buffer.data)
+}
+
+// Get buffer data.
+// Writing to data of sealed buffer causes segmentation fault.
+func (buffer *SecureBuffer) Bytes() []byte {
+	return buffer.data
+}
+
+// Whether is buffer read-only.
+func (buffer *SecureBuffer) Sealed() bool {
+	return buffer.sealed
+}
+
+// Make buffer read-only.
+func (buffer *SecureBuffer) Seal() error {
+	return buffer.protect(true)
+}
+
+// Make buffer writable again.
+func (buffer *SecureBuffer) Unseal() error {
+	return buffer.protect(false)
+}
+
+// Change protection of data pages.
+func (buffer *SecureBuffer) protect(sealed bool) error {
+	if buffer.data == nil {
+		return &ErrorClosed{}
+	}
+	protection := syscall.PROT_READ | syscall.PROT_WRITE
+	if sealed {
+		protection = syscall.PROT_READ
+	}
+	address := buffer.address + syspack.Size(os.Getpagesize())
+	if err := syspack.MprotectE(address, syspack.Len(buffer.pages), protection); err != nil {
+		return err
+	}
+	buffer.sealed = sealed
+	return nil
+}
+
+// Destroy buffer, zeroing its data before unmapping.
+func (buffer *SecureBuffer) Destroy() error {
+	if buffer.data == nil {
+		return &ErrorClosed{}
+	}
+	if buffer.sealed {
+		if err := buffer.Unseal(); err != nil {
+			return err
+		}
+	}
+	for i := range buffer.pages {
+		buffer.pages[i] = 0
+	}
+	if err := syspack.MunmapE(buffer.address, buffer.size); err != nil {
+		return err
+	}
+	buffer.pages = nil
+	buffer.data = nil
+	runtime.SetFinalizer(buffer, nil)
+	return nil
+}
@@ -0,0 +1,56 @@
+package mmap
+
+import (
+	"bytes"
+	"runtime/debug"
+	"testing"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+func writeRecovered(buffer []byte, value byte) (faulted bool) {
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		faulted = recover() != nil
+	}()
+	buffer[0] = value
+	return false
+}
+
+func TestSecureBuffer(t *testing.T) {
+	buffer, err := NewSecureBuffer(syspack.Len(testBuffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buffer.Destroy()
+	copy(buffer.Bytes(), testBuffer)
+	if err := buffer.Seal(); err != nil {
+		t.Fatal(err)
+	}
+	if !writeRecovered(buffer.Bytes(), 'J') {
+		t.Fatal("writing to sealed buffer must fault")
+	}
+	if bytes.Compare(buffer.Bytes(), testBuffer) != 0 {
+		t.Fatalf("buffer must be a %q, %v found", testBuffer, buffer.Bytes())
+	}
+	if err := buffer.Unseal(); err != nil {
+		t.Fatal(err)
+	}
+	if writeRecovered(buffer.Bytes(), 'J') {
+		t.Fatal("writing to unsealed buffer must not fault")
+	}
+	if err := buffer.Seal(); err != nil {
+		t.Fatal(err)
+	}
+	if err := buffer.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+	if buffer.Bytes() != nil {
+		t.Fatal("destroyed buffer must have no data")
+	}
+	if err := buffer.Destroy(); err == nil {
+		t.Fatal("expected closed, no error found")
+	} else if _, ok := err.(*ErrorClosed); !ok {
+		t.Fatalf("expected closed, [%v] error found", err)
+	}
+}
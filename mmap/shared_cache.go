@@ -0,0 +1,490 @@
+package mmap
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+// Shared cache format.
+const (
+	sharedCacheMagic   = 0x4355524c // "LRUC"
+	sharedCacheVersion = 1
+)
+
+// Shared cache header layout.
+// Header is followed by shard headers, then by buckets and entries of every shard.
+const (
+	sharedCacheMagicOffset     = 0
+	sharedCacheVersionOffset   = 4
+	sharedCacheShardsOffset    = 8
+	sharedCacheEntriesOffset   = 12
+	sharedCacheKeySizeOffset   = 16
+	sharedCacheValueSizeOffset = 20
+	sharedCacheHeaderSize      = cacheLineSize
+)
+
+// Shared cache shard header layout.
+// Entry references are one-based indexes inside shard, zero is nil.
+const (
+	cacheShardLockOffset      = 0
+	cacheShardHeadOffset      = 4
+	cacheShardTailOffset      = 8
+	cacheShardFreeOffset      = 12
+	cacheShardCountOffset     = 16
+	cacheShardHitsOffset      = 24
+	cacheShardMissesOffset    = 32
+	cacheShardEvictionsOffset = 40
+	cacheShardInsertsOffset   = 48
+	cacheShardHeaderSize      = cacheLineSize
+)
+
+// Shared cache entry layout.
+// Entry is linked into LRU list by prev and next and into bucket chain by chain.
+const (
+	cacheEntryHashOffset     = 0
+	cacheEntryPrevOffset     = 8
+	cacheEntryNextOffset     = 12
+	cacheEntryChainOffset    = 16
+	cacheEntryKeyLenOffset   = 20
+	cacheEntryValueLenOffset = 24
+	cacheEntryHeaderSize     = 32
+	cacheEntryAlignment      = 8
+	defaultCacheShards       = 16
+)
+
+type SharedCacheOptions struct {
+	// Shared cache options.
+
+	// Total number of entries.
+	Entries int
+
+	// Maximum key length.
+	KeySize int
+
+	// Maximum value length.
+	ValueSize int
+
+	// Number of independently locked shards, power of two, 16 by default.
+	Shards int
+}
+
+type SharedCacheStats struct {
+	// Shared cache statistics.
+
+	// Number of cached entries.
+	Entries uint64
+
+	// Number of successful lookups.
+	Hits uint64
+
+	// Number of failed lookups.
+	Misses uint64
+
+	// Number of entries evicted to make room.
+	Evictions uint64
+
+	// Number of inserted entries.
+	Inserts uint64
+}
+
+type cacheShard struct {
+	// Shared cache shard.
+
+	// Header.
+	header []byte
+
+	// Buckets.
+	buckets []byte
+
+	// Entries.
+	entries []byte
+
+	// Lock.
+	mutex sharedMutex
+}
+
+type SharedCache struct {
+	// Fixed-capacity LRU cache in shared mapping usable from several processes.
+	// Keys are distributed over shards, each shard has its own lock, hash index and LRU list.
+
+	// Mapping.
+	mapping *Mapping
+
+	// Shards.
+	shards []cacheShard
+
+	// Number of entries per shard.
+	entries uint32
+
+	// Number of buckets per shard.
+	buckets uint32
+
+	// Maximum key length.
+	keySize int
+
+	// Maximum value length.
+	valueSize int
+
+	// Entry size.
+	stride int
+}
+
+// Normalize shared cache options.
+func sharedCacheGeometry(options *SharedCacheOptions) (shards, entries, buckets uint32, stride int, err error) {
+	shards = defaultCacheShards
+	if options.Shards != 0 {
+		shards = uint32(options.Shards)
+	}
+	if options.Shards < 0 || shards&(shards-1) != 0 || options.Shards > 1<<16 {
+		return 0, 0, 0, 0, &ErrorInvalidSize{Size: syspack.Size(options.Shards)}
+	}
+	if options.Entries <= 0 || options.Entries > 1<<30 {
+		return 0, 0, 0, 0, &ErrorInvalidSize{Size: syspack.Size(options.Entries)}
+	}
+	if options.KeySize <= 0 || options.ValueSize < 0 || options.KeySize > 1<<20 || options.ValueSize > 1<<20 {
+		return 0, 0, 0, 0, &ErrorInvalidSize{Size: syspack.Size(options.KeySize + options.ValueSize)}
+	}
+	entries = (uint32(options.Entries) + shards - 1) / shards
+	buckets = 1
+	for buckets < entries {
+		buckets <<= 1
+	}
+	stride = (cacheEntryHeaderSize + options.KeySize + options.ValueSize + cacheEntryAlignment - 1) &^ (cacheEntryAlignment - 1)
+	return shards, entries, buckets, stride, nil
+}
+
+// Get size of shard data.
+func cacheShardSize(entries, buckets uint32, stride int) uint64 {
+	return (uint64(buckets)*4+cacheEntryAlignment-1)&^(cacheEntryAlignment-1) + uint64(entries)*uint64(stride)
+}
+
+// Get mapping size required for shared cache with given options.
+func SharedCacheSize(options *SharedCacheOptions) syspack.Size {
+	shards, entries, buckets, stride, err := sharedCacheGeometry(options)
+	if err != nil {
+		return 0
+	}
+	return syspack.Size(sharedCacheHeaderSize + uint64(shards)*(cacheShardHeaderSize+cacheShardSize(entries, buckets, stride)))
+}
+
+// Make new shared cache in mapping at given offset.
+func NewSharedCache(mapping *Mapping, offset syspack.Offset, options *SharedCacheOptions) (*SharedCache, error) {
+	shards, entries, _, _, err := sharedCacheGeometry(options)
+	if err != nil {
+		return nil, err
+	}
+	cache, err := openSharedCache(mapping, offset, shards, entries, options.KeySize, options.ValueSize)
+	if err != nil {
+		return nil, err
+	}
+	for i := range cache.shards {
+		shard := &cache.shards[i]
+		for j := range shard.header {
+			shard.header[j] = 0
+		}
+		for j := range shard.buckets {
+			shard.buckets[j] = 0
+		}
+		for index := uint32(1); index <= cache.entries; index++ {
+			next := index + 1
+			if index == cache.entries {
+				next = 0
+			}
+			binary.LittleEndian.PutUint32(cache.entry(shard, index)[cacheEntryNextOffset:], next)
+		}
+		binary.LittleEndian.PutUint32(shard.header[cacheShardFreeOffset:], 1)
+	}
+	mapping.markDirty(offset, offset+syspack.Offset(SharedCacheSize(options)))
+	data, _ := mapping.Direct(offset, offset+sharedCacheHeaderSize)
+	binary.LittleEndian.PutUint32(data[sharedCacheShardsOffset:], shards)
+	binary.LittleEndian.PutUint32(data[sharedCacheEntriesOffset:], entries)
+	binary.LittleEndian.PutUint32(data[sharedCacheKeySizeOffset:], uint32(options.KeySize))
+	binary.LittleEndian.PutUint32(data[sharedCacheValueSizeOffset:], uint32(options.ValueSize))
+	if err := mapping.StoreUint32At(sharedCacheVersion, offset+sharedCacheVersionOffset); err != nil {
+		return nil, err
+	}
+	if err := mapping.StoreUint32At(sharedCacheMagic, offset+sharedCacheMagicOffset); err != nil {
+		return nil, err
+	}
+	return cache, nil
+}
+
+// Attach to shared cache existing in mapping at given offset.
+func AttachSharedCache(mapping *Mapping, offset syspack.Offset) (*SharedCache, error) {
+	magic, err := mapping.LoadUint32At(offset + sharedCacheMagicOffset)
+	if err != nil {
+		return nil, err
+	}
+	if magic != sharedCacheMagic {
+		return nil, &ErrorBadMagic{Magic: magic}
+	}
+	version, err := mapping.LoadUint32At(offset + sharedCacheVersionOffset)
+	if err != nil {
+		return nil, err
+	}
+	if version != sharedCacheVersion {
+		return nil, &ErrorVersionMismatch{Version: version, Expected: sharedCacheVersion}
+	}
+	data, err := mapping.Direct(offset, offset+sharedCacheHeaderSize)
+	if err != nil {
+		return nil, err
+	}
+	shards := binary.LittleEndian.Uint32(data[sharedCacheShardsOffset:])
+	entries := binary.LittleEndian.Uint32(data[sharedCacheEntriesOffset:])
+	options := &SharedCacheOptions{
+		Entries:   int(entries) * int(shards),
+		KeySize:   int(binary.LittleEndian.Uint32(data[sharedCacheKeySizeOffset:])),
+		ValueSize: int(binary.LittleEndian.Uint32(data[sharedCacheValueSizeOffset:])),
+		Shards:    int(shards),
+	}
+	if _, checkedEntries, _, _, err := sharedCacheGeometry(options); err != nil || checkedEntries != entries {
+		return nil, &ErrorInvalidSize{Size: syspack.Size(entries)}
+	}
+	return openSharedCache(mapping, offset, shards, entries, options.KeySize, options.ValueSize)
+}
+
+// Open shared cache of given geometry in mapping at given offset.
+func openSharedCache(mapping *Mapping, offset syspack.Offset, shards, entries uint32, keySize, valueSize int) (*SharedCache, error) {
+	if offset%cacheEntryAlignment != 0 {
+		return nil, &ErrorUnalignedOffset{Offset: offset}
+	}
+	_, _, buckets, stride, _ := sharedCacheGeometry(&SharedCacheOptions{
+		Entries: int(entries), KeySize: keySize, ValueSize: valueSize, Shards: 1,
+	})
+	options := &SharedCacheOptions{Entries: int(entries) * int(shards), KeySize: keySize, ValueSize: valueSize, Shards: int(shards)}
+	data, err := mapping.Direct(offset, offset+syspack.Offset(SharedCacheSize(options)))
+	if err != nil {
+		return nil, err
+	}
+	if !mapping.canWrite {
+		return nil, &ErrorNotAllowed{Operation: "write"}
+	}
+	cache := &SharedCache{mapping: mapping, entries: entries, buckets: buckets, keySize: keySize, valueSize: valueSize, stride: stride}
+	shardSize := cacheShardSize(entries, buckets, stride)
+	bucketsSize := shardSize - uint64(entries)*uint64(stride)
+	position := uint64(sharedCacheHeaderSize) + uint64(shards)*cacheShardHeaderSize
+	for i := uint64(0); i < uint64(shards); i++ {
+		header := data[sharedCacheHeaderSize+i*cacheShardHeaderSize:][:cacheShardHeaderSize]
+		cache.shards = append(cache.shards, cacheShard{
+			header:  header,
+			buckets: data[position : position+bucketsSize],
+			entries: data[position+bucketsSize : position+shardSize],
+			mutex:   sharedMutex{state: (*uint32)(unsafe.Pointer(&header[cacheShardLockOffset]))},
+		})
+		position += shardSize
+	}
+	return cache, nil
+}
+
+// Get entry of given one-based index in shard.
+func (cache *SharedCache) entry(shard *cacheShard, index uint32) []byte {
+	start := int(index-1) * cache.stride
+	return shard.entries[start : start+cache.stride]
+}
+
+// Get 32-bit field of shard header.
+func (shard *cacheShard) field(offset int) uint32 {
+	return binary.LittleEndian.Uint32(shard.header[offset:])
+}
+
+// Set 32-bit value in cache data.
+func (cache *SharedCache) setUint32(data []byte, value uint32) {
+	binary.LittleEndian.PutUint32(data, value)
+	cache.mapping.markDirtyBytes(data[:4])
+}
+
+// Set 32-bit field of shard header.
+func (cache *SharedCache) setField(shard *cacheShard, offset int, value uint32) {
+	cache.setUint32(shard.header[offset:], value)
+}
+
+// Get pointer to 64-bit statistics counter of shard.
+func (shard *cacheShard) counter(offset int) *uint64 {
+	return (*uint64)(unsafe.Pointer(&shard.header[offset]))
+}
+
+// Increment 64-bit statistics counter of shard.
+func (cache *SharedCache) count(shard *cacheShard, offset int) {
+	counter := shard.counter(offset)
+	atomic.AddUint64(counter, 1)
+	cache.mapping.markDirtyPointer(unsafe.Pointer(counter), 8)
+}
+
+// Get shard and hash of key.
+func (cache *SharedCache) locate(key []byte) (*cacheShard, uint64) {
+	hash := hashBytes(0, key)
+	return &cache.shards[hash&uint64(len(cache.shards)-1)], hash
+}
+
+// Get bucket index of hash.
+func (cache *SharedCache) bucket(hash uint64) int {
+	return int((hash>>32)&uint64(cache.buckets-1)) * 4
+}
+
+// Find entry of key in shard.
+func (cache *SharedCache) find(shard *cacheShard, key []byte, hash uint64) uint32 {
+	index := binary.LittleEndian.Uint32(shard.buckets[cache.bucket(hash):])
+	for index != 0 {
+		entry := cache.entry(shard, index)
+		if binary.LittleEndian.Uint64(entry[cacheEntryHashOffset:]) == hash {
+			keyLength := int(binary.LittleEndian.Uint32(entry[cacheEntryKeyLenOffset:]))
+			if string(entry[cacheEntryHeaderSize:cacheEntryHeaderSize+keyLength]) == string(key) {
+				return index
+			}
+		}
+		index = binary.LittleEndian.Uint32(entry[cacheEntryChainOffset:])
+	}
+	return 0
+}
+
+// Unlink entry from LRU list.
+func (cache *SharedCache) unlink(shard *cacheShard, index uint32) {
+	entry := cache.entry(shard, index)
+	prev := binary.LittleEndian.Uint32(entry[cacheEntryPrevOffset:])
+	next := binary.LittleEndian.Uint32(entry[cacheEntryNextOffset:])
+	if prev != 0 {
+		cache.setUint32(cache.entry(shard, prev)[cacheEntryNextOffset:], next)
+	} else {
+		cache.setField(shard, cacheShardHeadOffset, next)
+	}
+	if next != 0 {
+		cache.setUint32(cache.entry(shard, next)[cacheEntryPrevOffset:], prev)
+	} else {
+		cache.setField(shard, cacheShardTailOffset, prev)
+	}
+}
+
+// Link entry at front of LRU list.
+func (cache *SharedCache) pushFront(shard *cacheShard, index uint32) {
+	entry := cache.entry(shard, index)
+	head := shard.field(cacheShardHeadOffset)
+	cache.setUint32(entry[cacheEntryPrevOffset:], 0)
+	cache.setUint32(entry[cacheEntryNextOffset:], head)
+	if head != 0 {
+		cache.setUint32(cache.entry(shard, head)[cacheEntryPrevOffset:], index)
+	} else {
+		cache.setField(shard, cacheShardTailOffset, index)
+	}
+	cache.setField(shard, cacheShardHeadOffset, index)
+}
+
+// Remove entry from its bucket chain, LRU list and push it to free list.
+func (cache *SharedCache) remove(shard *cacheShard, index uint32) {
+	entry := cache.entry(shard, index)
+	bucket := cache.bucket(binary.LittleEndian.Uint64(entry[cacheEntryHashOffset:]))
+	chain := binary.LittleEndian.Uint32(entry[cacheEntryChainOffset:])
+	if current := binary.LittleEndian.Uint32(shard.buckets[bucket:]); current == index {
+		cache.setUint32(shard.buckets[bucket:], chain)
+	} else {
+		for current != 0 {
+			currentEntry := cache.entry(shard, current)
+			next := binary.LittleEndian.Uint32(currentEntry[cacheEntryChainOffset:])
+			if next == index {
+				cache.setUint32(currentEntry[cacheEntryChainOffset:], chain)
+				break
+			}
+			current = next
+		}
+	}
+	cache.unlink(shard, index)
+	cache.setUint32(entry[cacheEntryNextOffset:], shard.field(cacheShardFreeOffset))
+	cache.setField(shard, cacheShardFreeOffset, index)
+	cache.setField(shard, cacheShardCountOffset, shard.field(cacheShardCountOffset)-1)
+}
+
+// Get value of key appending it to buffer and marking entry as recently used.
+// Returns false if there is no such key.
+func (cache *SharedCache) Get(key, buffer []byte) ([]byte, bool) {
+	shard, hash := cache.locate(key)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	index := cache.find(shard, key, hash)
+	if index == 0 {
+		cache.count(shard, cacheShardMissesOffset)
+		return buffer, false
+	}
+	cache.count(shard, cacheShardHitsOffset)
+	cache.unlink(shard, index)
+	cache.pushFront(shard, index)
+	entry := cache.entry(shard, index)
+	keyLength := int(binary.LittleEndian.Uint32(entry[cacheEntryKeyLenOffset:]))
+	valueLength := int(binary.LittleEndian.Uint32(entry[cacheEntryValueLenOffset:]))
+	start := cacheEntryHeaderSize + keyLength
+	return append(buffer, entry[start:start+valueLength]...), true
+}
+
+// Put value of key evicting the least recently used entry of shard if it is full.
+func (cache *SharedCache) Put(key, value []byte) error {
+	if len(key) > cache.keySize {
+		return &ErrorInvalidSize{Size: syspack.Len(key)}
+	}
+	if len(value) > cache.valueSize {
+		return &ErrorInvalidSize{Size: syspack.Len(value)}
+	}
+	shard, hash := cache.locate(key)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	index := cache.find(shard, key, hash)
+	if index != 0 {
+		cache.unlink(shard, index)
+	} else {
+		if shard.field(cacheShardFreeOffset) == 0 {
+			cache.remove(shard, shard.field(cacheShardTailOffset))
+			cache.count(shard, cacheShardEvictionsOffset)
+		}
+		index = shard.field(cacheShardFreeOffset)
+		entry := cache.entry(shard, index)
+		cache.setField(shard, cacheShardFreeOffset, binary.LittleEndian.Uint32(entry[cacheEntryNextOffset:]))
+		cache.setField(shard, cacheShardCountOffset, shard.field(cacheShardCountOffset)+1)
+		bucket := cache.bucket(hash)
+		binary.LittleEndian.PutUint64(entry[cacheEntryHashOffset:], hash)
+		cache.setUint32(entry[cacheEntryChainOffset:], binary.LittleEndian.Uint32(shard.buckets[bucket:]))
+		cache.setUint32(entry[cacheEntryKeyLenOffset:], uint32(len(key)))
+		copy(entry[cacheEntryHeaderSize:], key)
+		cache.mapping.markDirtyBytes(entry[:cacheEntryHeaderSize+len(key)])
+		cache.setUint32(shard.buckets[bucket:], index)
+		cache.count(shard, cacheShardInsertsOffset)
+	}
+	entry := cache.entry(shard, index)
+	cache.setUint32(entry[cacheEntryValueLenOffset:], uint32(len(value)))
+	copy(entry[cacheEntryHeaderSize+len(key):], value)
+	cache.mapping.markDirtyBytes(entry[cacheEntryHeaderSize+len(key) : cacheEntryHeaderSize+len(key)+len(value)])
+	cache.pushFront(shard, index)
+	return nil
+}
+
+// Delete key.
+// Returns false if there is no such key.
+func (cache *SharedCache) Delete(key []byte) bool {
+	shard, hash := cache.locate(key)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	index := cache.find(shard, key, hash)
+	if index == 0 {
+		return false
+	}
+	cache.remove(shard, index)
+	return true
+}
+
+// Get capacity.
+func (cache *SharedCache) Capacity() int {
+	return int(cache.entries) * len(cache.shards)
+}
+
+// Get statistics summed over shards.
+func (cache *SharedCache) Stats() SharedCacheStats {
+	var stats SharedCacheStats
+	for i := range cache.shards {
+		shard := &cache.shards[i]
+		stats.Entries += uint64(atomic.LoadUint32((*uint32)(unsafe.Pointer(&shard.header[cacheShardCountOffset]))))
+		stats.Hits += atomic.LoadUint64(shard.counter(cacheShardHitsOffset))
+		stats.Misses += atomic.LoadUint64(shard.counter(cacheShardMissesOffset))
+		stats.Evictions += atomic.LoadUint64(shard.counter(cacheShardEvictionsOffset))
+		stats.Inserts += atomic.LoadUint64(shard.counter(cacheShardInsertsOffset))
+	}
+	return stats
+}
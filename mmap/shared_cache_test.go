@@ -0,0 +1,112 @@
+package mmap
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestSharedCache(t *testing.T) {
+	mapping, err := makeTestMapping(ModeReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mapping.Close()
+	options := &SharedCacheOptions{Entries: 256, KeySize: 16, ValueSize: 300, Shards: 4}
+	if SharedCacheSize(options) > testLength {
+		t.Fatalf("cache must fit into %d bytes, %d found", testLength, SharedCacheSize(options))
+	}
+	cache, err := NewSharedCache(mapping, 8, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attached, err := AttachSharedCache(mapping, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const count = 1000
+	var wait sync.WaitGroup
+	for _, writer := range []*SharedCache{cache, attached} {
+		wait.Add(1)
+		go func(writer *SharedCache) {
+			defer wait.Done()
+			for i := 0; i < count; i++ {
+				if err := writer.Put([]byte(fmt.Sprint(i)), makeTestMessage(i)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(writer)
+	}
+	wait.Wait()
+	stats := attached.Stats()
+	if stats.Entries > uint64(cache.Capacity()) || stats.Inserts-stats.Evictions != stats.Entries {
+		t.Fatalf("entries must be bounded by capacity, %+v found", stats)
+	}
+	var buffer []byte
+	found := 0
+	for i := 0; i < count; i++ {
+		value, ok := cache.Get([]byte(fmt.Sprint(i)), buffer[:0])
+		if !ok {
+			continue
+		}
+		if bytes.Compare(value, makeTestMessage(i)) != 0 {
+			t.Fatalf("value of %d must be a test message", i)
+		}
+		buffer = value
+		found++
+	}
+	if uint64(found) != stats.Entries {
+		t.Fatalf("%d entries must be found, %d found", stats.Entries, found)
+	}
+	if stats := cache.Stats(); stats.Hits != uint64(found) || stats.Misses != uint64(count-found) {
+		t.Fatalf("hits and misses must be counted, %+v found", stats)
+	}
+	if !cache.Delete([]byte(fmt.Sprint(count - 1))) {
+		t.Fatal("the most recent key must be deleted")
+	}
+	if _, ok := attached.Get([]byte(fmt.Sprint(count-1)), nil); ok {
+		t.Fatal("deleted key must not be found")
+	}
+	if err := cache.Put(make([]byte, 17), nil); err == nil {
+		t.Fatal("put of too long key must fail")
+	}
+	if _, err := AttachSharedCache(mapping, 0); err == nil {
+		t.Fatal("attach at wrong offset must fail")
+	}
+}
+
+func TestSharedCacheEviction(t *testing.T) {
+	mapping, err := makeTestMapping(ModeReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mapping.Close()
+	cache, err := NewSharedCache(mapping, 0, &SharedCacheOptions{Entries: 4, KeySize: 1, ValueSize: 1, Shards: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range "abcd" {
+		if err := cache.Put([]byte{byte(key)}, []byte{byte(key)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := cache.Get([]byte("a"), nil); !ok {
+		t.Fatal("key must be found")
+	}
+	if err := cache.Put([]byte("e"), []byte("e")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.Get([]byte("b"), nil); ok {
+		t.Fatal("the least recently used key must be evicted")
+	}
+	for _, key := range "acde" {
+		if value, ok := cache.Get([]byte{byte(key)}, nil); !ok || value[0] != byte(key) {
+			t.Fatalf("key %c must be found", key)
+		}
+	}
+	if stats := cache.Stats(); stats.Evictions != 1 || stats.Entries != 4 {
+		t.Fatalf("one eviction must be counted, %+v found", stats)
+	}
+}
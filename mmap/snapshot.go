@@ -0,0 +1,200 @@
+package mmap
+
+import (
+	"encoding/binary"
+	"runtime"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+// Snapshot format.
+const (
+	snapshotMagic   = 0x50414e53 // "SNAP"
+	snapshotVersion = 1
+)
+
+// Snapshot header layout.
+const (
+	snapshotMagicOffset      = 0
+	snapshotVersionOffset    = 4
+	snapshotCapacityOffset   = 8
+	snapshotGenerationOffset = cacheLineSize
+	snapshotHeaderSize       = 2 * cacheLineSize
+)
+
+// Snapshot slot layout.
+// Slot sequence is odd while slot is being written, slot version is the generation it holds.
+const (
+	snapshotSequenceOffset    = 0
+	snapshotSlotVersionOffset = 8
+	snapshotLengthOffset      = 16
+	snapshotDataOffset        = 24
+)
+
+type Snapshot struct {
+	// Versioned blob published by single writer and read by many readers.
+	// Writer fills the inactive one of two slots, readers validate copies with slot sequence.
+
+	// Mapping.
+	mapping *Mapping
+
+	// Slots.
+	slots [2][]byte
+
+	// Blob capacity.
+	capacity uint64
+
+	// Number of published versions.
+	generation *uint64
+}
+
+// Get mapping size required for snapshot of given capacity.
+func SnapshotSize(capacity syspack.Size) syspack.Size {
+	return snapshotHeaderSize + 2*snapshotStride(capacity)
+}
+
+// Get slot stride.
+func snapshotStride(capacity syspack.Size) syspack.Size {
+	return (snapshotDataOffset + capacity + cacheLineSize - 1) &^ (cacheLineSize - 1)
+}
+
+// Make new snapshot in mapping at given offset.
+func NewSnapshot(mapping *Mapping, offset syspack.Offset, capacity syspack.Size) (*Snapshot, error) {
+	if !mapping.CanWrite() {
+		return nil, &ErrorNotAllowed{Operation: "write"}
+	}
+	snapshot, err := openSnapshot(mapping, offset, capacity)
+	if err != nil {
+		return nil, err
+	}
+	atomic.StoreUint64(snapshot.generation, 0)
+	for _, slot := range snapshot.slots {
+		atomic.StoreUint64(snapshotSequence(slot), 0)
+		binary.LittleEndian.PutUint64(slot[snapshotSlotVersionOffset:], 0)
+		binary.LittleEndian.PutUint64(slot[snapshotLengthOffset:], 0)
+	}
+	mapping.markDirty(offset, offset+syspack.Offset(SnapshotSize(capacity)))
+	if err := mapping.StoreUint64At(uint64(capacity), offset+snapshotCapacityOffset); err != nil {
+		return nil, err
+	}
+	if err := mapping.StoreUint32At(snapshotVersion, offset+snapshotVersionOffset); err != nil {
+		return nil, err
+	}
+	if err := mapping.StoreUint32At(snapshotMagic, offset+snapshotMagicOffset); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// Attach to snapshot existing in mapping at given offset.
+// Read-only mapping is sufficient for readers.
+func AttachSnapshot(mapping *Mapping, offset syspack.Offset) (*Snapshot, error) {
+	magic, err := mapping.LoadUint32At(offset + snapshotMagicOffset)
+	if err != nil {
+		return nil, err
+	}
+	if magic != snapshotMagic {
+		return nil, &ErrorBadMagic{Magic: magic}
+	}
+	version, err := mapping.LoadUint32At(offset + snapshotVersionOffset)
+	if err != nil {
+		return nil, err
+	}
+	if version != snapshotVersion {
+		return nil, &ErrorVersionMismatch{Version: version, Expected: snapshotVersion}
+	}
+	capacity, err := mapping.LoadUint64At(offset + snapshotCapacityOffset)
+	if err != nil {
+		return nil, err
+	}
+	if capacity > uint64(syspack.MaxInt) {
+		return nil, &ErrorInvalidSize{Size: syspack.MaxSize}
+	}
+	return openSnapshot(mapping, offset, syspack.Size(capacity))
+}
+
+// Open snapshot in mapping at given offset.
+func openSnapshot(mapping *Mapping, offset syspack.Offset, capacity syspack.Size) (*Snapshot, error) {
+	if capacity > syspack.Size(syspack.MaxInt)/4 {
+		return nil, &ErrorInvalidSize{Size: capacity}
+	}
+	data, err := mapping.Direct(offset, offset+syspack.Offset(SnapshotSize(capacity)))
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &Snapshot{mapping: mapping, capacity: uint64(capacity)}
+	if snapshot.generation, err = mapping.word64(offset + snapshotGenerationOffset); err != nil {
+		return nil, err
+	}
+	stride := snapshotStride(capacity)
+	for i := range snapshot.slots {
+		start := snapshotHeaderSize + syspack.Size(i)*stride
+		snapshot.slots[i] = data[start : start+stride]
+	}
+	return snapshot, nil
+}
+
+// Get snapshot slot sequence.
+func snapshotSequence(slot []byte) *uint64 {
+	return (*uint64)(unsafe.Pointer(&slot[snapshotSequenceOffset]))
+}
+
+// Get blob capacity.
+func (snapshot *Snapshot) Capacity() syspack.Size {
+	return syspack.Size(snapshot.capacity)
+}
+
+// Get latest published version.
+func (snapshot *Snapshot) Version() uint64 {
+	return atomic.LoadUint64(snapshot.generation)
+}
+
+// Publish new version of blob.
+// Only one writer may publish at a time, readers are never blocked.
+func (snapshot *Snapshot) Publish(blob []byte) (uint64, error) {
+	if !snapshot.mapping.CanWrite() {
+		return 0, &ErrorNotAllowed{Operation: "write"}
+	}
+	if uint64(len(blob)) > snapshot.capacity {
+		return 0, &ErrorInvalidSize{Size: syspack.Len(blob)}
+	}
+	generation := atomic.LoadUint64(snapshot.generation) + 1
+	slot := snapshot.slots[generation&1]
+	sequence := snapshotSequence(slot)
+	atomic.AddUint64(sequence, 1)
+	binary.LittleEndian.PutUint64(slot[snapshotSlotVersionOffset:], generation)
+	binary.LittleEndian.PutUint64(slot[snapshotLengthOffset:], uint64(len(blob)))
+	copy(slot[snapshotDataOffset:], blob)
+	atomic.AddUint64(sequence, 1)
+	snapshot.mapping.markDirtyBytes(slot[:snapshotDataOffset+len(blob)])
+	atomic.StoreUint64(snapshot.generation, generation)
+	snapshot.mapping.markDirtyPointer(unsafe.Pointer(snapshot.generation), 8)
+	return generation, nil
+}
+
+// Load consistent copy of latest version of blob.
+// Blob is appended to buffer, zero version means nothing is published yet.
+func (snapshot *Snapshot) Load(buffer []byte) ([]byte, uint64) {
+	for retry := 0; ; retry++ {
+		if retry > 0 {
+			runtime.Gosched()
+		}
+		slot := snapshot.slots[atomic.LoadUint64(snapshot.generation)&1]
+		sequence := snapshotSequence(slot)
+		before := atomic.LoadUint64(sequence)
+		if before&1 != 0 {
+			continue
+		}
+		generation := binary.LittleEndian.Uint64(slot[snapshotSlotVersionOffset:])
+		length := binary.LittleEndian.Uint64(slot[snapshotLengthOffset:])
+		if length > snapshot.capacity {
+			continue
+		}
+		result := append(buffer, slot[snapshotDataOffset:snapshotDataOffset+length]...)
+		if atomic.LoadUint64(sequence) == before {
+			return result, generation
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package mmap
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+func makeTestBlob(version uint64) []byte {
+	return bytes.Repeat([]byte{byte(version)}, int(version%1000))
+}
+
+func TestSnapshot(t *testing.T) {
+	mapping, err := makeTestMapping(ModeReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mapping.Close()
+	writer, err := NewSnapshot(mapping, 0, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buffer, version := writer.Load(nil); version != 0 || len(buffer) != 0 {
+		t.Fatalf("empty snapshot expected, version %d with %v found", version, buffer)
+	}
+	const count = 10000
+	var group sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		reader, err := AttachSnapshot(mapping, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			var buffer []byte
+			var version uint64
+			for version < count {
+				buffer, version = reader.Load(buffer[:0])
+				if blob := makeTestBlob(version); bytes.Compare(buffer, blob) != 0 {
+					t.Errorf("blob of version %d must be a %v, %v found", version, blob, buffer)
+					return
+				}
+			}
+		}()
+	}
+	for version := uint64(1); version <= count; version++ {
+		if published, err := writer.Publish(makeTestBlob(version)); err != nil {
+			t.Fatal(err)
+		} else if published != version {
+			t.Fatalf("version must be a %d, %d found", version, published)
+		}
+	}
+	group.Wait()
+}
@@ -0,0 +1,96 @@
+package mmap
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+type TempFile struct {
+	// Mapped temporary file published atomically under its final name,
+	// so readers never see partially written file.
+
+	// File.
+	file *os.File
+
+	// Mapping.
+	mapping *Mapping
+
+	// Path of named temporary file, empty if file is unnamed.
+	tempPath string
+}
+
+// Create temporary file of given size in directory and map it.
+// File should be created in directory it will be published to.
+func CreateTemp(dir string, size syspack.Size) (*TempFile, error) {
+	if size == 0 || size > syspack.Size(syspack.MaxInt) {
+		return nil, &ErrorInvalidSize{Size: size}
+	}
+	file, tempPath, err := createTempFile(dir)
+	if err != nil {
+		return nil, err
+	}
+	temp := &TempFile{file: file, tempPath: tempPath}
+	if err := file.Truncate(int64(size)); err != nil {
+		temp.Close()
+		return nil, err
+	}
+	if temp.mapping, err = NewMapping(file.Fd(), 0, size, &Options{Mode: ModeReadWrite}); err != nil {
+		temp.Close()
+		return nil, err
+	}
+	return temp, nil
+}
+
+// Get mapping of file.
+func (temp *TempFile) Mapping() *Mapping {
+	return temp.mapping
+}
+
+// Sync file and give it given path replacing existing file.
+// Mapping is closed, directory of path is synced to make publication durable.
+func (temp *TempFile) Publish(path string) error {
+	if temp.file == nil {
+		return &ErrorClosed{}
+	}
+	if err := temp.mapping.syncAll(); err != nil {
+		return err
+	}
+	if err := temp.file.Sync(); err != nil {
+		return err
+	}
+	if err := temp.mapping.Close(); err != nil {
+		return err
+	}
+	temp.mapping = nil
+	file := temp.file
+	temp.file = nil
+	if err := publishTempFile(file, temp.tempPath, path); err != nil {
+		return err
+	}
+	temp.tempPath = ""
+	return syncDir(filepath.Dir(path))
+}
+
+// Discard file if it was not published.
+func (temp *TempFile) Close() error {
+	var err error
+	if temp.mapping != nil {
+		err = temp.mapping.Close()
+		temp.mapping = nil
+	}
+	if temp.file != nil {
+		if closeErr := temp.file.Close(); err == nil {
+			err = closeErr
+		}
+		temp.file = nil
+	}
+	if temp.tempPath != "" {
+		if removeErr := os.Remove(temp.tempPath); err == nil {
+			err = removeErr
+		}
+		temp.tempPath = ""
+	}
+	return err
+}
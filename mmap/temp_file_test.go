@@ -0,0 +1,64 @@
+package mmap
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+var testTempDir = filepath.Join(os.TempDir(), "test.temp")
+
+func TestTempFile(t *testing.T) {
+	os.RemoveAll(testTempDir)
+	if err := os.MkdirAll(testTempDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testTempDir)
+	path := filepath.Join(testTempDir, "published")
+	for i := 0; i < 2; i++ {
+		temp, err := CreateTemp(testTempDir, syspack.Len(testBuffer))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := temp.Mapping().WriteAt(testBuffer[i:], 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(path); i == 0 && !os.IsNotExist(err) {
+			t.Fatal("file must not be visible before publication")
+		}
+		if err := temp.Publish(path); err != nil {
+			t.Fatal(err)
+		}
+		if err := temp.Publish(path); err == nil {
+			t.Fatal("second publication must fail")
+		}
+		if err := temp.Close(); err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Compare(data[:len(testBuffer)-i], testBuffer[i:]) != 0 {
+			t.Fatalf("published file %d must contain written data", i)
+		}
+	}
+	temp, err := CreateTemp(testTempDir, syspack.Len(testBuffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := temp.Close(); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := ioutil.ReadDir(testTempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "published" {
+		t.Fatalf("only published file must remain, %d entries found", len(entries))
+	}
+}
@@ -0,0 +1,27 @@
+package mmap
+
+import (
+	"time"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+// Wait while 32-bit unsigned integer in mapping at given offset is equal to value.
+// Wait may return spuriously, so caller must check the value again.
+// Negative timeout means infinite wait.
+func (mapping *Mapping) WaitUint32At(value uint32, offset syspack.Offset, timeout time.Duration) error {
+	word, err := mapping.word32(offset)
+	if err != nil {
+		return err
+	}
+	return wait32(word, value, timeout)
+}
+
+// Wake at most count waiters of 32-bit unsigned integer in mapping at given offset.
+func (mapping *Mapping) WakeUint32At(offset syspack.Offset, count int) (int, error) {
+	word, err := mapping.word32(offset)
+	if err != nil {
+		return 0, err
+	}
+	return wake32(word, count)
+}
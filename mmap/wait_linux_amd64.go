@@ -0,0 +1,35 @@
+package mmap
+
+import (
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/alexeymaximov/syspack"
+)
+
+// Wait while 32-bit word is equal to value.
+func wait32(word *uint32, value uint32, timeout time.Duration) error {
+	var timespec *syscall.Timespec
+	if timeout >= 0 {
+		value := syscall.NsecToTimespec(int64(timeout))
+		timespec = &value
+	}
+	_, err := syspack.Futex(uintptr(unsafe.Pointer(word)), syspack.FutexWait, value, timespec)
+	switch err {
+	case nil, syscall.EAGAIN, syscall.EINTR:
+		return nil
+	case syscall.ETIMEDOUT:
+		return &ErrorTimeout{}
+	}
+	return os.NewSyscallError(syspack.SymbolFutex, err)
+}
+
+// Wake at most count waiters of 32-bit word.
+func wake32(word *uint32, count int) (int, error) {
+	if count < 0 || count > int(syspack.MaxDword>>1) {
+		count = int(syspack.MaxDword >> 1)
+	}
+	return syspack.FutexE(uintptr(unsafe.Pointer(word)), syspack.FutexWake, syspack.Dword(count), nil)
+}
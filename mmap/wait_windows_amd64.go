@@ -0,0 +1,34 @@
+package mmap
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Maximum polling delay.
+const maxWaitDelay = time.Millisecond
+
+// Wait while 32-bit word is equal to value.
+// There is no cross-process address wait on Windows, so word is polled.
+func wait32(word *uint32, value uint32, timeout time.Duration) error {
+	var deadline time.Time
+	if timeout >= 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	for delay := time.Microsecond; atomic.LoadUint32(word) == value; {
+		if timeout >= 0 && !time.Now().Before(deadline) {
+			return &ErrorTimeout{}
+		}
+		time.Sleep(delay)
+		if delay < maxWaitDelay {
+			delay <<= 1
+		}
+	}
+	return nil
+}
+
+// Wake at most count waiters of 32-bit word.
+// Waiters are polling, so there is nothing to do.
+func wake32(word *uint32, count int) (int, error) {
+	return 0, nil
+}
@@ -3,22 +3,146 @@ package syspack
 import (
 	"os"
 	"syscall"
+	"unsafe"
 )
 
 const (
-	SymbolMlock   = "mlock"
-	SymbolMmap    = "mmap"
-	SymbolMsync   = "msync"
-	SymbolMunlock = "munlock"
-	SymbolMunmap  = "munmap"
+	SymbolFutex       = "futex"
+	SymbolLinkat      = "linkat"
+	SymbolMadvise     = "madvise"
+	SymbolMemfdCreate = "memfd_create"
+	SymbolMlock       = "mlock"
+	SymbolMmap        = "mmap"
+	SymbolMprotect    = "mprotect"
+	SymbolMsync       = "msync"
+	SymbolMunlock     = "munlock"
+	SymbolMunmap      = "munmap"
 )
 
+const (
+	SysMemfdCreate = 319
+)
+
+const (
+	FutexWait = 0
+	FutexWake = 1
+)
+
+const (
+	MadvNormal     = 0x0
+	MadvDontneed   = 0x4
+	MadvHugepage   = 0xe
+	MadvNohugepage = 0xf
+	MadvDontdump   = 0x10
+	MadvDodump     = 0x11
+	MadvWipeonfork = 0x12
+	MadvKeeponfork = 0x13
+)
+
+const (
+	MfdCloexec = 0x1
+)
+
+const (
+	AtFdcwd         = -0x64
+	AtSymlinkFollow = 0x400
+)
+
+const (
+	OTmpfile = 0x410000
+)
+
+func Futex(addr uintptr, op int, val Dword, timeout *syscall.Timespec) (int, error) {
+	if op < 0 {
+		return 0, syscall.EINVAL
+	}
+	result, _, err := syscall.Syscall6(
+		syscall.SYS_FUTEX,
+		addr, uintptr(op), uintptr(val),
+		uintptr(unsafe.Pointer(timeout)), 0, 0,
+	)
+	if err != 0 {
+		return 0, Errno(err)
+	}
+	return int(result), nil
+}
+func FutexE(addr uintptr, op int, val Dword, timeout *syscall.Timespec) (int, error) {
+	result, err := Futex(addr, op, val, timeout)
+	if err != nil {
+		return result, os.NewSyscallError(SymbolFutex, err)
+	}
+	return result, nil
+}
+
+func Linkat(oldDirfd int, oldPath string, newDirfd int, newPath string, flags int) error {
+	if flags < 0 {
+		return syscall.EINVAL
+	}
+	oldPathPtr, err := syscall.BytePtrFromString(oldPath)
+	if err != nil {
+		return err
+	}
+	newPathPtr, err := syscall.BytePtrFromString(newPath)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall6(
+		syscall.SYS_LINKAT,
+		uintptr(oldDirfd), uintptr(unsafe.Pointer(oldPathPtr)),
+		uintptr(newDirfd), uintptr(unsafe.Pointer(newPathPtr)),
+		uintptr(flags), 0,
+	)
+	if errno != 0 {
+		return Errno(errno)
+	}
+	return nil
+}
+func LinkatE(oldDirfd int, oldPath string, newDirfd int, newPath string, flags int) error {
+	return os.NewSyscallError(SymbolLinkat, Linkat(oldDirfd, oldPath, newDirfd, newPath, flags))
+}
+
+func Madvise(addr uintptr, length Size, advice int) error {
+	if advice < 0 {
+		return syscall.EINVAL
+	}
+	_, _, err := syscall.Syscall(syscall.SYS_MADVISE, addr, length, uintptr(advice))
+	if err != 0 {
+		return Errno(err)
+	}
+	return nil
+}
+func MadviseE(addr uintptr, length Size, advice int) error {
+	return os.NewSyscallError(SymbolMadvise, Madvise(addr, length, advice))
+}
+
+func MemfdCreate(name string, flags int) (uintptr, error) {
+	if flags < 0 {
+		return 0, syscall.EINVAL
+	}
+	namePtr, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return 0, err
+	}
+	fd, _, errno := syscall.Syscall(SysMemfdCreate, uintptr(unsafe.Pointer(namePtr)), uintptr(flags), 0)
+	if errno != 0 {
+		return 0, Errno(errno)
+	}
+	return fd, nil
+}
+func MemfdCreateE(name string, flags int) (uintptr, error) {
+	fd, err := MemfdCreate(name, flags)
+	if err != nil {
+		return fd, os.NewSyscallError(SymbolMemfdCreate, err)
+	}
+	return fd, nil
+}
+
 func Mlock(addr uintptr, length Size) error {
 	_, _, err := syscall.Syscall(syscall.SYS_MLOCK, addr, length, 0)
 	if err != 0 {
 		return Errno(err)
 	}
-	return err
+	return nil
 }
 func MlockE(addr uintptr, length Size) error {
 	return os.NewSyscallError(SymbolMlock, Mlock(addr, length))
@@ -42,6 +166,20 @@ func MmapE(addr uintptr, length Size, prot, flags int, fd uintptr, offset Offset
 	return memory, nil
 }
 
+func Mprotect(addr uintptr, length Size, prot int) error {
+	if prot < 0 {
+		return syscall.EINVAL
+	}
+	_, _, err := syscall.Syscall(syscall.SYS_MPROTECT, addr, length, uintptr(prot))
+	if err != 0 {
+		return Errno(err)
+	}
+	return nil
+}
+func MprotectE(addr uintptr, length Size, prot int) error {
+	return os.NewSyscallError(SymbolMprotect, Mprotect(addr, length, prot))
+}
+
 func Msync(addr uintptr, length Size) error {
 	_, _, err := syscall.Syscall(syscall.SYS_MSYNC, addr, length, syscall.MS_SYNC)
 	if err != 0 {
@@ -0,0 +1,22 @@
+package syspack
+
+import (
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestMlock(t *testing.T) {
+	size := Size(os.Getpagesize())
+	addr, err := MmapE(0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE|syscall.MAP_ANON, ^uintptr(0), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer MunmapE(addr, size)
+	if err := Mlock(addr, size); err != nil {
+		t.Fatalf("successful mlock must return nil, %v found", err)
+	}
+	if err := MunlockE(addr, size); err != nil {
+		t.Fatal(err)
+	}
+}